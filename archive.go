@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var archiveUrl = &url.URL{Path: "/archive/"}
+
+func yearArchiveUrl(year int) *url.URL {
+	return &url.URL{Path: fmt.Sprintf("/archive/%04d/", year)}
+}
+
+func monthArchiveUrl(year int, month time.Month) *url.URL {
+	return &url.URL{Path: fmt.Sprintf("/archive/%04d/%02d/", year, month)}
+}
+
+// byPublicationDate sorts posts newest first.
+type byPublicationDate []*AllPostsPost
+
+func (posts byPublicationDate) Len() int      { return len(posts) }
+func (posts byPublicationDate) Swap(i, j int) { posts[i], posts[j] = posts[j], posts[i] }
+func (posts byPublicationDate) Less(i, j int) bool {
+	return posts[j].publishedAt.Before(posts[i].publishedAt)
+}
+
+type ArchiveMonth struct {
+	Year  int
+	Month time.Month
+	Url   *url.URL
+	Posts []*AllPostsPost
+
+	// YearUrl points to the archive of the year this month belongs
+	// to.
+	YearUrl *url.URL
+}
+
+func (month *ArchiveMonth) Name() string {
+	return fmt.Sprintf("%s %d", month.Month, month.Year)
+}
+
+func (month *ArchiveMonth) RenderHTML(page *Pagination) []byte {
+	return renderTemplate("views/archive_month.html", &struct {
+		*ArchiveMonth
+		Page *Pagination
+	}{month, page})
+}
+
+type ArchiveYear struct {
+	Year   int
+	Url    *url.URL
+	Months []*ArchiveMonth
+	Posts  []*AllPostsPost
+
+	byMonth map[time.Month]*ArchiveMonth
+}
+
+func (year *ArchiveYear) Len() int { return len(year.Months) }
+func (year *ArchiveYear) Swap(i, j int) {
+	year.Months[i], year.Months[j] = year.Months[j], year.Months[i]
+}
+func (year *ArchiveYear) Less(i, j int) bool {
+	return year.Months[i].Month > year.Months[j].Month
+}
+
+func (year *ArchiveYear) addPost(post *AllPostsPost) {
+	monthNumber := post.publishedAt.Month()
+	month := year.byMonth[monthNumber]
+	if month == nil {
+		month = &ArchiveMonth{
+			Year:    year.Year,
+			Month:   monthNumber,
+			Url:     monthArchiveUrl(year.Year, monthNumber),
+			Posts:   []*AllPostsPost{},
+			YearUrl: year.Url,
+		}
+		year.byMonth[monthNumber] = month
+		year.Months = append(year.Months, month)
+		sort.Sort(year)
+	}
+
+	month.Posts = append(month.Posts, post)
+	sort.Sort(byPublicationDate(month.Posts))
+	year.Posts = append(year.Posts, post)
+	sort.Sort(byPublicationDate(year.Posts))
+}
+
+func (year *ArchiveYear) ByMonth(month time.Month) *ArchiveMonth {
+	return year.byMonth[month]
+}
+
+func (year *ArchiveYear) RenderHTML(page *Pagination) []byte {
+	return renderTemplate("views/archive_year.html", &struct {
+		*ArchiveYear
+		Page *Pagination
+	}{year, page})
+}
+
+// ArchiveView groups posts by the year and month they have been
+// published in.
+type ArchiveView struct {
+	Years []*ArchiveYear
+
+	allPosts *AllPostsView
+	byYear   map[int]*ArchiveYear
+}
+
+func NewArchiveView(allPosts *AllPostsView) *ArchiveView {
+	return &ArchiveView{
+		Years:    []*ArchiveYear{},
+		allPosts: allPosts,
+		byYear:   map[int]*ArchiveYear{},
+	}
+}
+
+func (view *ArchiveView) Len() int           { return len(view.Years) }
+func (view *ArchiveView) Swap(i, j int)      { view.Years[i], view.Years[j] = view.Years[j], view.Years[i] }
+func (view *ArchiveView) Less(i, j int) bool { return view.Years[i].Year > view.Years[j].Year }
+
+func (view *ArchiveView) HandleEvent(event Event) error {
+	switch evt := event.(type) {
+	case *PostPublishedEvent:
+		if post := view.allPosts.ById(evt.PostId); post != nil {
+			view.addPost(post)
+		}
+	}
+
+	return nil
+}
+
+func (view *ArchiveView) addPost(post *AllPostsPost) {
+	yearNumber := post.publishedAt.Year()
+	year := view.byYear[yearNumber]
+	if year == nil {
+		year = &ArchiveYear{
+			Year:    yearNumber,
+			Url:     yearArchiveUrl(yearNumber),
+			Months:  []*ArchiveMonth{},
+			Posts:   []*AllPostsPost{},
+			byMonth: map[time.Month]*ArchiveMonth{},
+		}
+		view.byYear[yearNumber] = year
+		view.Years = append(view.Years, year)
+		sort.Sort(view)
+	}
+
+	year.addPost(post)
+}
+
+// ByYear returns the archive of the year given as a string in a URL,
+// or nil if nothing has been published in that year.
+func (view *ArchiveView) ByYear(year string) *ArchiveYear {
+	number, err := strconv.Atoi(year)
+	if err != nil || len(year) != 4 {
+		return nil
+	}
+
+	return view.byYear[number]
+}
+
+// ByMonth returns the archive of the month given as strings in a URL,
+// or nil if nothing has been published in that month.
+func (view *ArchiveView) ByMonth(year, month string) *ArchiveMonth {
+	archive := view.ByYear(year)
+	number, err := strconv.Atoi(month)
+	if archive == nil || err != nil || len(month) != 2 {
+		return nil
+	}
+
+	return archive.ByMonth(time.Month(number))
+}
+
+func (view *ArchiveView) RenderHTML() []byte {
+	return renderTemplate("views/archive.html", view)
+}
@@ -0,0 +1,45 @@
+package main_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dhamidi/blog"
+)
+
+func TestArchiveView_GroupsPostsByYearAndMonth(t *testing.T) {
+	allPosts := &main.AllPostsView{}
+	archive := main.NewArchiveView(allPosts)
+	publish := func(title string, at time.Time) {
+		evt := &main.PostPublishedEvent{PostId: main.Id(), Title: title, PublishedAt: at}
+		allPosts.HandleEvent(evt)
+		archive.HandleEvent(evt)
+	}
+
+	publish("first", time.Date(2025, time.December, 24, 12, 0, 0, 0, time.UTC))
+	publish("second", time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC))
+	publish("third", time.Date(2026, time.October, 3, 12, 0, 0, 0, time.UTC))
+	publish("fourth", time.Date(2026, time.October, 17, 12, 0, 0, 0, time.UTC))
+
+	if len(archive.Years) != 2 || archive.Years[0].Year != 2026 {
+		t.Fatalf("Expected 2026 to come first, got %#v", archive.Years)
+	}
+
+	month := archive.ByMonth("2026", "10")
+	if month == nil {
+		t.Fatal("Expected an archive for October 2026")
+	}
+	if len(month.Posts) != 2 || month.Posts[0].Title != "fourth" {
+		t.Fatalf("Expected newest post of October first, got %#v", month.Posts)
+	}
+	if url := month.Url.String(); url != "/archive/2026/10/" {
+		t.Fatalf("Expected /archive/2026/10/, got %s", url)
+	}
+
+	if year := archive.ByYear("2026"); len(year.Posts) != 3 || len(year.Months) != 2 {
+		t.Fatalf("Expected three posts in two months in 2026, got %#v", year)
+	}
+	if archive.ByMonth("2026", "11") != nil || archive.ByYear("26") != nil {
+		t.Fatal("Expected no archive for months without posts")
+	}
+}
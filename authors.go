@@ -0,0 +1,508 @@
+package main
+
+import (
+	"html/template"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// minPasswordLength is the minimum number of characters of an author's
+// password.
+const minPasswordLength = 8
+
+// unusablePasswordHash is a bcrypt hash that no password matches.
+var unusablePasswordHash = []byte("$2a$10$AAAAAAAAAAAAAAAAAAAAAOAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA")
+
+// hashPassword returns the bcrypt hash of password.
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func authorUrl(login string) *url.URL {
+	return &url.URL{Path: "/authors/" + login + ".html"}
+}
+
+type authorAccount struct {
+	id           string
+	login        string
+	passwordHash []byte
+	roles        map[string]bool
+	deactivated  bool
+	twoFactor    bool
+}
+
+// AllAuthors keeps track of the logins and credentials of all authors.
+type AllAuthors struct {
+	byLogin map[string]*authorAccount
+	byId    map[string]*authorAccount
+}
+
+func (all *AllAuthors) New() Aggregate {
+	return &Author{
+		all: all,
+	}
+}
+
+func (all *AllAuthors) HandleEvent(event Event) error {
+	if all.byLogin == nil {
+		all.byLogin = map[string]*authorAccount{}
+	}
+	if all.byId == nil {
+		all.byId = map[string]*authorAccount{}
+	}
+
+	switch evt := event.(type) {
+	case *AuthorRegisteredEvent:
+		account := &authorAccount{
+			id:           evt.AuthorId,
+			login:        evt.Login,
+			passwordHash: []byte(evt.PasswordHash),
+			roles:        map[string]bool{},
+		}
+		all.byLogin[evt.Login] = account
+		all.byId[evt.AuthorId] = account
+	case *AuthorRoleGrantedEvent:
+		if account := all.byId[evt.AuthorId]; account != nil {
+			account.roles[evt.Role] = true
+		}
+	case *AuthorRoleRevokedEvent:
+		if account := all.byId[evt.AuthorId]; account != nil {
+			delete(account.roles, evt.Role)
+		}
+	case *AuthorPasswordChangedEvent:
+		if account := all.byId[evt.AuthorId]; account != nil {
+			account.passwordHash = []byte(evt.PasswordHash)
+		}
+	case *AuthorDeactivatedEvent:
+		if account := all.byId[evt.AuthorId]; account != nil {
+			account.deactivated = true
+		}
+	case *TwoFactorEnabledEvent:
+		if account := all.byId[evt.AuthorId]; account != nil {
+			account.twoFactor = true
+		}
+	case *TwoFactorDisabledEvent:
+		if account := all.byId[evt.AuthorId]; account != nil {
+			account.twoFactor = false
+		}
+	}
+
+	return nil
+}
+
+// UniqueLogin returns true if login is neither used by another author
+// nor by the administrator configured through the environment.
+func (all *AllAuthors) UniqueLogin(login string) bool {
+	if admin := os.Getenv("BLOG_ADMIN_USER"); admin != "" && login == admin {
+		return false
+	}
+
+	_, taken := all.byLogin[login]
+	return !taken
+}
+
+// Authenticate returns the principal for the author with login, if
+// password is correct and the author has not been deactivated.
+func (all *AllAuthors) Authenticate(login, password string) *Principal {
+	if bcrypt.CompareHashAndPassword(all.PasswordHash(login), []byte(password)) != nil {
+		return nil
+	}
+
+	return all.PrincipalFor(login)
+}
+
+// PasswordHash returns the password hash of the author with login.  If
+// there is no such author, a hash no password matches is returned, so
+// that the password is still compared and logins cannot be guessed
+// from the time it takes.
+func (all *AllAuthors) PasswordHash(login string) []byte {
+	if account := all.byLogin[login]; account != nil {
+		return account.passwordHash
+	}
+
+	return unusablePasswordHash
+}
+
+// PrincipalFor returns the principal for the author with login, or nil
+// if there is no such author or the author has been deactivated.
+func (all *AllAuthors) PrincipalFor(login string) *Principal {
+	account := all.byLogin[login]
+	if account == nil || account.deactivated {
+		return nil
+	}
+
+	return &Principal{
+		AuthorId: account.id,
+		Login:    account.login,
+		Roles:    sortedRoles(account.roles),
+	}
+}
+
+// TwoFactorEnabled returns true if the author with authorId has to
+// give a code from an authenticator app when logging in.
+func (all *AllAuthors) TwoFactorEnabled(authorId string) bool {
+	account := all.byId[authorId]
+	return account != nil && account.twoFactor
+}
+
+// Author is somebody who writes posts.  Authors can only change their
+// own posts, unless they have been granted a role allowing more.
+type Author struct {
+	all         *AllAuthors
+	id          string
+	roles       map[string]bool
+	deactivated bool
+
+	twoFactorSecret string
+	lastTOTPStep    int64
+	recoveryCodes   map[string]bool
+
+	// twoFactorFailures holds the times of invalid codes given since
+	// the last valid one.
+	twoFactorFailures []time.Time
+}
+
+func (author *Author) HandleEvent(event Event) error {
+	switch evt := event.(type) {
+	case *AuthorRegisteredEvent:
+		author.id = evt.AuthorId
+		author.roles = map[string]bool{}
+	case *AuthorRoleGrantedEvent:
+		author.roles[evt.Role] = true
+	case *AuthorRoleRevokedEvent:
+		delete(author.roles, evt.Role)
+	case *AuthorDeactivatedEvent:
+		author.deactivated = true
+	case *TwoFactorEnabledEvent:
+		author.twoFactorSecret = evt.Secret
+		author.lastTOTPStep = evt.Step
+		author.recoveryCodes = map[string]bool{}
+		for _, hash := range evt.RecoveryCodes {
+			author.recoveryCodes[hash] = true
+		}
+	case *TwoFactorUsedEvent:
+		if evt.Step > author.lastTOTPStep {
+			author.lastTOTPStep = evt.Step
+		}
+		delete(author.recoveryCodes, evt.RecoveryCode)
+		author.twoFactorFailures = nil
+	case *TwoFactorFailedEvent:
+		author.twoFactorFailures = append(author.twoFactorFailures, evt.FailedAt)
+	case *TwoFactorDisabledEvent:
+		author.twoFactorSecret = ""
+		author.recoveryCodes = nil
+		author.twoFactorFailures = nil
+	}
+
+	return nil
+}
+
+func (author *Author) HandleCommand(command Command) (*Events, error) {
+	switch cmd := command.(type) {
+	case *RegisterAuthorCommand:
+		return author.register(cmd)
+	case *UpdateAuthorProfileCommand:
+		return author.updateProfile(cmd)
+	case *ChangePasswordCommand:
+		return author.changePassword(cmd)
+	case *DeactivateAuthorCommand:
+		return author.deactivate(cmd)
+	case *GrantRoleCommand:
+		return author.grantRole(cmd)
+	case *RevokeRoleCommand:
+		return author.revokeRole(cmd)
+	case *EnableTwoFactorCommand:
+		return author.enableTwoFactor(cmd)
+	case *VerifyTwoFactorCommand:
+		return author.verifyTwoFactor(cmd)
+	case *DisableTwoFactorCommand:
+		return author.disableTwoFactor(cmd)
+	}
+
+	return NoEvents, nil
+}
+
+func (author *Author) register(cmd *RegisterAuthorCommand) (*Events, error) {
+	verr := ValidationError{}
+	if cmd.Login == "" {
+		verr.Add("Login", ErrEmpty)
+	} else if !author.all.UniqueLogin(cmd.Login) {
+		verr.Add("Login", ErrNotUnique)
+	}
+	if cmd.Name == "" {
+		verr.Add("Name", ErrEmpty)
+	}
+	if len(cmd.Password) < minPasswordLength {
+		verr.Add("Password", ErrTooShort)
+	}
+	for _, role := range cmd.Roles {
+		if !validRole(role) {
+			verr.Add("Roles", ErrUnsupported)
+		}
+	}
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
+	}
+
+	hash, err := hashPassword(cmd.Password)
+	if err != nil {
+		return NoEvents, err
+	}
+
+	now := time.Now()
+	registered := &AuthorRegisteredEvent{
+		AuthorId:     Id(),
+		Login:        cmd.Login,
+		Name:         cmd.Name,
+		Email:        cmd.Email,
+		PasswordHash: hash,
+		RegisteredAt: now,
+	}
+	events := ListOfEvents(registered)
+	for _, role := range cmd.Roles {
+		events.Append(&AuthorRoleGrantedEvent{
+			AuthorId:  registered.AuthorId,
+			Role:      role,
+			GrantedAt: now,
+		})
+	}
+
+	return events, nil
+}
+
+func (author *Author) updateProfile(cmd *UpdateAuthorProfileCommand) (*Events, error) {
+	verr := ValidationError{}
+	if cmd.AuthorId != author.id || author.deactivated {
+		verr.Add("Author", ErrNotFound)
+	}
+	if cmd.Name == "" {
+		verr.Add("Name", ErrEmpty)
+	}
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
+	}
+
+	return ListOfEvents(&AuthorProfileUpdatedEvent{
+		AuthorId:  author.id,
+		Name:      cmd.Name,
+		Email:     cmd.Email,
+		Bio:       cmd.Bio,
+		UpdatedAt: time.Now(),
+	}), nil
+}
+
+func (author *Author) changePassword(cmd *ChangePasswordCommand) (*Events, error) {
+	verr := ValidationError{}
+	if cmd.AuthorId != author.id || author.deactivated {
+		verr.Add("Author", ErrNotFound)
+	}
+	if len(cmd.Password) < minPasswordLength {
+		verr.Add("Password", ErrTooShort)
+	}
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
+	}
+
+	hash, err := hashPassword(cmd.Password)
+	if err != nil {
+		return NoEvents, err
+	}
+
+	return ListOfEvents(&AuthorPasswordChangedEvent{
+		AuthorId:     author.id,
+		PasswordHash: hash,
+		ChangedAt:    time.Now(),
+	}), nil
+}
+
+func (author *Author) deactivate(cmd *DeactivateAuthorCommand) (*Events, error) {
+	if cmd.AuthorId != author.id {
+		return NoEvents, ValidationError{}.Add("Author", ErrNotFound)
+	}
+	if author.deactivated {
+		return NoEvents, nil
+	}
+
+	return ListOfEvents(&AuthorDeactivatedEvent{
+		AuthorId:      author.id,
+		DeactivatedAt: time.Now(),
+	}), nil
+}
+
+func (author *Author) grantRole(cmd *GrantRoleCommand) (*Events, error) {
+	verr := ValidationError{}
+	if cmd.AuthorId != author.id || author.deactivated {
+		verr.Add("Author", ErrNotFound)
+	}
+	if !validRole(cmd.Role) {
+		verr.Add("Role", ErrUnsupported)
+	}
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
+	}
+	if author.roles[cmd.Role] {
+		return NoEvents, nil
+	}
+
+	return ListOfEvents(&AuthorRoleGrantedEvent{
+		AuthorId:  author.id,
+		Role:      cmd.Role,
+		GrantedAt: time.Now(),
+	}), nil
+}
+
+func (author *Author) revokeRole(cmd *RevokeRoleCommand) (*Events, error) {
+	if cmd.AuthorId != author.id {
+		return NoEvents, ValidationError{}.Add("Author", ErrNotFound)
+	}
+	if !author.roles[cmd.Role] {
+		return NoEvents, nil
+	}
+
+	return ListOfEvents(&AuthorRoleRevokedEvent{
+		AuthorId:  author.id,
+		Role:      cmd.Role,
+		RevokedAt: time.Now(),
+	}), nil
+}
+
+type AuthorsAuthor struct {
+	Id          string
+	Login       string
+	Name        string
+	Email       string
+	Bio         string
+	BioHTML     template.HTML
+	Url         *url.URL
+	Roles       []string
+	Deactivated bool
+
+	// TwoFactor is true if the author has to give a code from an
+	// authenticator app when logging in.
+	TwoFactor          bool
+	TwoFactorEnabledAt time.Time
+	RecoveryCodesLeft  int
+
+	// Posts lists the posts written by the author, most recent
+	// first.
+	Posts []*AllPostsPost
+
+	roles map[string]bool
+}
+
+func (author *AuthorsAuthor) update(name, email, bio string) {
+	author.Name = name
+	author.Email = email
+	author.Bio = bio
+	author.BioHTML = textToHTML(bio, false)
+}
+
+func (author *AuthorsAuthor) RenderHTML() []byte {
+	return renderTemplate("views/author.html", author)
+}
+
+// AuthorsView maintains the profiles of all authors and attaches them
+// to the posts they have written.
+type AuthorsView struct {
+	Collection []*AuthorsAuthor
+
+	allPosts *AllPostsView
+	byId     map[string]*AuthorsAuthor
+	byLogin  map[string]*AuthorsAuthor
+}
+
+func NewAuthorsView(allPosts *AllPostsView) *AuthorsView {
+	return &AuthorsView{
+		Collection: []*AuthorsAuthor{},
+		allPosts:   allPosts,
+		byId:       map[string]*AuthorsAuthor{},
+		byLogin:    map[string]*AuthorsAuthor{},
+	}
+}
+
+func (view *AuthorsView) HandleEvent(event Event) error {
+	switch evt := event.(type) {
+	case *AuthorRegisteredEvent:
+		author := &AuthorsAuthor{
+			Id:    evt.AuthorId,
+			Login: evt.Login,
+			Url:   authorUrl(evt.Login),
+			Posts: []*AllPostsPost{},
+			roles: map[string]bool{},
+		}
+		author.update(evt.Name, evt.Email, "")
+		author.Roles = sortedRoles(author.roles)
+		view.byId[author.Id] = author
+		view.byLogin[author.Login] = author
+		view.Collection = append(view.Collection, author)
+		sort.Slice(view.Collection, func(i, j int) bool {
+			return view.Collection[i].Name < view.Collection[j].Name
+		})
+	case *AuthorProfileUpdatedEvent:
+		if author := view.byId[evt.AuthorId]; author != nil {
+			author.update(evt.Name, evt.Email, evt.Bio)
+		}
+	case *AuthorRoleGrantedEvent:
+		if author := view.byId[evt.AuthorId]; author != nil {
+			author.roles[evt.Role] = true
+			author.Roles = sortedRoles(author.roles)
+		}
+	case *AuthorRoleRevokedEvent:
+		if author := view.byId[evt.AuthorId]; author != nil {
+			delete(author.roles, evt.Role)
+			author.Roles = sortedRoles(author.roles)
+		}
+	case *AuthorDeactivatedEvent:
+		if author := view.byId[evt.AuthorId]; author != nil {
+			author.Deactivated = true
+		}
+	case *TwoFactorEnabledEvent:
+		if author := view.byId[evt.AuthorId]; author != nil {
+			author.TwoFactor = true
+			author.TwoFactorEnabledAt = evt.EnabledAt
+			author.RecoveryCodesLeft = len(evt.RecoveryCodes)
+		}
+	case *TwoFactorUsedEvent:
+		if author := view.byId[evt.AuthorId]; author != nil && evt.RecoveryCode != "" {
+			author.RecoveryCodesLeft--
+		}
+	case *TwoFactorDisabledEvent:
+		if author := view.byId[evt.AuthorId]; author != nil {
+			author.TwoFactor = false
+			author.RecoveryCodesLeft = 0
+		}
+	case *PostPublishedEvent:
+		author, post := view.byId[evt.AuthorId], view.allPosts.ById(evt.PostId)
+		if author == nil || post == nil {
+			return nil
+		}
+		post.Author = author
+		author.Posts = append(author.Posts, post)
+		sort.Slice(author.Posts, func(i, j int) bool {
+			return author.Posts[j].publishedAt.Before(author.Posts[i].publishedAt)
+		})
+	}
+
+	return nil
+}
+
+func (view *AuthorsView) ById(id string) *AuthorsAuthor {
+	return view.byId[id]
+}
+
+func (view *AuthorsView) ByLogin(login string) *AuthorsAuthor {
+	return view.byLogin[login]
+}
+
+func (view *AuthorsView) RenderHTML() []byte {
+	return renderTemplate("views/admin_authors.html", view)
+}
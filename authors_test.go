@@ -0,0 +1,112 @@
+package main_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/dhamidi/blog"
+)
+
+func TestAuthor_Register_RequiresUniqueLoginAndPassword(t *testing.T) {
+	all := &main.AllAuthors{}
+	all.HandleEvent(&main.AuthorRegisteredEvent{AuthorId: main.Id(), Login: "jane"})
+
+	cmd := &main.RegisterAuthorCommand{Login: "Jane", Name: "Jane Doe", Password: "short"}
+	cmd.Sanitize()
+	_, err := all.New().HandleCommand(cmd)
+
+	if err == nil {
+		t.Fatal("Expected an error.")
+	}
+
+	verr := err.(main.ValidationError)
+	if verr.Get("Login") != main.ErrNotUnique {
+		t.Fatalf("Expected Login to be %s, got %s", main.ErrNotUnique, verr.Get("Login"))
+	}
+	if verr.Get("Password") != main.ErrTooShort {
+		t.Fatalf("Expected Password to be %s, got %s", main.ErrTooShort, verr.Get("Password"))
+	}
+}
+
+func TestAuthor_Register_RejectsAdministratorLogin(t *testing.T) {
+	os.Setenv("BLOG_ADMIN_USER", "admin")
+	defer os.Unsetenv("BLOG_ADMIN_USER")
+
+	cmd := &main.RegisterAuthorCommand{Login: "admin", Name: "Admin", Password: "correct horse"}
+	_, err := (&main.AllAuthors{}).New().HandleCommand(cmd)
+
+	verr, invalid := err.(main.ValidationError)
+	if !invalid || verr.Get("Login") != main.ErrNotUnique {
+		t.Fatalf("Expected Login to be %s, got %v", main.ErrNotUnique, err)
+	}
+}
+
+func TestAllAuthors_Authenticate(t *testing.T) {
+	all := &main.AllAuthors{}
+	events, err := all.New().HandleCommand(&main.RegisterAuthorCommand{
+		Login:    "jane",
+		Name:     "Jane Doe",
+		Password: "correct horse",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registered := events.Items()[0].(*main.AuthorRegisteredEvent)
+	all.HandleEvent(registered)
+
+	if registered.PasswordHash == "correct horse" {
+		t.Fatal("Password stored in plain text.")
+	}
+	if all.Authenticate("jane", "wrong password") != nil {
+		t.Fatal("Authenticated with a wrong password.")
+	}
+	principal := all.Authenticate("jane", "correct horse")
+	if principal == nil || principal.AuthorId != registered.AuthorId {
+		t.Fatalf("Expected principal for %s, got %#v", registered.AuthorId, principal)
+	}
+
+	all.HandleEvent(&main.AuthorDeactivatedEvent{AuthorId: registered.AuthorId})
+	if all.Authenticate("jane", "correct horse") != nil {
+		t.Fatal("Deactivated author authenticated.")
+	}
+}
+
+func TestAuthor_GrantRole(t *testing.T) {
+	registered := &main.AuthorRegisteredEvent{AuthorId: main.Id(), Login: "jane"}
+	all := &main.AllAuthors{}
+	author := all.New()
+	author.HandleEvent(registered)
+
+	if _, err := author.HandleCommand(&main.GrantRoleCommand{AuthorId: registered.AuthorId, Role: "owner"}); err == nil {
+		t.Fatal("Expected an error for an unknown role.")
+	}
+
+	events, err := author.HandleCommand(&main.GrantRoleCommand{AuthorId: registered.AuthorId, Role: main.RoleModerator})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events.ApplyTo(author)
+
+	events, err = author.HandleCommand(&main.GrantRoleCommand{AuthorId: registered.AuthorId, Role: main.RoleModerator})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events.Len() != 0 {
+		t.Fatalf("Expected no events for granting a role twice, got %d", events.Len())
+	}
+}
+
+func TestPrincipal_May(t *testing.T) {
+	moderator := &main.Principal{AuthorId: main.Id(), Roles: []string{main.RoleModerator}}
+	admin := &main.Principal{Roles: []string{main.RoleAdmin}}
+
+	if !moderator.May(main.PermModerate) || !moderator.May(main.PermWritePosts) {
+		t.Fatal("Moderator cannot moderate or write posts.")
+	}
+	if moderator.May(main.PermEditAllPosts) || moderator.May(main.PermManageUsers) {
+		t.Fatal("Moderator has permissions of other roles.")
+	}
+	if !admin.May(main.PermManageUsers) {
+		t.Fatal("Admin cannot manage users.")
+	}
+}
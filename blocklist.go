@@ -0,0 +1,168 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// BlocklistId is the id of the single stream holding all changes to the
+// blocklist.
+const BlocklistId = "blocklist"
+
+// Blocklist keeps track of the email addresses and domains that are not
+// allowed to comment.
+type Blocklist struct {
+	emails  map[string]time.Time
+	domains map[string]time.Time
+}
+
+func NewBlocklist() *Blocklist {
+	return &Blocklist{
+		emails:  map[string]time.Time{},
+		domains: map[string]time.Time{},
+	}
+}
+
+func (blocklist *Blocklist) New() Aggregate {
+	return &BlocklistChange{
+		blocklist: blocklist,
+	}
+}
+
+func (blocklist *Blocklist) HandleEvent(event Event) error {
+	switch evt := event.(type) {
+	case *EmailBlockedEvent:
+		blocklist.emails[evt.Email] = evt.BlockedAt
+	case *EmailUnblockedEvent:
+		delete(blocklist.emails, evt.Email)
+	case *DomainBlockedEvent:
+		blocklist.domains[evt.Domain] = evt.BlockedAt
+	case *DomainUnblockedEvent:
+		delete(blocklist.domains, evt.Domain)
+	}
+
+	return nil
+}
+
+// IsBlocked returns true if email or its domain are blocked.
+func (blocklist *Blocklist) IsBlocked(email string) bool {
+	email = normalizeEmail(email)
+	if _, blocked := blocklist.emails[email]; blocked {
+		return true
+	}
+
+	_, blocked := blocklist.domains[emailDomain(email)]
+	return blocked
+}
+
+func (blocklist *Blocklist) Emails() []string {
+	return sortedKeys(blocklist.emails)
+}
+
+func (blocklist *Blocklist) Domains() []string {
+	return sortedKeys(blocklist.domains)
+}
+
+func (blocklist *Blocklist) RenderHTML() []byte {
+	return renderTemplate("views/admin_spam.html", blocklist)
+}
+
+// BlocklistChange handles the commands for changing the blocklist.
+type BlocklistChange struct {
+	blocklist *Blocklist
+}
+
+func (change *BlocklistChange) HandleEvent(event Event) error { return nil }
+
+func (change *BlocklistChange) HandleCommand(command Command) (*Events, error) {
+	switch cmd := command.(type) {
+	case *BlockEmailCommand:
+		return change.blockEmail(cmd)
+	case *UnblockEmailCommand:
+		return change.unblockEmail(cmd)
+	case *BlockDomainCommand:
+		return change.blockDomain(cmd)
+	case *UnblockDomainCommand:
+		return change.unblockDomain(cmd)
+	}
+
+	return NoEvents, nil
+}
+
+func (change *BlocklistChange) blockEmail(cmd *BlockEmailCommand) (*Events, error) {
+	verr := ValidationError{}
+	if cmd.Email == "" {
+		verr.Add("Email", ErrEmpty)
+	} else if _, blocked := change.blocklist.emails[cmd.Email]; blocked {
+		verr.Add("Email", ErrNotUnique)
+	}
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
+	}
+
+	return ListOfEvents(&EmailBlockedEvent{
+		Email:     cmd.Email,
+		BlockedAt: time.Now(),
+	}), nil
+}
+
+func (change *BlocklistChange) unblockEmail(cmd *UnblockEmailCommand) (*Events, error) {
+	if _, blocked := change.blocklist.emails[cmd.Email]; !blocked {
+		return NoEvents, ValidationError{}.Add("Email", ErrNotFound)
+	}
+
+	return ListOfEvents(&EmailUnblockedEvent{
+		Email:       cmd.Email,
+		UnblockedAt: time.Now(),
+	}), nil
+}
+
+func (change *BlocklistChange) blockDomain(cmd *BlockDomainCommand) (*Events, error) {
+	verr := ValidationError{}
+	if cmd.Domain == "" {
+		verr.Add("Domain", ErrEmpty)
+	} else if _, blocked := change.blocklist.domains[cmd.Domain]; blocked {
+		verr.Add("Domain", ErrNotUnique)
+	}
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
+	}
+
+	return ListOfEvents(&DomainBlockedEvent{
+		Domain:    cmd.Domain,
+		BlockedAt: time.Now(),
+	}), nil
+}
+
+func (change *BlocklistChange) unblockDomain(cmd *UnblockDomainCommand) (*Events, error) {
+	if _, blocked := change.blocklist.domains[cmd.Domain]; !blocked {
+		return NoEvents, ValidationError{}.Add("Domain", ErrNotFound)
+	}
+
+	return ListOfEvents(&DomainUnblockedEvent{
+		Domain:      cmd.Domain,
+		UnblockedAt: time.Now(),
+	}), nil
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// emailDomain returns the part of email after the @.
+func emailDomain(email string) string {
+	return email[strings.LastIndex(email, "@")+1:]
+}
+
+func sortedKeys(m map[string]time.Time) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
@@ -4,8 +4,13 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/dhamidi/blog/eventstore"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type Application struct {
@@ -14,18 +19,61 @@ type Application struct {
 	replaying bool
 
 	types struct {
-		posts *Posts
+		posts     *Posts
+		series    *AllSeries
+		blocklist *Blocklist
+		media     *AllMedia
+		pages     *AllPages
+		authors   *AllAuthors
+		sessions  *Sessions
 	}
 
 	mailer Mailer
 
+	signer *Signer
+
+	media *MediaStore
+
 	observers []EventHandler
 
 	processors []EventHandler
 
+	expiry *CommentExpiryProcessor
+
+	guard *CommentGuard
+
+	// logins limits the attempts to log in from the same address
+	// and for the same login.
+	logins struct {
+		byIP    *RateLimiter
+		byLogin *RateLimiter
+	}
+
+	lock sync.Mutex
+
+	// pageSize is the number of posts listed per page.
+	pageSize int
+
+	// navigation holds the configured links shown on every page.
+	navigation struct {
+		links  []*NavigationLink
+		social []*NavigationLink
+	}
+
 	views struct {
-		allPosts *AllPostsView
-		sitemap  *Sitemap
+		allPosts  *AllPostsView
+		tags      *TagsView
+		series    *SeriesView
+		revisions *RevisionsView
+		media     *MediaLibraryView
+		pages     *PagesView
+		authors   *AuthorsView
+		comments  *CommentModerationView
+		sitemap   *Sitemap
+		feed      *Feed
+		search    *SearchIndex
+		archive   *ArchiveView
+		related   *RelatedPostsView
 	}
 
 	tls struct {
@@ -38,12 +86,88 @@ type Application struct {
 func (app *Application) Init() error {
 	app.Store.RegisterType(&PostPublishedEvent{})
 	app.Store.RegisterType(&PostRewordedEvent{})
+	app.Store.RegisterType(&PostTaggedEvent{})
+	app.Store.RegisterType(&PostUntaggedEvent{})
 	app.Store.RegisterType(&PostCommentedEvent{})
 	app.Store.RegisterType(&PostCommentAuthenticatedEvent{})
+	app.Store.RegisterType(&CommentAuthenticationRequestedEvent{})
+	app.Store.RegisterType(&CommentExpiredEvent{})
+	app.Store.RegisterType(&CommentEditedEvent{})
+	app.Store.RegisterType(&CommentWithdrawnEvent{})
+	app.Store.RegisterType(&PostCommentApprovedEvent{})
+	app.Store.RegisterType(&PostCommentRejectedEvent{})
+	app.Store.RegisterType(&PostCommentHiddenEvent{})
+	app.Store.RegisterType(&PostCommentDeletedEvent{})
+	app.Store.RegisterType(&SeriesCreatedEvent{})
+	app.Store.RegisterType(&PostAddedToSeriesEvent{})
+	app.Store.RegisterType(&SeriesReorderedEvent{})
+	app.Store.RegisterType(&EmailBlockedEvent{})
+	app.Store.RegisterType(&EmailUnblockedEvent{})
+	app.Store.RegisterType(&DomainBlockedEvent{})
+	app.Store.RegisterType(&DomainUnblockedEvent{})
+	app.Store.RegisterType(&MediaUploadedEvent{})
+	app.Store.RegisterType(&PageCreatedEvent{})
+	app.Store.RegisterType(&PageEditedEvent{})
+	app.Store.RegisterType(&PageDeletedEvent{})
+	app.Store.RegisterType(&AuthorRegisteredEvent{})
+	app.Store.RegisterType(&AuthorProfileUpdatedEvent{})
+	app.Store.RegisterType(&AuthorDeactivatedEvent{})
+	app.Store.RegisterType(&AuthorRoleGrantedEvent{})
+	app.Store.RegisterType(&AuthorRoleRevokedEvent{})
+	app.Store.RegisterType(&AuthorPasswordChangedEvent{})
+	app.Store.RegisterType(&TwoFactorEnabledEvent{})
+	app.Store.RegisterType(&TwoFactorUsedEvent{})
+	app.Store.RegisterType(&TwoFactorFailedEvent{})
+	app.Store.RegisterType(&TwoFactorDisabledEvent{})
+	app.Store.RegisterType(&SessionStartedEvent{})
+	app.Store.RegisterType(&SessionEndedEvent{})
+
+	postsConfig := PostsConfig{
+		RequireApproval:      os.Getenv("BLOG_MODERATE_COMMENTS") != "",
+		EditWindow:           durationFromEnv("BLOG_COMMENT_EDIT_WINDOW", 15*time.Minute),
+		AuthenticationExpiry: durationFromEnv("BLOG_COMMENT_EXPIRY", 48*time.Hour),
+		MaxResends:           3,
+	}
 
-	app.types.posts = &Posts{}
+	secret := os.Getenv("BLOG_SECRET")
+	if secret == exampleSecret {
+		return fmt.Errorf("Application.Init: BLOG_SECRET is still set to %q, use a long random string instead\n", secret)
+	}
+	if exampleAdminPassword() {
+		return fmt.Errorf("Application.Init: the admin password is still %q, use \"blog passwd\" to set BLOG_ADMIN_PASS_HASH\n", examplePassword)
+	}
+	app.signer = NewSigner(secret)
+	app.pageSize = intFromEnv("BLOG_PAGE_SIZE", defaultPageSize)
+	app.types.posts = NewPosts(postsConfig)
+	app.types.series = &AllSeries{}
+	app.types.blocklist = NewBlocklist()
+	app.types.media = &AllMedia{}
+	app.types.pages = &AllPages{}
+	app.types.authors = &AllAuthors{}
+	app.types.sessions = &Sessions{}
 	app.views.allPosts = &AllPostsView{}
-	app.views.sitemap = NewSitemap(app.views.allPosts)
+	app.views.tags = NewTagsView(app.views.allPosts)
+	app.views.series = NewSeriesView(app.views.allPosts)
+	app.views.revisions = NewRevisionsView(app.views.allPosts, os.Getenv("BLOG_SHOW_CHANGES") != "")
+	app.views.media = NewMediaLibraryView()
+	app.views.pages = NewPagesView()
+	app.views.authors = NewAuthorsView(app.views.allPosts)
+	app.views.comments = NewCommentModerationView(app.views.allPosts)
+	app.views.sitemap = NewSitemap(app.views.allPosts, app.views.tags)
+	app.views.feed = NewFeed(app.views.authors)
+	app.views.search = NewSearchIndex(app.views.allPosts)
+	app.views.archive = NewArchiveView(app.views.allPosts)
+	app.views.related = NewRelatedPostsView(app.views.allPosts)
+
+	mediaDir := os.Getenv("BLOG_MEDIA_DIR")
+	if mediaDir == "" {
+		mediaDir = "_media"
+	}
+	if media, err := NewMediaStore(mediaDir); err != nil {
+		return err
+	} else {
+		app.media = media
+	}
 
 	if mailer, err := NewSystemMailer("/usr/sbin/sendmail"); err != nil {
 		log.Fatal(err)
@@ -58,17 +182,88 @@ func (app *Application) Init() error {
 		&PostCommentProcessor{
 			mailer: app.mailer,
 			posts:  app.views.allPosts,
+			signer: app.signer,
+			config: postsConfig,
 			useTls: app.tls.enabled,
 		},
 	}
 
+	app.expiry = NewCommentExpiryProcessor(postsConfig.AuthenticationExpiry)
+
+	spamFilter := NewBayesianFilter(10)
+	app.guard = NewCommentGuard(app.signer, app.types.blocklist, spamFilter)
+	templateFuncs["commentFormToken"] = app.guard.FormToken
+	app.logins.byIP = NewRateLimiter(20, 15*time.Minute)
+	app.logins.byLogin = NewRateLimiter(10, 15*time.Minute)
+	blackfridayAuthor.images = app.views.media
+	app.navigation.links = navigationFromEnv("BLOG_NAVIGATION", defaultNavigation)
+	app.navigation.social = socialLinksFromEnv("BLOG_SOCIAL_LINKS")
+	templateFuncs["navigation"] = app.Navigation
+	templateFuncs["socialLinks"] = app.SocialLinks
+
 	app.observers = []EventHandler{
 		app.types.posts,
+		app.types.series,
+		app.types.blocklist,
+		app.types.pages,
+		app.types.authors,
+		app.types.sessions,
 		app.views.allPosts,
+		app.views.tags,
+		app.views.series,
+		app.views.revisions,
+		app.views.media,
+		app.views.pages,
+		app.views.authors,
+		app.views.comments,
 		app.views.sitemap,
+		app.views.feed,
+		app.views.search,
+		app.views.archive,
+		app.views.related,
+		app.expiry,
+		spamFilter,
 	}
 
-	return app.replayState()
+	if err := app.replayState(); err != nil {
+		return err
+	}
+
+	go app.expiry.Run(app, time.Minute)
+
+	return nil
+}
+
+// durationFromEnv parses the environment variable name as a duration,
+// returning def if it is not set.
+func durationFromEnv(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("%s: %s", name, err)
+	}
+
+	return d
+}
+
+// intFromEnv parses the environment variable name as a positive
+// integer, returning def if it is not set.
+func intFromEnv(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		log.Fatalf("%s: not a positive integer: %q", name, value)
+	}
+
+	return n
 }
 
 func (app *Application) replayState() error {
@@ -89,6 +284,9 @@ func (app *Application) load(typ Type, id string) (Aggregate, error) {
 	aggregate := typ.New()
 	events, err := app.Store.LoadStream(id)
 
+	if eventstore.IsNotFound(err) {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -101,6 +299,115 @@ func (app *Application) load(typ Type, id string) (Aggregate, error) {
 }
 
 func (app *Application) HandleCommand(command Command) (*Events, error) {
+	// Logging in takes the lock itself, so that comparing password
+	// hashes does not hold up other requests.
+	if cmd, ok := command.(*LogInCommand); ok {
+		cmd.Sanitize()
+		return app.logIn(cmd)
+	}
+
+	app.lock.Lock()
+	defer app.lock.Unlock()
+
+	return app.handleCommand(command)
+}
+
+// HandleCommandAs handles command on behalf of principal, if principal
+// is allowed to do so.
+func (app *Application) HandleCommandAs(principal *Principal, command Command) (*Events, error) {
+	app.lock.Lock()
+	defer app.lock.Unlock()
+
+	if err := app.authorize(principal, command); err != nil {
+		return NoEvents, err
+	}
+
+	return app.handleCommand(command)
+}
+
+// authorize returns ErrForbidden unless one of principal's roles grants
+// the permission required for command.  Authors can always change
+// their own posts and profile.
+func (app *Application) authorize(principal *Principal, command Command) error {
+	if cmd, ok := command.(*PublishPostCommand); ok {
+		cmd.AuthorId = principal.AuthorId
+	}
+	// Authenticator apps can only be enrolled by their owner.
+	if cmd, ok := command.(*EnableTwoFactorCommand); ok && cmd.AuthorId != principal.AuthorId {
+		return ErrForbidden
+	}
+	// Whoever is logged in as the owner needs their second factor for
+	// turning it off.
+	if cmd, ok := command.(*DisableTwoFactorCommand); ok && cmd.AuthorId == principal.AuthorId {
+		cmd.requireCode = true
+	}
+	if principal.May(requiredPermission(command)) {
+		return nil
+	}
+
+	switch cmd := command.(type) {
+	case *RewordPostCommand:
+		return app.authorizePost(principal, cmd.PostId)
+	case *RevertPostCommand:
+		return app.authorizePost(principal, cmd.PostId)
+	case *TagPostCommand:
+		return app.authorizePost(principal, cmd.PostId)
+	case *UntagPostCommand:
+		return app.authorizePost(principal, cmd.PostId)
+	case *UpdateAuthorProfileCommand:
+		if cmd.AuthorId == principal.AuthorId {
+			return nil
+		}
+	case *ChangePasswordCommand:
+		if cmd.AuthorId == principal.AuthorId {
+			return nil
+		}
+	case *EnableTwoFactorCommand:
+		return nil
+	case *DisableTwoFactorCommand:
+		if cmd.AuthorId == principal.AuthorId {
+			return nil
+		}
+	}
+
+	return ErrForbidden
+}
+
+// authorizePost returns ErrForbidden unless principal is the author of
+// the post with postId.
+func (app *Application) authorizePost(principal *Principal, postId string) error {
+	if principal.AuthorId == "" || app.types.posts.AuthorOf(postId) != principal.AuthorId {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+// PrincipalFor returns the principal for the author with login, or nil
+// if there is no such author.
+func (app *Application) PrincipalFor(login string) *Principal {
+	return app.types.authors.PrincipalFor(login)
+}
+
+// adminPrincipal returns the principal for the administrator configured
+// through the environment, who has the admin role, or nil if login is
+// not the administrator's.
+func adminPrincipal(login string) *Principal {
+	if admin := os.Getenv("BLOG_ADMIN_USER"); admin == "" || login != admin {
+		return nil
+	}
+
+	return &Principal{Login: login, Roles: []string{RoleAdmin}}
+}
+
+// adminCredentials returns a signature of the administrator's password
+// configured through the environment, which changes whenever the
+// password does.
+func (app *Application) adminCredentials() string {
+	return app.signer.Sign("admin-credentials", os.Getenv("BLOG_ADMIN_PASS_HASH"), os.Getenv("BLOG_ADMIN_PASS"))
+}
+
+func (app *Application) handleCommand(command Command) (*Events, error) {
 	command.Sanitize()
 
 	switch cmd := command.(type) {
@@ -110,8 +417,72 @@ func (app *Application) HandleCommand(command Command) (*Events, error) {
 		return app.previewPost(cmd)
 	case *RewordPostCommand:
 		return app.rewordPost(cmd)
+	case *RevertPostCommand:
+		return app.update(app.types.posts, cmd.PostId, cmd)
+	case *TagPostCommand:
+		return app.update(app.types.posts, cmd.PostId, cmd)
+	case *UntagPostCommand:
+		return app.update(app.types.posts, cmd.PostId, cmd)
+	case *ResendCommentAuthenticationCommand:
+		return app.resendCommentAuthentication(cmd)
+	case *ExpireCommentCommand:
+		return app.update(app.types.posts, cmd.PostId, cmd)
+	case *EditCommentCommand:
+		return app.editComment(cmd)
+	case *WithdrawCommentCommand:
+		return app.changeOwnComment(cmd.CommentId, cmd.Signature, cmd)
+	case *ApproveCommentCommand:
+		return app.update(app.types.posts, cmd.PostId, cmd)
+	case *RejectCommentCommand:
+		return app.update(app.types.posts, cmd.PostId, cmd)
+	case *HideCommentCommand:
+		return app.update(app.types.posts, cmd.PostId, cmd)
+	case *DeleteCommentCommand:
+		return app.update(app.types.posts, cmd.PostId, cmd)
+	case *CreateSeriesCommand:
+		return app.createSeries(cmd)
+	case *AddPostToSeriesCommand:
+		return app.update(app.types.series, cmd.SeriesId, cmd)
+	case *ReorderSeriesCommand:
+		return app.update(app.types.series, cmd.SeriesId, cmd)
 	case *CommentOnPostCommand:
 		return app.commentOnPost(cmd)
+	case *BlockEmailCommand:
+		return app.changeBlocklist(cmd)
+	case *UnblockEmailCommand:
+		return app.changeBlocklist(cmd)
+	case *BlockDomainCommand:
+		return app.changeBlocklist(cmd)
+	case *UnblockDomainCommand:
+		return app.changeBlocklist(cmd)
+	case *CreatePageCommand:
+		return app.createPage(cmd)
+	case *EditPageCommand:
+		return app.update(app.types.pages, cmd.PageId, cmd)
+	case *DeletePageCommand:
+		return app.update(app.types.pages, cmd.PageId, cmd)
+	case *UploadMediaCommand:
+		return app.uploadMedia(cmd)
+	case *RegisterAuthorCommand:
+		return app.registerAuthor(cmd)
+	case *UpdateAuthorProfileCommand:
+		return app.update(app.types.authors, cmd.AuthorId, cmd)
+	case *DeactivateAuthorCommand:
+		return app.update(app.types.authors, cmd.AuthorId, cmd)
+	case *GrantRoleCommand:
+		return app.update(app.types.authors, cmd.AuthorId, cmd)
+	case *RevokeRoleCommand:
+		return app.update(app.types.authors, cmd.AuthorId, cmd)
+	case *ChangePasswordCommand:
+		return app.update(app.types.authors, cmd.AuthorId, cmd)
+	case *EnableTwoFactorCommand:
+		return app.update(app.types.authors, cmd.AuthorId, cmd)
+	case *DisableTwoFactorCommand:
+		return app.disableTwoFactor(cmd)
+	case *LogOutCommand:
+		return app.update(app.types.sessions, cmd.SessionId, cmd)
+	case *AuthorCommentOnPostCommand:
+		return app.update(app.types.posts, cmd.PostId, cmd)
 	case *PostAuthenticateCommentCommand:
 		return app.authenticateComment(cmd)
 	}
@@ -148,7 +519,194 @@ func (app *Application) rewordPost(cmd *RewordPostCommand) (*Events, error) {
 	}
 }
 
+// update loads the aggregate of type typ identified by id and lets it
+// handle cmd.
+func (app *Application) update(typ Type, id string, cmd Command) (*Events, error) {
+	aggregate, err := app.load(typ, id)
+
+	if err == ErrNotFound {
+		return NoEvents, err
+	}
+	if err != nil {
+		return NoEvents, fmt.Errorf("Application.load: %s\n", err)
+	}
+
+	events, err := aggregate.HandleCommand(cmd)
+	if err != nil {
+		return NoEvents, err
+	} else {
+		return events, app.process(events)
+	}
+}
+
+func (app *Application) createSeries(cmd *CreateSeriesCommand) (*Events, error) {
+	series := app.types.series.New()
+	events, err := series.HandleCommand(cmd)
+
+	if err != nil {
+		return NoEvents, err
+	} else {
+		return events, app.process(events)
+	}
+}
+
+func (app *Application) createPage(cmd *CreatePageCommand) (*Events, error) {
+	page := app.types.pages.New()
+	events, err := page.HandleCommand(cmd)
+
+	if err != nil {
+		return NoEvents, err
+	} else {
+		return events, app.process(events)
+	}
+}
+
+func (app *Application) registerAuthor(cmd *RegisterAuthorCommand) (*Events, error) {
+	author := app.types.authors.New()
+	events, err := author.HandleCommand(cmd)
+
+	if err != nil {
+		return NoEvents, err
+	} else {
+		return events, app.process(events)
+	}
+}
+
+// logIn starts a session if the credentials given in cmd are valid.
+// Authors who have enabled two-factor authentication also need to give
+// a valid code.  Attempts are limited per address and login, and the
+// password is compared without holding the application's lock.
+func (app *Application) logIn(cmd *LogInCommand) (*Events, error) {
+	now := time.Now()
+	if !app.logins.byIP.Allow(cmd.RemoteAddr, now) || !app.logins.byLogin.Allow(strings.ToLower(cmd.Login), now) {
+		return NoEvents, ErrTooManyRequests
+	}
+
+	app.lock.Lock()
+	hash := app.types.authors.PasswordHash(cmd.Login)
+	app.lock.Unlock()
+
+	admin := validUser(cmd.Login, cmd.Password)
+	valid := admin || bcrypt.CompareHashAndPassword(hash, []byte(cmd.Password)) == nil
+
+	app.lock.Lock()
+	defer app.lock.Unlock()
+
+	principal := app.PrincipalFor(cmd.Login)
+	if admin {
+		principal = adminPrincipal(cmd.Login)
+		cmd.adminCredentials = app.adminCredentials()
+	}
+	if !valid || principal == nil {
+		return NoEvents, ErrInvalidCredentials
+	}
+
+	if app.types.authors.TwoFactorEnabled(principal.AuthorId) {
+		if cmd.Code == "" {
+			return NoEvents, ErrCodeRequired
+		}
+
+		verify := &VerifyTwoFactorCommand{
+			AuthorId:   principal.AuthorId,
+			Code:       cmd.Code,
+			RemoteAddr: cmd.RemoteAddr,
+		}
+		if err := app.verifyTwoFactor(verify); err != nil {
+			return NoEvents, err
+		}
+		cmd.twoFactor = true
+	}
+
+	session := app.types.sessions.New()
+	events, err := session.HandleCommand(cmd)
+
+	if err != nil {
+		return NoEvents, err
+	} else {
+		return events, app.process(events)
+	}
+}
+
+// verifyTwoFactor checks the second factor given in cmd.  Unlike other
+// commands, failed verifications are recorded as well, for auditing
+// them and for locking out further attempts.
+func (app *Application) verifyTwoFactor(cmd *VerifyTwoFactorCommand) error {
+	cmd.Sanitize()
+	author, err := app.load(app.types.authors, cmd.AuthorId)
+	if err == ErrNotFound {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("Application.load: %s\n", err)
+	}
+
+	events, err := author.HandleCommand(cmd)
+	if perr := app.process(events); perr != nil {
+		return perr
+	}
+
+	return err
+}
+
+// disableTwoFactor checks the code given in cmd, if required, before
+// disabling two-factor authentication.
+func (app *Application) disableTwoFactor(cmd *DisableTwoFactorCommand) (*Events, error) {
+	if cmd.requireCode && app.types.authors.TwoFactorEnabled(cmd.AuthorId) {
+		if cmd.Code == "" {
+			return NoEvents, ValidationError{}.Add("Code", ErrCodeRequired)
+		}
+
+		err := app.verifyTwoFactor(&VerifyTwoFactorCommand{
+			AuthorId:   cmd.AuthorId,
+			Code:       cmd.Code,
+			RemoteAddr: cmd.RemoteAddr,
+		})
+		if err == ErrInvalidCode {
+			return NoEvents, ValidationError{}.Add("Code", err)
+		} else if err != nil {
+			return NoEvents, err
+		}
+	}
+
+	return app.update(app.types.authors, cmd.AuthorId, cmd)
+}
+
+// uploadMedia stores the uploaded file before recording the upload.
+func (app *Application) uploadMedia(cmd *UploadMediaCommand) (*Events, error) {
+	media := app.types.media.New()
+	events, err := media.HandleCommand(cmd)
+	if err != nil {
+		return NoEvents, err
+	}
+
+	uploaded := events.Items()[0].(*MediaUploadedEvent)
+	if err := app.media.Put(uploaded.Hash, cmd.Content); err != nil {
+		return NoEvents, err
+	}
+	widths := variantWidths(uploaded.MimeType, uploaded.Width, uploaded.Animated)
+	if err := app.media.PutVariants(uploaded.Hash, uploaded.MimeType, widths); err != nil {
+		return NoEvents, err
+	}
+
+	return events, app.process(events)
+}
+
+func (app *Application) changeBlocklist(cmd Command) (*Events, error) {
+	blocklist := app.types.blocklist.New()
+	events, err := blocklist.HandleCommand(cmd)
+
+	if err != nil {
+		return NoEvents, err
+	} else {
+		return events, app.process(events)
+	}
+}
+
 func (app *Application) commentOnPost(cmd *CommentOnPostCommand) (*Events, error) {
+	if err := app.guard.Check(cmd); err != nil {
+		return NoEvents, err
+	}
+
 	post, err := app.load(app.types.posts, cmd.PostId)
 
 	if err == ErrNotFound {
@@ -167,6 +725,12 @@ func (app *Application) commentOnPost(cmd *CommentOnPostCommand) (*Events, error
 }
 
 func (app *Application) authenticateComment(cmd *PostAuthenticateCommentCommand) (*Events, error) {
+	commentId, err := app.signer.VerifyCommentAuthenticationToken(cmd.Token)
+	if err != nil {
+		return NoEvents, ValidationError{}.Add("Token", err)
+	}
+
+	cmd.CommentId = commentId
 	cmd.postId = app.types.posts.IdForComment(cmd.CommentId)
 	if cmd.postId == "" {
 		return NoEvents, ErrNotFound
@@ -185,6 +749,50 @@ func (app *Application) authenticateComment(cmd *PostAuthenticateCommentCommand)
 	}
 }
 
+func (app *Application) resendCommentAuthentication(cmd *ResendCommentAuthenticationCommand) (*Events, error) {
+	if !app.signer.VerifyResendAuthentication(cmd.Signature, cmd.CommentId) {
+		return NoEvents, ValidationError{}.Add("Signature", ErrInvalidSignature)
+	}
+
+	postId := app.types.posts.IdForComment(cmd.CommentId)
+	if postId == "" {
+		return NoEvents, ErrNotFound
+	}
+
+	return app.update(app.types.posts, postId, cmd)
+}
+
+// changeOwnComment lets the author of a comment change it, given a
+// valid signature from the comment's management link.
+func (app *Application) changeOwnComment(commentId, signature string, cmd Command) (*Events, error) {
+	if !app.signer.VerifyCommentManagement(signature, commentId) {
+		return NoEvents, ValidationError{}.Add("Signature", ErrInvalidSignature)
+	}
+
+	postId := app.types.posts.PostForComment(commentId)
+	if postId == "" {
+		return NoEvents, ErrNotFound
+	}
+
+	return app.update(app.types.posts, postId, cmd)
+}
+
+// editComment checks the new content of a comment for spam before
+// changing it, as it would have been when the comment was written.
+func (app *Application) editComment(cmd *EditCommentCommand) (*Events, error) {
+	if !app.signer.VerifyCommentManagement(cmd.Signature, cmd.CommentId) {
+		return NoEvents, ValidationError{}.Add("Signature", ErrInvalidSignature)
+	}
+
+	if comment := app.views.comments.ById(cmd.CommentId); comment != nil {
+		if err := app.guard.CheckContent(comment.Author, comment.Email, cmd.Content); err != nil {
+			return NoEvents, err
+		}
+	}
+
+	return app.changeOwnComment(cmd.CommentId, cmd.Signature, cmd)
+}
+
 func (app *Application) previewPost(cmd *PreviewPostCommand) (*Events, error) {
 	posts := &Posts{}
 	post := posts.New()
@@ -0,0 +1,183 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dhamidi/blog"
+	"github.com/dhamidi/blog/eventstore"
+)
+
+// newApplication returns an application storing its events and media
+// in a temporary directory, which is returned for removing it.
+func newApplication(t *testing.T) (*main.Application, string) {
+	dir, err := ioutil.TempDir("", "blog-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := eventstore.NewOnDisk(filepath.Join(dir, "events"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("BLOG_MEDIA_DIR", filepath.Join(dir, "media"))
+	app := &main.Application{Store: store}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	return app, dir
+}
+
+// registerAuthor registers an author with the given roles and returns
+// the principal for logging in as them.
+func registerAuthor(t *testing.T, app *main.Application, login string, roles ...string) *main.Principal {
+	admin := &main.Principal{Login: "admin", Roles: []string{main.RoleAdmin}}
+	if _, err := app.HandleCommandAs(admin, &main.RegisterAuthorCommand{
+		Login:    login,
+		Name:     login,
+		Email:    login + "@example.com",
+		Password: "correct horse",
+		Roles:    roles,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return app.PrincipalFor(login)
+}
+
+func TestApplication_HandleCommandAs_RestrictsChangesToOwnPosts(t *testing.T) {
+	app, dir := newApplication(t)
+	defer os.RemoveAll(dir)
+
+	jane := registerAuthor(t, app, "jane")
+	john := registerAuthor(t, app, "john")
+	editor := registerAuthor(t, app, "eve", main.RoleEditor)
+
+	events, err := app.HandleCommandAs(jane, &main.PublishPostCommand{Title: "Jane's post", Content: "content"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	postId := events.Items()[0].(*main.PostPublishedEvent).PostId
+
+	commands := []main.Command{
+		&main.RewordPostCommand{PostId: postId, NewContent: "changed content"},
+		&main.TagPostCommand{PostId: postId, Tag: "changed"},
+		&main.RevertPostCommand{PostId: postId, Revision: 1},
+	}
+
+	for _, cmd := range commands {
+		if _, err := app.HandleCommandAs(john, cmd); err != main.ErrForbidden {
+			t.Fatalf("Expected %T by another author to be %s, got %v", cmd, main.ErrForbidden, err)
+		}
+	}
+
+	for _, cmd := range commands {
+		if _, err := app.HandleCommandAs(editor, cmd); err != nil {
+			t.Fatalf("Expected %T by an editor to be allowed, got %v", cmd, err)
+		}
+	}
+}
+
+func TestApplication_HandleCommandAs_ModeratorCannotManageSite(t *testing.T) {
+	app, dir := newApplication(t)
+	defer os.RemoveAll(dir)
+
+	moderator := registerAuthor(t, app, "mo", main.RoleModerator)
+
+	for _, cmd := range []main.Command{
+		&main.CreateSeriesCommand{Title: "Series"},
+		&main.CreatePageCommand{Title: "About", Content: "content"},
+	} {
+		if _, err := app.HandleCommandAs(moderator, cmd); err != main.ErrForbidden {
+			t.Fatalf("Expected %T by a moderator to be %s, got %v", cmd, main.ErrForbidden, err)
+		}
+	}
+}
+
+func TestApplication_HandleCommandAs_EnableTwoFactorOnlyForSelf(t *testing.T) {
+	app, dir := newApplication(t)
+	defer os.RemoveAll(dir)
+
+	jane := registerAuthor(t, app, "jane")
+	admin := &main.Principal{Login: "admin", Roles: []string{main.RoleAdmin}}
+
+	_, err := app.HandleCommandAs(admin, &main.EnableTwoFactorCommand{
+		AuthorId: jane.AuthorId,
+		Secret:   main.NewTOTPSecret(),
+	})
+	if err != main.ErrForbidden {
+		t.Fatalf("Expected enrolling another author to be %s, got %v", main.ErrForbidden, err)
+	}
+}
+
+func TestApplication_HandleCommand_LimitsLoginAttempts(t *testing.T) {
+	app, dir := newApplication(t)
+	defer os.RemoveAll(dir)
+
+	registerAuthor(t, app, "jane")
+
+	for i := 0; i < 10; i++ {
+		_, err := app.HandleCommand(&main.LogInCommand{Login: "jane", Password: "wrong", RemoteAddr: "192.0.2.1"})
+		if err != main.ErrInvalidCredentials {
+			t.Fatalf("Expected attempt %d to be %s, got %v", i+1, main.ErrInvalidCredentials, err)
+		}
+	}
+
+	_, err := app.HandleCommand(&main.LogInCommand{Login: "jane", Password: "correct horse", RemoteAddr: "192.0.2.2"})
+	if err != main.ErrTooManyRequests {
+		t.Fatalf("Expected further attempts to be %s, got %v", main.ErrTooManyRequests, err)
+	}
+}
+
+func TestApplication_HandleCommandAs_DisableTwoFactorRequiresCodeFromSelf(t *testing.T) {
+	app, dir := newApplication(t)
+	defer os.RemoveAll(dir)
+
+	jane := registerAuthor(t, app, "jane")
+	secret := main.NewTOTPSecret()
+	enable := &main.EnableTwoFactorCommand{
+		AuthorId: jane.AuthorId,
+		Secret:   secret,
+		Code:     main.TOTPCode(secret, time.Now()),
+	}
+	if _, err := app.HandleCommandAs(jane, enable); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, code := range []string{"", "invalid"} {
+		_, err := app.HandleCommandAs(jane, &main.DisableTwoFactorCommand{AuthorId: jane.AuthorId, Code: code})
+		if _, invalid := err.(main.ValidationError); !invalid {
+			t.Fatalf("Expected disabling with code %q to be invalid, got %v", code, err)
+		}
+	}
+
+	disable := &main.DisableTwoFactorCommand{AuthorId: jane.AuthorId, Code: enable.RecoveryCodes()[0]}
+	if _, err := app.HandleCommandAs(jane, disable); err != nil {
+		t.Fatalf("Expected disabling with a recovery code to be allowed, got %v", err)
+	}
+}
+
+func TestApplication_Init_RefusesExampleAdminPassword(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blog-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := eventstore.NewOnDisk(filepath.Join(dir, "events"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("BLOG_ADMIN_PASS_HASH", "$2a$10$uM36nXxyMFv9xuQvzSHSsODNROsiMi0O0YgjcRCgGAta6QFhcepvi")
+	defer os.Unsetenv("BLOG_ADMIN_PASS_HASH")
+	app := &main.Application{Store: store}
+	if err := app.Init(); err == nil {
+		t.Fatal("Expected the example password to be refused.")
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
-import "strings"
+import (
+	"path"
+	"strings"
+)
 
 type RewordPostCommand struct {
 	PostId     string
@@ -12,9 +15,24 @@ func (cmd *RewordPostCommand) Sanitize() {
 	cmd.NewContent = strings.TrimSpace(cmd.NewContent)
 }
 
+// RevertPostCommand restores the content of a post from an earlier
+// revision.  Revision 1 is the content the post was published with,
+// every reword adds another revision.
+type RevertPostCommand struct {
+	PostId   string
+	Revision int
+}
+
+func (cmd *RevertPostCommand) Sanitize() {}
+
 type PublishPostCommand struct {
 	Title   string
 	Content string
+	Tags    []string
+
+	// AuthorId is set to the author on whose behalf the post is
+	// published.
+	AuthorId string
 
 	postId string
 }
@@ -22,6 +40,7 @@ type PublishPostCommand struct {
 func (cmd *PublishPostCommand) Sanitize() {
 	cmd.Title = strings.TrimSpace(cmd.Title)
 	cmd.Content = strings.TrimSpace(cmd.Content)
+	cmd.Tags = normalizeTags(cmd.Tags)
 
 	if cmd.postId == "" {
 		cmd.postId = Id()
@@ -34,23 +53,411 @@ type PreviewPostCommand struct {
 	view *AllPostsPost
 }
 
+type TagPostCommand struct {
+	PostId string
+	Tag    string
+}
+
+func (cmd *TagPostCommand) Sanitize() {
+	cmd.Tag = normalizeTag(cmd.Tag)
+}
+
+type UntagPostCommand struct {
+	PostId string
+	Tag    string
+}
+
+func (cmd *UntagPostCommand) Sanitize() {
+	cmd.Tag = normalizeTag(cmd.Tag)
+}
+
 type CommentOnPostCommand struct {
 	PostId  string
 	Content string
 	Author  string
 	Email   string
+
+	// ParentId identifies the comment this comment is a reply to.
+	// It is empty for top-level comments.
+	ParentId string
+
+	// RemoteAddr is the IP address the comment was sent from.
+	RemoteAddr string
+
+	// Honeypot is the value of a form field hidden from humans,
+	// which is only filled in by bots.
+	Honeypot string
+
+	// FormToken records when the comment form was rendered.
+	FormToken string
 }
 
 func (cmd *CommentOnPostCommand) Sanitize() {
+	cmd.ParentId = strings.TrimSpace(cmd.ParentId)
 	cmd.Content = strings.TrimSpace(cmd.Content)
 	cmd.Author = strings.TrimSpace(cmd.Author)
 	cmd.Email = strings.TrimSpace(cmd.Email)
 }
 
+// AuthorCommentOnPostCommand is used by the author of the blog to
+// comment on a post.  Such comments need no email authentication.
+type AuthorCommentOnPostCommand struct {
+	PostId   string
+	ParentId string
+	Content  string
+	Author   string
+	Email    string
+}
+
+func (cmd *AuthorCommentOnPostCommand) Sanitize() {
+	cmd.ParentId = strings.TrimSpace(cmd.ParentId)
+	cmd.Content = strings.TrimSpace(cmd.Content)
+	cmd.Author = strings.TrimSpace(cmd.Author)
+	cmd.Email = strings.TrimSpace(cmd.Email)
+}
+
+// PostAuthenticateCommentCommand authenticates a comment.  Token is the
+// signed token from the authentication mail, CommentId is filled in
+// from the token once it has been verified.
 type PostAuthenticateCommentCommand struct {
+	Token     string
 	CommentId string
 
 	postId string
 }
 
 func (cmd *PostAuthenticateCommentCommand) Sanitize() {}
+
+// ResendCommentAuthenticationCommand sends another authentication mail
+// for a comment.  Signature is handed out to the author of the comment
+// after commenting.
+type ResendCommentAuthenticationCommand struct {
+	CommentId string
+	Signature string
+}
+
+func (cmd *ResendCommentAuthenticationCommand) Sanitize() {}
+
+// ExpireCommentCommand discards a comment that has not been
+// authenticated in time.
+type ExpireCommentCommand struct {
+	PostId    string
+	CommentId string
+}
+
+func (cmd *ExpireCommentCommand) Sanitize() {}
+
+// EditCommentCommand changes the content of a comment on behalf of its
+// author.  Signature is taken from the management link sent to the
+// author.
+type EditCommentCommand struct {
+	CommentId string
+	Content   string
+	Signature string
+}
+
+func (cmd *EditCommentCommand) Sanitize() {
+	cmd.Content = strings.TrimSpace(cmd.Content)
+}
+
+// WithdrawCommentCommand removes a comment on behalf of its author.
+type WithdrawCommentCommand struct {
+	CommentId string
+	Signature string
+}
+
+func (cmd *WithdrawCommentCommand) Sanitize() {}
+
+// ApproveCommentCommand publishes a comment that is pending approval or
+// has been hidden before.
+type ApproveCommentCommand struct {
+	PostId    string
+	CommentId string
+}
+
+func (cmd *ApproveCommentCommand) Sanitize() {}
+
+// RejectCommentCommand refuses to publish a comment that has not been
+// published yet.
+type RejectCommentCommand struct {
+	PostId    string
+	CommentId string
+}
+
+func (cmd *RejectCommentCommand) Sanitize() {}
+
+// HideCommentCommand takes down a published comment.
+type HideCommentCommand struct {
+	PostId    string
+	CommentId string
+}
+
+func (cmd *HideCommentCommand) Sanitize() {}
+
+// DeleteCommentCommand removes a comment for good.
+type DeleteCommentCommand struct {
+	PostId    string
+	CommentId string
+}
+
+func (cmd *DeleteCommentCommand) Sanitize() {}
+
+type CreateSeriesCommand struct {
+	Title       string
+	Description string
+}
+
+func (cmd *CreateSeriesCommand) Sanitize() {
+	cmd.Title = strings.TrimSpace(cmd.Title)
+	cmd.Description = strings.TrimSpace(cmd.Description)
+}
+
+// AddPostToSeriesCommand adds a post to a series.  Position starts at
+// 1; a position of 0 appends the post to the end of the series.
+type AddPostToSeriesCommand struct {
+	SeriesId string
+	PostId   string
+	Position int
+}
+
+func (cmd *AddPostToSeriesCommand) Sanitize() {}
+
+// ReorderSeriesCommand lists all posts of a series in their new order.
+type ReorderSeriesCommand struct {
+	SeriesId string
+	PostIds  []string
+}
+
+func (cmd *ReorderSeriesCommand) Sanitize() {}
+
+// BlockEmailCommand prevents an email address from being used for
+// commenting.
+type BlockEmailCommand struct {
+	Email string
+}
+
+func (cmd *BlockEmailCommand) Sanitize() {
+	cmd.Email = normalizeEmail(cmd.Email)
+}
+
+type UnblockEmailCommand struct {
+	Email string
+}
+
+func (cmd *UnblockEmailCommand) Sanitize() {
+	cmd.Email = normalizeEmail(cmd.Email)
+}
+
+// BlockDomainCommand prevents all email addresses of a domain from
+// being used for commenting.
+type BlockDomainCommand struct {
+	Domain string
+}
+
+func (cmd *BlockDomainCommand) Sanitize() {
+	cmd.Domain = normalizeEmail(cmd.Domain)
+}
+
+type UnblockDomainCommand struct {
+	Domain string
+}
+
+func (cmd *UnblockDomainCommand) Sanitize() {
+	cmd.Domain = normalizeEmail(cmd.Domain)
+}
+
+// UploadMediaCommand adds a file to the media library.
+type UploadMediaCommand struct {
+	Name     string
+	MimeType string
+	Content  []byte
+}
+
+func (cmd *UploadMediaCommand) Sanitize() {
+	cmd.Name = path.Base(strings.TrimSpace(strings.Replace(cmd.Name, "\\", "/", -1)))
+	if cmd.Name == "." || cmd.Name == "/" {
+		cmd.Name = ""
+	}
+}
+
+// CreatePageCommand creates a page that is not part of the list of
+// posts.  Slug determines the page's URL and defaults to the title.
+// If Navigation is set, the page is linked to from every page, ordered
+// by Position.
+type CreatePageCommand struct {
+	Title      string
+	Slug       string
+	Content    string
+	Navigation bool
+	Position   int
+}
+
+func (cmd *CreatePageCommand) Sanitize() {
+	cmd.Title = strings.TrimSpace(cmd.Title)
+	cmd.Content = strings.TrimSpace(cmd.Content)
+	if strings.TrimSpace(cmd.Slug) == "" {
+		cmd.Slug = cmd.Title
+	}
+	cmd.Slug = normalizeTag(cmd.Slug)
+}
+
+type EditPageCommand struct {
+	PageId     string
+	Title      string
+	Content    string
+	Navigation bool
+	Position   int
+}
+
+func (cmd *EditPageCommand) Sanitize() {
+	cmd.Title = strings.TrimSpace(cmd.Title)
+	cmd.Content = strings.TrimSpace(cmd.Content)
+}
+
+type DeletePageCommand struct {
+	PageId string
+}
+
+func (cmd *DeletePageCommand) Sanitize() {}
+
+// RegisterAuthorCommand adds an author who can log in with Login and
+// Password, granting Roles to the author.
+type RegisterAuthorCommand struct {
+	Login    string
+	Name     string
+	Email    string
+	Password string
+	Roles    []string
+}
+
+func (cmd *RegisterAuthorCommand) Sanitize() {
+	cmd.Login = normalizeTag(cmd.Login)
+	cmd.Name = strings.TrimSpace(cmd.Name)
+	cmd.Email = normalizeEmail(cmd.Email)
+}
+
+type UpdateAuthorProfileCommand struct {
+	AuthorId string
+	Name     string
+	Email    string
+	Bio      string
+}
+
+func (cmd *UpdateAuthorProfileCommand) Sanitize() {
+	cmd.Name = strings.TrimSpace(cmd.Name)
+	cmd.Email = normalizeEmail(cmd.Email)
+	cmd.Bio = strings.TrimSpace(cmd.Bio)
+}
+
+// DeactivateAuthorCommand prevents an author from logging in.  The
+// author's posts remain published.
+type DeactivateAuthorCommand struct {
+	AuthorId string
+}
+
+func (cmd *DeactivateAuthorCommand) Sanitize() {}
+
+type GrantRoleCommand struct {
+	AuthorId string
+	Role     string
+}
+
+func (cmd *GrantRoleCommand) Sanitize() {
+	cmd.Role = strings.TrimSpace(cmd.Role)
+}
+
+type RevokeRoleCommand struct {
+	AuthorId string
+	Role     string
+}
+
+func (cmd *RevokeRoleCommand) Sanitize() {
+	cmd.Role = strings.TrimSpace(cmd.Role)
+}
+
+// ChangePasswordCommand sets a new password for an author.
+type ChangePasswordCommand struct {
+	AuthorId string
+	Password string
+}
+
+func (cmd *ChangePasswordCommand) Sanitize() {}
+
+// EnableTwoFactorCommand enrolls the authenticator app set up with
+// Secret, once Code shows that it works.
+type EnableTwoFactorCommand struct {
+	AuthorId string
+	Secret   string
+	Code     string
+
+	recoveryCodes []string
+}
+
+func (cmd *EnableTwoFactorCommand) Sanitize() {
+	cmd.Secret = strings.TrimSpace(cmd.Secret)
+	cmd.Code = strings.TrimSpace(cmd.Code)
+}
+
+// RecoveryCodes returns the recovery codes handed out when enabling
+// two-factor authentication.
+func (cmd *EnableTwoFactorCommand) RecoveryCodes() []string {
+	return cmd.recoveryCodes
+}
+
+// VerifyTwoFactorCommand checks the second factor given when logging
+// in or disabling two-factor authentication.  It is only handled as part
+// of a LogInCommand or DisableTwoFactorCommand.
+type VerifyTwoFactorCommand struct {
+	AuthorId   string
+	Code       string
+	RemoteAddr string
+}
+
+func (cmd *VerifyTwoFactorCommand) Sanitize() {
+	cmd.Code = strings.TrimSpace(cmd.Code)
+}
+
+// DisableTwoFactorCommand turns off two-factor authentication for an
+// author.  Authors disabling it for themselves need to confirm with a
+// valid Code.
+type DisableTwoFactorCommand struct {
+	AuthorId   string
+	Code       string
+	RemoteAddr string
+
+	requireCode bool
+}
+
+func (cmd *DisableTwoFactorCommand) Sanitize() {
+	cmd.Code = strings.TrimSpace(cmd.Code)
+}
+
+// LogInCommand starts a session for the user identified by Login and
+// Password.  Code is required for authors who have enabled two-factor
+// authentication.
+type LogInCommand struct {
+	Login      string
+	Password   string
+	Code       string
+	RemoteAddr string
+
+	sessionId        string
+	twoFactor        bool
+	adminCredentials string
+}
+
+func (cmd *LogInCommand) Sanitize() {
+	cmd.Login = strings.TrimSpace(cmd.Login)
+	cmd.Code = strings.TrimSpace(cmd.Code)
+}
+
+// SessionId returns the id of the session started by the command.
+func (cmd *LogInCommand) SessionId() string {
+	return cmd.sessionId
+}
+
+type LogOutCommand struct {
+	SessionId string
+}
+
+func (cmd *LogOutCommand) Sanitize() {}
@@ -49,4 +49,28 @@ var (
 	ErrNotFound             = errors.New("not found")
 	ErrEmpty                = errors.New("empty")
 	ErrAlreadyAuthenticated = errors.New("already authenticated")
+	ErrAlreadyInSeries      = errors.New("already part of a series")
+	ErrNotAuthenticated     = errors.New("not authenticated")
+	ErrNotPublished         = errors.New("not published")
+	ErrAlreadyPublished     = errors.New("already published")
+	ErrInvalidSignature     = errors.New("invalid signature")
+	ErrEditWindowClosed     = errors.New("cannot be edited anymore")
+	ErrWithdrawn            = errors.New("withdrawn")
+	ErrExpired              = errors.New("expired")
+	ErrTooManyResends       = errors.New("sent too often")
+	ErrOutOfRange           = errors.New("out of range")
+	ErrMismatch             = errors.New("does not match")
+	ErrBlocked              = errors.New("blocked")
+	ErrTooFast              = errors.New("submitted too quickly")
+	ErrLooksLikeSpam        = errors.New("looks like spam")
+	ErrTooManyRequests      = errors.New("too many requests, try again later")
+	ErrTooLarge             = errors.New("too large")
+	ErrUnsupported          = errors.New("not supported")
+	ErrTooShort             = errors.New("too short")
+	ErrForbidden            = errors.New("forbidden")
+	ErrInvalidCredentials   = errors.New("invalid login or password")
+	ErrCodeRequired         = errors.New("authentication code required")
+	ErrInvalidCode          = errors.New("invalid authentication code")
+	ErrAlreadyEnabled       = errors.New("already enabled")
+	ErrInvalid              = errors.New("invalid")
 )
@@ -1,6 +1,9 @@
 package main
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 type PostRewordedEvent struct {
 	PostId          string
@@ -14,8 +17,10 @@ func (event *PostRewordedEvent) AggregateId() string { return event.PostId }
 
 type PostPublishedEvent struct {
 	PostId      string
+	AuthorId    string
 	Title       string
 	Content     string
+	Tags        []string
 	PublishedAt time.Time
 }
 
@@ -27,13 +32,36 @@ func (event *PostPublishedEvent) AggregateId() string {
 	return event.PostId
 }
 
+type PostTaggedEvent struct {
+	PostId   string
+	TagName  string
+	TaggedAt time.Time
+}
+
+func (event *PostTaggedEvent) Tag() string         { return "post.tagged" }
+func (event *PostTaggedEvent) AggregateId() string { return event.PostId }
+
+type PostUntaggedEvent struct {
+	PostId     string
+	TagName    string
+	UntaggedAt time.Time
+}
+
+func (event *PostUntaggedEvent) Tag() string         { return "post.untagged" }
+func (event *PostUntaggedEvent) AggregateId() string { return event.PostId }
+
 type PostCommentedEvent struct {
 	PostId      string
 	CommentId   string
+	ParentId    string
 	AuthorName  string
 	AuthorEmail string
 	Content     string
 	CommentedAt time.Time
+
+	// ByAuthor is set for comments written by the author of the
+	// blog through the admin interface.
+	ByAuthor bool
 }
 
 func (event *PostCommentedEvent) Tag() string         { return "post.commented" }
@@ -43,7 +71,348 @@ type PostCommentAuthenticatedEvent struct {
 	CommentId       string
 	PostId          string
 	AuthenticatedAt time.Time
+
+	// PendingApproval is set if the comment needs to be approved
+	// by the admin before it is published.
+	PendingApproval bool
 }
 
 func (event *PostCommentAuthenticatedEvent) Tag() string         { return "post.comment_authenticated" }
 func (event *PostCommentAuthenticatedEvent) AggregateId() string { return event.PostId }
+
+// CommentAuthenticationRequestedEvent is recorded whenever the author of
+// a comment asks for another authentication mail.
+type CommentAuthenticationRequestedEvent struct {
+	CommentId   string
+	PostId      string
+	AuthorName  string
+	AuthorEmail string
+	RequestedAt time.Time
+}
+
+func (event *CommentAuthenticationRequestedEvent) Tag() string {
+	return "post.comment_authentication_requested"
+}
+func (event *CommentAuthenticationRequestedEvent) AggregateId() string { return event.PostId }
+
+type CommentExpiredEvent struct {
+	CommentId string
+	PostId    string
+	ExpiredAt time.Time
+}
+
+func (event *CommentExpiredEvent) Tag() string         { return "post.comment_expired" }
+func (event *CommentExpiredEvent) AggregateId() string { return event.PostId }
+
+type CommentEditedEvent struct {
+	CommentId string
+	PostId    string
+	Content   string
+	EditedAt  time.Time
+
+	// PendingApproval is set if the comment had been published and
+	// needs to be approved again because comments are moderated.
+	PendingApproval bool
+}
+
+func (event *CommentEditedEvent) Tag() string         { return "post.comment_edited" }
+func (event *CommentEditedEvent) AggregateId() string { return event.PostId }
+
+type CommentWithdrawnEvent struct {
+	CommentId   string
+	PostId      string
+	WithdrawnAt time.Time
+}
+
+func (event *CommentWithdrawnEvent) Tag() string         { return "post.comment_withdrawn" }
+func (event *CommentWithdrawnEvent) AggregateId() string { return event.PostId }
+
+type PostCommentApprovedEvent struct {
+	CommentId  string
+	PostId     string
+	ApprovedAt time.Time
+}
+
+func (event *PostCommentApprovedEvent) Tag() string         { return "post.comment_approved" }
+func (event *PostCommentApprovedEvent) AggregateId() string { return event.PostId }
+
+type PostCommentRejectedEvent struct {
+	CommentId  string
+	PostId     string
+	RejectedAt time.Time
+}
+
+func (event *PostCommentRejectedEvent) Tag() string         { return "post.comment_rejected" }
+func (event *PostCommentRejectedEvent) AggregateId() string { return event.PostId }
+
+type PostCommentHiddenEvent struct {
+	CommentId string
+	PostId    string
+	HiddenAt  time.Time
+}
+
+func (event *PostCommentHiddenEvent) Tag() string         { return "post.comment_hidden" }
+func (event *PostCommentHiddenEvent) AggregateId() string { return event.PostId }
+
+type PostCommentDeletedEvent struct {
+	CommentId string
+	PostId    string
+	DeletedAt time.Time
+}
+
+func (event *PostCommentDeletedEvent) Tag() string         { return "post.comment_deleted" }
+func (event *PostCommentDeletedEvent) AggregateId() string { return event.PostId }
+
+type SeriesCreatedEvent struct {
+	SeriesId    string
+	Title       string
+	Description string
+	CreatedAt   time.Time
+}
+
+func (event *SeriesCreatedEvent) Tag() string         { return "series.created" }
+func (event *SeriesCreatedEvent) AggregateId() string { return event.SeriesId }
+
+type PostAddedToSeriesEvent struct {
+	SeriesId string
+	PostId   string
+	Position int
+	AddedAt  time.Time
+}
+
+func (event *PostAddedToSeriesEvent) Tag() string         { return "series.post_added" }
+func (event *PostAddedToSeriesEvent) AggregateId() string { return event.SeriesId }
+
+type SeriesReorderedEvent struct {
+	SeriesId    string
+	PostIds     []string
+	ReorderedAt time.Time
+}
+
+func (event *SeriesReorderedEvent) Tag() string         { return "series.reordered" }
+func (event *SeriesReorderedEvent) AggregateId() string { return event.SeriesId }
+
+type EmailBlockedEvent struct {
+	Email     string
+	BlockedAt time.Time
+}
+
+func (event *EmailBlockedEvent) Tag() string         { return "blocklist.email_blocked" }
+func (event *EmailBlockedEvent) AggregateId() string { return BlocklistId }
+
+type EmailUnblockedEvent struct {
+	Email       string
+	UnblockedAt time.Time
+}
+
+func (event *EmailUnblockedEvent) Tag() string         { return "blocklist.email_unblocked" }
+func (event *EmailUnblockedEvent) AggregateId() string { return BlocklistId }
+
+type DomainBlockedEvent struct {
+	Domain    string
+	BlockedAt time.Time
+}
+
+func (event *DomainBlockedEvent) Tag() string         { return "blocklist.domain_blocked" }
+func (event *DomainBlockedEvent) AggregateId() string { return BlocklistId }
+
+type DomainUnblockedEvent struct {
+	Domain      string
+	UnblockedAt time.Time
+}
+
+func (event *DomainUnblockedEvent) Tag() string         { return "blocklist.domain_unblocked" }
+func (event *DomainUnblockedEvent) AggregateId() string { return BlocklistId }
+
+type MediaUploadedEvent struct {
+	MediaId    string
+	Name       string
+	Hash       string
+	MimeType   string
+	Size       int64
+	UploadedAt time.Time
+
+	// Width and Height are only set for images that can be
+	// resized.
+	Width    int
+	Height   int
+	Animated bool
+}
+
+func (event *MediaUploadedEvent) Tag() string         { return "media.uploaded" }
+func (event *MediaUploadedEvent) AggregateId() string { return event.MediaId }
+
+type PageCreatedEvent struct {
+	PageId     string
+	Title      string
+	Slug       string
+	Content    string
+	Navigation bool
+	Position   int
+	CreatedAt  time.Time
+}
+
+func (event *PageCreatedEvent) Tag() string         { return "page.created" }
+func (event *PageCreatedEvent) AggregateId() string { return event.PageId }
+
+type PageEditedEvent struct {
+	PageId     string
+	Title      string
+	Content    string
+	Navigation bool
+	Position   int
+	EditedAt   time.Time
+}
+
+func (event *PageEditedEvent) Tag() string         { return "page.edited" }
+func (event *PageEditedEvent) AggregateId() string { return event.PageId }
+
+type PageDeletedEvent struct {
+	PageId    string
+	DeletedAt time.Time
+}
+
+func (event *PageDeletedEvent) Tag() string         { return "page.deleted" }
+func (event *PageDeletedEvent) AggregateId() string { return event.PageId }
+
+// AuthorRegisteredEvent records a new author.  Only the bcrypt hash of
+// the author's password is stored.  Roles are granted through
+// AuthorRoleGrantedEvent.
+type AuthorRegisteredEvent struct {
+	AuthorId     string
+	Login        string
+	Name         string
+	Email        string
+	PasswordHash string
+	RegisteredAt time.Time
+}
+
+func (event *AuthorRegisteredEvent) Tag() string         { return "author.registered" }
+func (event *AuthorRegisteredEvent) AggregateId() string { return event.AuthorId }
+
+type AuthorProfileUpdatedEvent struct {
+	AuthorId  string
+	Name      string
+	Email     string
+	Bio       string
+	UpdatedAt time.Time
+}
+
+func (event *AuthorProfileUpdatedEvent) Tag() string         { return "author.profile-updated" }
+func (event *AuthorProfileUpdatedEvent) AggregateId() string { return event.AuthorId }
+
+type AuthorDeactivatedEvent struct {
+	AuthorId      string
+	DeactivatedAt time.Time
+}
+
+func (event *AuthorDeactivatedEvent) Tag() string         { return "author.deactivated" }
+func (event *AuthorDeactivatedEvent) AggregateId() string { return event.AuthorId }
+
+type AuthorRoleGrantedEvent struct {
+	AuthorId  string
+	Role      string
+	GrantedAt time.Time
+}
+
+func (event *AuthorRoleGrantedEvent) Tag() string         { return "author.role-granted" }
+func (event *AuthorRoleGrantedEvent) AggregateId() string { return event.AuthorId }
+
+type AuthorRoleRevokedEvent struct {
+	AuthorId  string
+	Role      string
+	RevokedAt time.Time
+}
+
+func (event *AuthorRoleRevokedEvent) Tag() string         { return "author.role-revoked" }
+func (event *AuthorRoleRevokedEvent) AggregateId() string { return event.AuthorId }
+
+type AuthorPasswordChangedEvent struct {
+	AuthorId     string
+	PasswordHash string
+	ChangedAt    time.Time
+}
+
+func (event *AuthorPasswordChangedEvent) Tag() string         { return "author.password-changed" }
+func (event *AuthorPasswordChangedEvent) AggregateId() string { return event.AuthorId }
+
+// TwoFactorEnabledEvent records an author enrolling an authenticator
+// app.  Step is the time step of the code given for confirmation.  Only
+// the hashes of the recovery codes are stored.
+type TwoFactorEnabledEvent struct {
+	AuthorId      string
+	Secret        string
+	Step          int64
+	RecoveryCodes []string
+	EnabledAt     time.Time
+}
+
+func (event *TwoFactorEnabledEvent) Tag() string         { return "author.two-factor-enabled" }
+func (event *TwoFactorEnabledEvent) AggregateId() string { return event.AuthorId }
+
+// GoString keeps the secret out of the log.
+func (event *TwoFactorEnabledEvent) GoString() string {
+	return fmt.Sprintf("&main.TwoFactorEnabledEvent{AuthorId:%q, EnabledAt:%s}", event.AuthorId, event.EnabledAt)
+}
+
+// TwoFactorUsedEvent records a successful second factor when logging
+// in.  Either Step is the time step of the code from the authenticator
+// app or RecoveryCode is the hash of the recovery code used.
+type TwoFactorUsedEvent struct {
+	AuthorId     string
+	Step         int64
+	RecoveryCode string
+	RemoteAddr   string
+	UsedAt       time.Time
+}
+
+func (event *TwoFactorUsedEvent) Tag() string         { return "author.two-factor-used" }
+func (event *TwoFactorUsedEvent) AggregateId() string { return event.AuthorId }
+
+// TwoFactorFailedEvent records an invalid second factor given when
+// logging in.
+type TwoFactorFailedEvent struct {
+	AuthorId   string
+	RemoteAddr string
+	FailedAt   time.Time
+}
+
+func (event *TwoFactorFailedEvent) Tag() string         { return "author.two-factor-failed" }
+func (event *TwoFactorFailedEvent) AggregateId() string { return event.AuthorId }
+
+type TwoFactorDisabledEvent struct {
+	AuthorId   string
+	DisabledAt time.Time
+}
+
+func (event *TwoFactorDisabledEvent) Tag() string         { return "author.two-factor-disabled" }
+func (event *TwoFactorDisabledEvent) AggregateId() string { return event.AuthorId }
+
+type SessionStartedEvent struct {
+	SessionId  string
+	Login      string
+	RemoteAddr string
+	StartedAt  time.Time
+	ExpiresAt  time.Time
+
+	// TwoFactor is true if the user gave a second factor when
+	// logging in.
+	TwoFactor bool
+
+	// AdminCredentials is only set if the user logged in as the
+	// administrator configured through the environment rather than
+	// as an author.  It is a signature of the administrator's
+	// password, so that the session ends when the password changes.
+	AdminCredentials string
+}
+
+func (event *SessionStartedEvent) Tag() string         { return "session.started" }
+func (event *SessionStartedEvent) AggregateId() string { return event.SessionId }
+
+type SessionEndedEvent struct {
+	SessionId string
+	EndedAt   time.Time
+}
+
+func (event *SessionEndedEvent) Tag() string         { return "session.ended" }
+func (event *SessionEndedEvent) AggregateId() string { return event.SessionId }
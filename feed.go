@@ -0,0 +1,585 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"html/template"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+)
+
+// feedSize is the number of posts included in the feeds.
+const feedSize = 20
+
+// feedContentTypes maps the file names of the supported feed formats
+// to their content types.
+var feedContentTypes = map[string]string{
+	"atom.xml":  "application/atom+xml; charset=utf-8",
+	"rss.xml":   "application/rss+xml; charset=utf-8",
+	"feed.json": "application/feed+json; charset=utf-8",
+}
+
+// FeedEntry is a post or a comment as it appears in the feeds of the
+// blog.
+type FeedEntry struct {
+	// Id identifies the entry independently of the title of the
+	// post, so that feed readers recognize it after changes.
+	Id       string
+	PostId   string
+	AuthorId string
+	Title    string
+
+	// AuthorName is only set for comments, which are not written
+	// by registered authors.
+	AuthorName string
+
+	Url         *url.URL
+	ContentHTML template.HTML
+	Tags        []string
+	Published   time.Time
+	Updated     time.Time
+}
+
+func feedEntryId(postId string) string {
+	return "urn:uuid:" + postId
+}
+
+func (entry *FeedEntry) hasTag(tag string) bool {
+	for _, existing := range entry.Tags {
+		if existing == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Feed maintains the entries of the feeds of the blog, most recent
+// first.
+type Feed struct {
+	Title   string
+	Entries []*FeedEntry
+
+	// Comments holds the published comments on all posts.
+	Comments []*FeedEntry
+
+	authors *AuthorsView
+	baseUrl *url.URL
+	byId    map[string]*FeedEntry
+	bySlug  map[string]*FeedEntry
+
+	// allComments holds all comments by id, including those that
+	// have not been published yet.
+	allComments map[string]*FeedEntry
+
+	// retaggedAt is the last time the tags of a post have
+	// changed, which adds or removes entries from the feeds of tags.
+	retaggedAt time.Time
+
+	// commentRemovedAt is the last time a published comment has
+	// been removed.
+	commentRemovedAt time.Time
+}
+
+func NewFeed(authors *AuthorsView) *Feed {
+	title := os.Getenv("BLOG_TITLE")
+	if title == "" {
+		title = "Blog"
+	}
+
+	return &Feed{
+		Title:       title,
+		Entries:     []*FeedEntry{},
+		Comments:    []*FeedEntry{},
+		authors:     authors,
+		baseUrl:     baseUrlFromEnv(),
+		byId:        map[string]*FeedEntry{},
+		bySlug:      map[string]*FeedEntry{},
+		allComments: map[string]*FeedEntry{},
+	}
+}
+
+func (feed *Feed) HandleEvent(event Event) error {
+	switch evt := event.(type) {
+	case *PostPublishedEvent:
+		entry := &FeedEntry{
+			Id:          feedEntryId(evt.PostId),
+			PostId:      evt.PostId,
+			AuthorId:    evt.AuthorId,
+			Title:       evt.Title,
+			Url:         postUrl(slugify(evt.Title)),
+			ContentHTML: textToHTML(evt.Content, false),
+			Tags:        append([]string{}, evt.Tags...),
+			Published:   evt.PublishedAt,
+			Updated:     evt.PublishedAt,
+		}
+		feed.byId[evt.PostId] = entry
+		feed.bySlug[slugify(evt.Title)] = entry
+		feed.Entries = append(feed.Entries, entry)
+		sortFeedEntries(feed.Entries)
+	case *PostRewordedEvent:
+		if entry := feed.byId[evt.PostId]; entry != nil {
+			entry.ContentHTML = textToHTML(evt.RewordedContent, false)
+			entry.Updated = evt.RewordedAt
+		}
+	case *PostTaggedEvent:
+		if entry := feed.byId[evt.PostId]; entry != nil && !entry.hasTag(evt.TagName) {
+			entry.Tags = append(entry.Tags, evt.TagName)
+			sort.Strings(entry.Tags)
+			feed.retaggedAt = evt.TaggedAt
+		}
+	case *PostUntaggedEvent:
+		if entry := feed.byId[evt.PostId]; entry != nil && entry.hasTag(evt.TagName) {
+			tags := []string{}
+			for _, tag := range entry.Tags {
+				if tag != evt.TagName {
+					tags = append(tags, tag)
+				}
+			}
+			entry.Tags = tags
+			feed.retaggedAt = evt.UntaggedAt
+		}
+	case *PostCommentedEvent:
+		feed.addComment(evt)
+	case *PostCommentAuthenticatedEvent:
+		if !evt.PendingApproval {
+			feed.publishComment(evt.CommentId, evt.AuthenticatedAt)
+		}
+	case *PostCommentApprovedEvent:
+		feed.publishComment(evt.CommentId, evt.ApprovedAt)
+	case *CommentEditedEvent:
+		if comment := feed.allComments[evt.CommentId]; comment != nil {
+			comment.ContentHTML = textToHTML(evt.Content, true)
+			comment.Updated = evt.EditedAt
+		}
+		if evt.PendingApproval {
+			feed.unpublishComment(evt.CommentId, evt.EditedAt)
+		}
+	case *CommentWithdrawnEvent:
+		feed.removeComment(evt.CommentId, evt.WithdrawnAt)
+	case *PostCommentHiddenEvent:
+		feed.unpublishComment(evt.CommentId, evt.HiddenAt)
+	case *PostCommentRejectedEvent:
+		feed.removeComment(evt.CommentId, evt.RejectedAt)
+	case *PostCommentDeletedEvent:
+		feed.removeComment(evt.CommentId, evt.DeletedAt)
+	case *CommentExpiredEvent:
+		feed.removeComment(evt.CommentId, evt.ExpiredAt)
+	}
+
+	return nil
+}
+
+func sortFeedEntries(entries []*FeedEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[j].Published.Before(entries[i].Published)
+	})
+}
+
+// addComment keeps track of a new comment until it is published.  The
+// email address of the commenter is left out on purpose.
+func (feed *Feed) addComment(evt *PostCommentedEvent) {
+	post := feed.byId[evt.PostId]
+	if post == nil {
+		return
+	}
+
+	feed.allComments[evt.CommentId] = &FeedEntry{
+		Id:          feedEntryId(evt.CommentId),
+		PostId:      evt.PostId,
+		AuthorName:  evt.AuthorName,
+		Title:       "Comment by " + evt.AuthorName + " on " + post.Title,
+		Url:         post.Url.ResolveReference(&url.URL{Fragment: "comment-" + evt.CommentId}),
+		ContentHTML: textToHTML(evt.Content, true),
+		Published:   evt.CommentedAt,
+		Updated:     evt.CommentedAt,
+	}
+}
+
+func (feed *Feed) publishComment(commentId string, publishedAt time.Time) {
+	comment := feed.allComments[commentId]
+	if comment == nil {
+		return
+	}
+	for _, published := range feed.Comments {
+		if published == comment {
+			return
+		}
+	}
+
+	comment.Updated = publishedAt
+	feed.Comments = append(feed.Comments, comment)
+	sortFeedEntries(feed.Comments)
+}
+
+func (feed *Feed) removeComment(commentId string, removedAt time.Time) {
+	feed.unpublishComment(commentId, removedAt)
+	delete(feed.allComments, commentId)
+}
+
+// unpublishComment removes a comment from the feeds, which can be
+// published again after approval.
+func (feed *Feed) unpublishComment(commentId string, removedAt time.Time) {
+	comment := feed.allComments[commentId]
+	for i, published := range feed.Comments {
+		if published == comment {
+			feed.Comments = append(feed.Comments[:i], feed.Comments[i+1:]...)
+			feed.commentRemovedAt = removedAt
+			return
+		}
+	}
+}
+
+// All returns the channel of all posts.
+func (feed *Feed) All() *FeedChannel {
+	return feed.channel(feed.Title, "/", &url.URL{Path: "/posts.html"}, feed.Entries, func(*FeedEntry) bool {
+		return true
+	})
+}
+
+// ForTag returns the channel of the posts tagged with tag, or nil if
+// there are none.
+func (feed *Feed) ForTag(tag string) *FeedChannel {
+	channel := feed.channel(feed.Title+": "+tag, "/tags/"+tag+"/", tagUrl(tag), feed.Entries, func(entry *FeedEntry) bool {
+		return entry.hasTag(tag)
+	})
+	channel.changedAt = feed.retaggedAt
+	if len(channel.Entries) == 0 {
+		return nil
+	}
+
+	return channel
+}
+
+// ForAuthor returns the channel of the posts written by the author with
+// login, or nil if there is no such author.
+func (feed *Feed) ForAuthor(login string) *FeedChannel {
+	author := feed.authors.ByLogin(login)
+	if author == nil {
+		return nil
+	}
+
+	return feed.channel(feed.Title+": "+author.Name, "/authors/"+login+"/", author.Url, feed.Entries, func(entry *FeedEntry) bool {
+		return entry.AuthorId == author.Id
+	})
+}
+
+// AllComments returns the channel of the comments on all posts.
+func (feed *Feed) AllComments() *FeedChannel {
+	channel := feed.channel(feed.Title+": comments", "/comments/", &url.URL{Path: "/posts.html"}, feed.Comments, func(*FeedEntry) bool {
+		return true
+	})
+	channel.changedAt = feed.commentRemovedAt
+
+	return channel
+}
+
+// CommentsOn returns the channel of the comments on the post with slug,
+// or nil if there is no such post.
+func (feed *Feed) CommentsOn(slug string) *FeedChannel {
+	post := feed.bySlug[slug]
+	if post == nil {
+		return nil
+	}
+
+	channel := feed.channel("Comments on "+post.Title, "/posts/"+slug+"/", post.Url, feed.Comments, func(entry *FeedEntry) bool {
+		return entry.PostId == post.PostId
+	})
+	channel.changedAt = feed.commentRemovedAt
+
+	return channel
+}
+
+func (feed *Feed) channel(title, dir string, home *url.URL, entries []*FeedEntry, include func(*FeedEntry) bool) *FeedChannel {
+	channel := &FeedChannel{
+		Title:   title,
+		Entries: []*FeedEntry{},
+		feed:    feed,
+		dir:     dir,
+		home:    home,
+	}
+
+	for _, entry := range entries {
+		if len(channel.Entries) == feedSize {
+			break
+		}
+		if include(entry) {
+			channel.Entries = append(channel.Entries, entry)
+		}
+	}
+
+	return channel
+}
+
+// authorOf returns the name and profile URL of the author of entry.
+// Posts without an author have been written by the administrator.
+func (feed *Feed) authorOf(entry *FeedEntry) (string, string) {
+	if entry.AuthorName != "" {
+		return entry.AuthorName, ""
+	}
+	if author := feed.authors.ById(entry.AuthorId); author != nil {
+		return author.Name, feed.absolute(author.Url)
+	}
+
+	return authorName(), ""
+}
+
+func (feed *Feed) absolute(loc *url.URL) string {
+	return feed.baseUrl.ResolveReference(loc).String()
+}
+
+// FeedChannel is a selection of the most recent entries of the feed,
+// which can be rendered in all supported feed formats.
+type FeedChannel struct {
+	Title   string
+	Entries []*FeedEntry
+
+	feed *Feed
+
+	// dir is the path under which the channel is available in the
+	// different formats.
+	dir string
+
+	// home is the page showing the posts of the channel.
+	home *url.URL
+
+	// changedAt is the last time an entry has been removed from
+	// the channel, which does not show in the entries left.
+	changedAt time.Time
+}
+
+// Updated returns the time at which the channel has last changed.
+func (channel *FeedChannel) Updated() time.Time {
+	updated := channel.changedAt
+	for _, entry := range channel.Entries {
+		if entry.Updated.After(updated) {
+			updated = entry.Updated
+		}
+	}
+
+	return updated
+}
+
+func (channel *FeedChannel) url(format string) string {
+	return channel.feed.absolute(&url.URL{Path: channel.dir + format})
+}
+
+// Render writes the channel in format, which is one of the keys of
+// feedContentTypes.
+func (channel *FeedChannel) Render(format string, w io.Writer) error {
+	switch format {
+	case "atom.xml":
+		return channel.RenderAtom(w)
+	case "rss.xml":
+		return channel.RenderRSS(w)
+	case "feed.json":
+		return channel.RenderJSON(w)
+	}
+
+	return ErrUnsupported
+}
+
+type atomFeed struct {
+	XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+	Base    string       `xml:"http://www.w3.org/XML/1998/namespace base,attr"`
+	Id      string       `xml:"id"`
+	Title   string       `xml:"title"`
+	Updated string       `xml:"updated"`
+	Links   []atomLink   `xml:"link"`
+	Entries []*atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+	Uri  string `xml:"uri,omitempty"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type    string `xml:"type,attr"`
+	Content string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Id         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Link       atomLink       `xml:"link"`
+	Author     atomPerson     `xml:"author"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomContent    `xml:"content"`
+}
+
+// RenderAtom writes the channel as an Atom feed, see RFC 4287.
+func (channel *FeedChannel) RenderAtom(w io.Writer) error {
+	feed := channel.feed
+	doc := &atomFeed{
+		Base:    feed.absolute(&url.URL{Path: "/"}),
+		Id:      channel.url("atom.xml"),
+		Title:   channel.Title,
+		Updated: channel.Updated().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: channel.url("atom.xml")},
+			{Rel: "alternate", Type: "text/html", Href: feed.absolute(channel.home)},
+		},
+		Entries: []*atomEntry{},
+	}
+
+	for _, entry := range channel.Entries {
+		name, uri := feed.authorOf(entry)
+		atom := &atomEntry{
+			Id:        entry.Id,
+			Title:     entry.Title,
+			Link:      atomLink{Rel: "alternate", Type: "text/html", Href: feed.absolute(entry.Url)},
+			Author:    atomPerson{Name: name, Uri: uri},
+			Published: entry.Published.Format(time.RFC3339),
+			Updated:   entry.Updated.Format(time.RFC3339),
+			Content:   atomContent{Type: "html", Content: string(entry.ContentHTML)},
+		}
+		for _, tag := range entry.Tags {
+			atom.Categories = append(atom.Categories, atomCategory{Term: tag})
+		}
+		doc.Entries = append(doc.Entries, atom)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string     `xml:"title"`
+	Link          string     `xml:"link"`
+	Description   string     `xml:"description"`
+	LastBuildDate string     `xml:"lastBuildDate"`
+	Self          atomLink   `xml:"http://www.w3.org/2005/Atom link"`
+	Items         []*rssItem `xml:"item"`
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Id          string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Guid        rssGuid  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Creator     string   `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Categories  []string `xml:"category"`
+	Description string   `xml:"description"`
+}
+
+// RenderRSS writes the channel as an RSS 2.0 feed.
+func (channel *FeedChannel) RenderRSS(w io.Writer) error {
+	feed := channel.feed
+	doc := &rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         channel.Title,
+			Link:          feed.absolute(channel.home),
+			Description:   channel.Title,
+			LastBuildDate: channel.Updated().Format(time.RFC1123Z),
+			Self:          atomLink{Rel: "self", Type: "application/rss+xml", Href: channel.url("rss.xml")},
+			Items:         []*rssItem{},
+		},
+	}
+
+	for _, entry := range channel.Entries {
+		name, _ := feed.authorOf(entry)
+		doc.Channel.Items = append(doc.Channel.Items, &rssItem{
+			Title:       entry.Title,
+			Link:        feed.absolute(entry.Url),
+			Guid:        rssGuid{Id: entry.Id},
+			PubDate:     entry.Published.Format(time.RFC1123Z),
+			Creator:     name,
+			Categories:  entry.Tags,
+			Description: string(entry.ContentHTML),
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
+
+type jsonFeed struct {
+	Version     string      `json:"version"`
+	Title       string      `json:"title"`
+	HomePageUrl string      `json:"home_page_url"`
+	FeedUrl     string      `json:"feed_url"`
+	Items       []*jsonItem `json:"items"`
+}
+
+type jsonAuthor struct {
+	Name string `json:"name"`
+	Url  string `json:"url,omitempty"`
+}
+
+type jsonItem struct {
+	Id            string       `json:"id"`
+	Url           string       `json:"url"`
+	Title         string       `json:"title"`
+	ContentHTML   string       `json:"content_html"`
+	DatePublished string       `json:"date_published"`
+	DateModified  string       `json:"date_modified"`
+	Authors       []jsonAuthor `json:"authors"`
+	Tags          []string     `json:"tags,omitempty"`
+}
+
+// RenderJSON writes the channel as a JSON Feed, version 1.1.
+func (channel *FeedChannel) RenderJSON(w io.Writer) error {
+	feed := channel.feed
+	doc := &jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       channel.Title,
+		HomePageUrl: feed.absolute(channel.home),
+		FeedUrl:     channel.url("feed.json"),
+		Items:       []*jsonItem{},
+	}
+
+	for _, entry := range channel.Entries {
+		name, uri := feed.authorOf(entry)
+		doc.Items = append(doc.Items, &jsonItem{
+			Id:            entry.Id,
+			Url:           feed.absolute(entry.Url),
+			Title:         entry.Title,
+			ContentHTML:   string(entry.ContentHTML),
+			DatePublished: entry.Published.Format(time.RFC3339),
+			DateModified:  entry.Updated.Format(time.RFC3339),
+			Authors:       []jsonAuthor{{Name: name, Url: uri}},
+			Tags:          entry.Tags,
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
@@ -0,0 +1,108 @@
+package main_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhamidi/blog"
+)
+
+func TestFeed_RenderAtom_ReflectsRewords(t *testing.T) {
+	t.Setenv("BLOG_HOST", "example.com")
+	feed := main.NewFeed(main.NewAuthorsView(&main.AllPostsView{}))
+	publishedAt := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+	feed.HandleEvent(&main.PostPublishedEvent{
+		PostId:      "post-id",
+		Title:       "Hello World",
+		Content:     "First version",
+		PublishedAt: publishedAt,
+	})
+	feed.HandleEvent(&main.PostRewordedEvent{
+		PostId:          "post-id",
+		RewordedContent: "Second *version*",
+		RewordedAt:      publishedAt.Add(time.Hour),
+	})
+
+	out := &bytes.Buffer{}
+	if err := feed.All().RenderAtom(out); err != nil {
+		t.Fatal(err)
+	}
+	atom := out.String()
+
+	for _, expected := range []string{
+		"<id>urn:uuid:post-id</id>",
+		"<published>2016-05-01T12:00:00Z</published>",
+		"<updated>2016-05-01T13:00:00Z</updated>",
+		"Second &lt;em&gt;version&lt;/em&gt;",
+		`href="http://example.com/posts/hello-world.html"`,
+	} {
+		if !strings.Contains(atom, expected) {
+			t.Errorf("Expected feed to contain %q:\n%s", expected, atom)
+		}
+	}
+}
+
+func TestFeed_ForTag_FollowsTagChanges(t *testing.T) {
+	feed := main.NewFeed(main.NewAuthorsView(&main.AllPostsView{}))
+	publishedAt := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+	feed.HandleEvent(&main.PostPublishedEvent{PostId: "go-post", Title: "Go", Tags: []string{"go"}, PublishedAt: publishedAt})
+	feed.HandleEvent(&main.PostPublishedEvent{PostId: "other-post", Title: "Other", PublishedAt: publishedAt.Add(time.Hour)})
+
+	if channel := feed.ForTag("go"); channel == nil || len(channel.Entries) != 1 || channel.Entries[0].PostId != "go-post" {
+		t.Fatalf("Expected only go-post in channel, got %#v", channel)
+	}
+
+	taggedAt := publishedAt.Add(2 * time.Hour)
+	feed.HandleEvent(&main.PostTaggedEvent{PostId: "other-post", TagName: "go", TaggedAt: taggedAt})
+	channel := feed.ForTag("go")
+	if len(channel.Entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(channel.Entries))
+	}
+	if !channel.Updated().Equal(taggedAt) {
+		t.Fatalf("Expected channel to be updated at %s, got %s", taggedAt, channel.Updated())
+	}
+
+	feed.HandleEvent(&main.PostUntaggedEvent{PostId: "go-post", TagName: "go", UntaggedAt: taggedAt})
+	feed.HandleEvent(&main.PostUntaggedEvent{PostId: "other-post", TagName: "go", UntaggedAt: taggedAt})
+	if channel := feed.ForTag("go"); channel != nil {
+		t.Fatalf("Expected no channel, got %#v", channel)
+	}
+}
+
+func TestFeed_CommentsOn_OnlyPublishedComments(t *testing.T) {
+	feed := main.NewFeed(main.NewAuthorsView(&main.AllPostsView{}))
+	now := time.Now()
+	feed.HandleEvent(&main.PostPublishedEvent{PostId: "post-id", Title: "Hello World", PublishedAt: now})
+	for _, id := range []string{"published", "pending"} {
+		feed.HandleEvent(&main.PostCommentedEvent{
+			PostId:      "post-id",
+			CommentId:   id,
+			AuthorName:  "John",
+			AuthorEmail: "john@example.com",
+			Content:     "Nice <script>alert(1)</script>",
+			CommentedAt: now,
+		})
+	}
+	feed.HandleEvent(&main.PostCommentAuthenticatedEvent{PostId: "post-id", CommentId: "published", AuthenticatedAt: now})
+	feed.HandleEvent(&main.PostCommentAuthenticatedEvent{PostId: "post-id", CommentId: "pending", AuthenticatedAt: now, PendingApproval: true})
+
+	channel := feed.CommentsOn("hello-world")
+	if len(channel.Entries) != 1 || channel.Entries[0].Id != "urn:uuid:published" {
+		t.Fatalf("Expected only the published comment, got %#v", channel.Entries)
+	}
+
+	out := &bytes.Buffer{}
+	if err := channel.RenderAtom(out); err != nil {
+		t.Fatal(err)
+	}
+	if atom := out.String(); strings.Contains(atom, "john@example.com") || strings.Contains(atom, "script") {
+		t.Fatalf("Expected no email address and sanitized content:\n%s", atom)
+	}
+
+	feed.HandleEvent(&main.PostCommentHiddenEvent{PostId: "post-id", CommentId: "published", HiddenAt: now})
+	if channel := feed.AllComments(); len(channel.Entries) != 0 {
+		t.Fatalf("Expected hidden comment to be removed, got %#v", channel.Entries)
+	}
+}
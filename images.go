@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/russross/blackfriday"
+)
+
+// imageWidths are the widths into which uploaded images are resized.
+var imageWidths = []int{320, 640, 1024, 1600}
+
+// imageSizes is the value of the sizes attribute of images in posts,
+// matching the maximum width of the main content.
+const imageSizes = "(max-width: 40em) 100vw, 40em"
+
+// resizable returns true if images of mimeType can be decoded and
+// encoded with the standard library.
+func resizable(mimeType string) bool {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// maxImagePixels is the maximum number of pixels of an image that is
+// resized, counting all frames of animated GIFs.  Decoding an image
+// takes four bytes of memory per pixel, no matter how well it has been
+// compressed.
+const maxImagePixels = 40000000
+
+// decodeImageConfig returns the dimensions of an image and whether it
+// is an animated GIF.  Images with more than maxImagePixels pixels are
+// refused with ErrTooLarge without decoding them.
+func decodeImageConfig(mimeType string, content []byte) (width, height int, animated bool, err error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return 0, 0, false, err
+	}
+	pixels := config.Width * config.Height
+	if config.Width > maxImagePixels || config.Height > maxImagePixels || pixels > maxImagePixels {
+		return 0, 0, false, ErrTooLarge
+	}
+
+	if mimeType == "image/gif" {
+		frames, framePixels, err := scanGIF(content)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		if framePixels > maxImagePixels {
+			return 0, 0, false, ErrTooLarge
+		}
+		animated = frames > 1
+	}
+
+	return config.Width, config.Height, animated, nil
+}
+
+// scanGIF returns the number of frames in a GIF and their total number
+// of pixels by walking through its blocks without decompressing them.
+func scanGIF(content []byte) (frames, pixels int, err error) {
+	errMalformed := fmt.Errorf("scanGIF: malformed GIF")
+	if len(content) < 13 {
+		return 0, 0, errMalformed
+	}
+
+	// colorTable returns the size of the color table announced by
+	// the packed fields of a descriptor.
+	colorTable := func(packed byte) int {
+		if packed&0x80 == 0 {
+			return 0
+		}
+		return 3 << (packed&0x07 + 1)
+	}
+	// skipSubBlocks returns the position after the data sub-blocks
+	// starting at i.
+	skipSubBlocks := func(i int) int {
+		for i < len(content) && content[i] != 0 {
+			i += int(content[i]) + 1
+		}
+		return i + 1
+	}
+
+	i := 13 + colorTable(content[10])
+	for i < len(content) {
+		switch content[i] {
+		case 0x21:
+			i = skipSubBlocks(i + 2)
+		case 0x2c:
+			if i+10 > len(content) {
+				return 0, 0, errMalformed
+			}
+			width := int(content[i+5]) | int(content[i+6])<<8
+			height := int(content[i+7]) | int(content[i+8])<<8
+			frames++
+			pixels += width * height
+			i = skipSubBlocks(i + 10 + colorTable(content[i+9]) + 1)
+		case 0x3b:
+			return frames, pixels, nil
+		default:
+			return 0, 0, errMalformed
+		}
+	}
+
+	return frames, pixels, nil
+}
+
+// variantExtension returns the extension of resized images.  GIFs are
+// resized to PNGs, which keeps more colors.
+func variantExtension(mimeType string) string {
+	if mimeType == "image/jpeg" {
+		return ".jpg"
+	}
+	return ".png"
+}
+
+func variantUrl(hash, mimeType string, width int) *url.URL {
+	return &url.URL{Path: fmt.Sprintf("/media/%s-%dw%s", hash, width, variantExtension(mimeType))}
+}
+
+// resizeImage scales src down to width, keeping its aspect ratio.
+// Every pixel of the result is the average of the pixels of src it
+// covers.
+func resizeImage(src image.Image, width int) *image.RGBA {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	height := (srcH*width + srcW/2) / srcW
+	if height < 1 {
+		height = 1
+	}
+
+	rgba, ok := src.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(image.Rect(0, 0, srcW, srcH))
+		draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+	}
+	origin := rgba.Bounds().Min
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := span(y, height, srcH)
+		for x := 0; x < width; x++ {
+			x0, x1 := span(x, width, srcW)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				i := rgba.PixOffset(origin.X+x0, origin.Y+sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint64(rgba.Pix[i])
+					g += uint64(rgba.Pix[i+1])
+					b += uint64(rgba.Pix[i+2])
+					a += uint64(rgba.Pix[i+3])
+					n++
+					i += 4
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+// span returns the range of source pixels covered by destination pixel
+// i when scaling from srcSize to dstSize.
+func span(i, dstSize, srcSize int) (int, int) {
+	from := i * srcSize / dstSize
+	to := (i + 1) * srcSize / dstSize
+	if to <= from {
+		to = from + 1
+	}
+	return from, to
+}
+
+// VariantPath returns the name of the file holding the image with hash
+// resized to width.
+func (store *MediaStore) VariantPath(hash, mimeType string, width int) string {
+	return fmt.Sprintf("%s-%dw%s", store.Path(hash), width, variantExtension(mimeType))
+}
+
+// PutVariants stores the image with hash resized to each of widths.
+// Images are resized when they are uploaded, so that requests for them
+// never cause decoding an image.
+func (store *MediaStore) PutVariants(hash, mimeType string, widths []int) error {
+	if len(widths) == 0 {
+		return nil
+	}
+
+	original, err := store.Open(hash)
+	if err != nil {
+		return err
+	}
+	defer original.Close()
+
+	config, _, err := image.DecodeConfig(original)
+	if err != nil {
+		return fmt.Errorf("MediaStore.PutVariants: %s", err)
+	}
+	if config.Width*config.Height > maxImagePixels || config.Width > maxImagePixels || config.Height > maxImagePixels {
+		return ErrTooLarge
+	}
+	if _, err := original.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("MediaStore.PutVariants: %s", err)
+	}
+
+	src, _, err := image.Decode(original)
+	if err != nil {
+		return fmt.Errorf("MediaStore.PutVariants: %s", err)
+	}
+
+	for _, width := range widths {
+		if err := store.putVariant(src, store.VariantPath(hash, mimeType, width), mimeType, width); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (store *MediaStore) putVariant(src image.Image, filename, mimeType string, width int) error {
+	if _, err := os.Stat(filename); err == nil {
+		return nil
+	}
+
+	out := bytes.NewBuffer(nil)
+	resized := resizeImage(src, width)
+	var err error
+	if mimeType == "image/jpeg" {
+		err = jpeg.Encode(out, resized, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(out, resized)
+	}
+	if err != nil {
+		return fmt.Errorf("MediaStore.PutVariants: %s", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "resize-")
+	if err != nil {
+		return fmt.Errorf("MediaStore.PutVariants: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(out.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("MediaStore.PutVariants: %s", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("MediaStore.PutVariants: %s", err)
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
+
+// authorContentRenderer renders images that have been uploaded to the
+// media library with a srcset listing their resized variants.
+type authorContentRenderer struct {
+	blackfriday.Renderer
+
+	// images looks up uploaded images by their URL.
+	images interface {
+		ByUrl(path string) *MediaItem
+	}
+}
+
+func (renderer *authorContentRenderer) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte) {
+	var item *MediaItem
+	if renderer.images != nil {
+		item = renderer.images.ByUrl(string(link))
+	}
+	if item == nil || item.Width == 0 {
+		renderer.Renderer.Image(out, link, title, alt)
+		return
+	}
+
+	fmt.Fprintf(out, `<img src="%s" alt="%s"`, html.EscapeString(string(link)), html.EscapeString(string(alt)))
+	if len(title) > 0 {
+		fmt.Fprintf(out, ` title="%s"`, html.EscapeString(string(title)))
+	}
+	if srcset := item.Srcset(); srcset != "" {
+		fmt.Fprintf(out, ` srcset="%s" sizes="%s"`, html.EscapeString(srcset), imageSizes)
+	}
+	fmt.Fprintf(out, ` width="%d" height="%d" />`, item.Width, item.Height)
+}
+
+// Widths returns the widths to which item can be resized.
+func (item *MediaItem) Widths() []int {
+	return variantWidths(item.MimeType, item.Width, item.Animated)
+}
+
+// variantWidths returns the widths to which an image of mimeType that
+// is width pixels wide is resized.
+func variantWidths(mimeType string, width int, animated bool) []int {
+	widths := []int{}
+	if !resizable(mimeType) || animated {
+		return widths
+	}
+
+	for _, w := range imageWidths {
+		if w < width {
+			widths = append(widths, w)
+		}
+	}
+	return widths
+}
+
+// Srcset returns the value of the srcset attribute for item, or the
+// empty string if it has not been resized.
+func (item *MediaItem) Srcset() string {
+	widths := item.Widths()
+	if len(widths) == 0 {
+		return ""
+	}
+
+	sources := []string{}
+	for _, width := range widths {
+		sources = append(sources, fmt.Sprintf("%s %dw", variantUrl(item.Hash, item.MimeType, width), width))
+	}
+	sources = append(sources, fmt.Sprintf("%s %dw", item.Url, item.Width))
+
+	return strings.Join(sources, ", ")
+}
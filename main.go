@@ -1,13 +1,26 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/dhamidi/blog/eventstore"
+	"golang.org/x/crypto/bcrypt"
 
 	// expvar is imported for registering its HTTP handler.
 	_ "expvar"
@@ -17,6 +30,10 @@ func respondWithError(w http.ResponseWriter, err error) {
 	switch err {
 	case ErrNotFound:
 		http.Error(w, err.Error(), http.StatusNotFound)
+	case ErrTooManyRequests:
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case ErrForbidden:
+		http.Error(w, err.Error(), http.StatusForbidden)
 	default:
 		if strings.HasPrefix(err.Error(), "ValidationError") {
 			w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -28,29 +45,288 @@ func respondWithError(w http.ResponseWriter, err error) {
 	}
 }
 
-func authenticated(w http.ResponseWriter, req *http.Request) bool {
-	user, pass, ok := req.BasicAuth()
-	if !ok || !validUser(user, pass) {
-		w.Header().Set("WWW-Authenticate", "Basic realm=\"Administration\"")
+// serveFeed writes channel in the format named by format, e.g.
+// "atom.xml".  Feed readers polling for changes get a 304 response if
+// the channel has not changed since they last fetched it.
+func serveFeed(w http.ResponseWriter, req *http.Request, channel *FeedChannel, format string) {
+	contentType, supported := feedContentTypes[format]
+	if channel == nil || !supported {
+		respondWithError(w, ErrNotFound)
+		return
+	}
+
+	out := &bytes.Buffer{}
+	if err := channel.Render(format, out); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	sum := sha256.Sum256(out.Bytes())
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	http.ServeContent(w, req, "", channel.Updated(), bytes.NewReader(out.Bytes()))
+}
+
+// authenticate returns the principal who is logged in in the session
+// req has been made in.  Without a session, pages are redirected to the
+// login page.
+func authenticate(app *Application, w http.ResponseWriter, req *http.Request) *Principal {
+	if sessionId := app.sessionId(req); sessionId != "" {
+		if login := app.types.sessions.Login(sessionId, time.Now()); login != "" {
+			principal := app.PrincipalFor(login)
+			if app.types.sessions.Admin(sessionId) {
+				principal = nil
+				if app.types.sessions.AdminCredentials(sessionId) == app.adminCredentials() {
+					principal = adminPrincipal(login)
+				}
+			}
+			if principal != nil {
+				return principal
+			}
+		}
+	}
+
+	if req.Method == "GET" {
+		http.Redirect(w, req, "/admin/login?next="+url.QueryEscape(req.URL.RequestURI()), http.StatusSeeOther)
+	} else {
 		http.Error(w, "Login required.", http.StatusUnauthorized)
-		return false
 	}
+	return nil
+}
+
+// requirePermission is like authenticate, but only lets principals
+// with permission through.
+func requirePermission(app *Application, w http.ResponseWriter, req *http.Request, permission string) *Principal {
+	principal := authenticate(app, w, req)
+	if principal != nil && !principal.May(permission) {
+		respondWithError(w, ErrForbidden)
+		return nil
+	}
+
+	return principal
+}
 
-	return true
+// commentReceived renders the page shown after commenting, which
+// allows the commenter to request another authentication mail.
+func commentReceived(app *Application, postId, commentId, email string, resent bool) []byte {
+	return renderTemplate("views/comment_received.html", map[string]interface{}{
+		"Post":            app.views.allPosts.ById(postId),
+		"Email":           email,
+		"Moderated":       app.types.posts.Config().RequireApproval,
+		"CommentId":       commentId,
+		"ResendSignature": app.signer.SignResendAuthentication(commentId),
+		"Resent":          resent,
+	})
+}
+
+// manageComment handles the pages linked to from the management link
+// that is sent to the author of a comment.
+func manageComment(app *Application, w http.ResponseWriter, req *http.Request, commentId, action string) {
+	signature := req.FormValue("signature")
+	postId := app.types.posts.PostForComment(commentId)
+
+	switch req.Method {
+	case "GET":
+		if action != "manage" {
+			respondWithError(w, ErrNotFound)
+			return
+		}
+		if !app.signer.VerifyCommentManagement(signature, commentId) {
+			respondWithError(w, ValidationError{}.Add("Signature", ErrInvalidSignature))
+			return
+		}
+
+		view, err := app.views.allPosts.manageCommentViewFor(postId, commentId, signature, app.types.posts.Config())
+		if err != nil {
+			respondWithError(w, err)
+		} else {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(view.RenderHTML())
+		}
+	case "POST":
+		if action == "resend" {
+			cmd := &ResendCommentAuthenticationCommand{
+				CommentId: commentId,
+				Signature: signature,
+			}
+			if events, err := app.HandleCommand(cmd); err != nil {
+				respondWithError(w, err)
+			} else {
+				requested := events.Items()[0].(*CommentAuthenticationRequestedEvent)
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.Write(commentReceived(app, postId, commentId, requested.AuthorEmail, true))
+			}
+			return
+		}
+
+		var cmd Command
+		switch action {
+		case "edit":
+			cmd = &EditCommentCommand{
+				CommentId: commentId,
+				Content:   req.FormValue("content"),
+				Signature: signature,
+			}
+		case "withdraw":
+			cmd = &WithdrawCommentCommand{
+				CommentId: commentId,
+				Signature: signature,
+			}
+		default:
+			respondWithError(w, ErrNotFound)
+			return
+		}
+
+		if _, err := app.HandleCommand(cmd); err != nil {
+			respondWithError(w, err)
+		} else {
+			post := app.views.allPosts.ById(postId)
+			http.Redirect(w, req, post.Url.String(), http.StatusSeeOther)
+		}
+	default:
+		http.Error(w, "Only GET,POST is allowed.", http.StatusMethodNotAllowed)
+	}
+}
+
+// authorName returns the name under which the author of the blog
+// comments on posts.
+func authorName() string {
+	if name := os.Getenv("BLOG_AUTHOR_NAME"); name != "" {
+		return name
+	}
+
+	return os.Getenv("BLOG_ADMIN_USER")
+}
+
+// validUser returns true if user and pass are the credentials of the
+// administrator configured through the environment.  The password is
+// compared against the hash in BLOG_ADMIN_PASS_HASH, or the plain text
+// password in BLOG_ADMIN_PASS if no hash has been set.
+// examplePassword is the admin password given as an example in CONFIG,
+// which is publicly known and therefore refused.
+const examplePassword = "change-me"
+
+// exampleAdminPassword returns true if the admin password configured
+// through the environment is examplePassword.
+func exampleAdminPassword() bool {
+	if hash := os.Getenv("BLOG_ADMIN_PASS_HASH"); hash != "" {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(examplePassword)) == nil
+	}
+
+	return os.Getenv("BLOG_ADMIN_PASS") == examplePassword
 }
 
 func validUser(user, pass string) bool {
 	expectedUser := os.Getenv("BLOG_ADMIN_USER")
-	expectedPass := os.Getenv("BLOG_ADMIN_PASS")
+	userMatches := subtle.ConstantTimeCompare([]byte(user), []byte(expectedUser)) == 1
+
+	passMatches := false
+	if hash := os.Getenv("BLOG_ADMIN_PASS_HASH"); hash != "" {
+		passMatches = bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+	} else if expectedPass := os.Getenv("BLOG_ADMIN_PASS"); expectedPass != "" {
+		passMatches = subtle.ConstantTimeCompare([]byte(pass), []byte(expectedPass)) == 1
+	}
 
-	if expectedUser == "" || expectedPass == "" {
-		return false
+	return expectedUser != "" && userMatches && passMatches
+}
+
+// safeRedirect returns next if it points to a page of the
+// administration, and the administration's start page otherwise.
+func safeRedirect(next string) string {
+	if strings.HasPrefix(next, "/admin") && !strings.HasPrefix(next, "//") {
+		return next
+	}
+
+	return "/admin/"
+}
+
+// passwd asks for a password and either prints its hash for
+// BLOG_ADMIN_PASS_HASH or, if a login is given, sets it as the
+// password of that author.
+func passwd(args []string) error {
+	fmt.Fprintf(os.Stderr, "Password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	password = strings.TrimRight(password, "\r\n")
+
+	if len(args) == 0 {
+		if len(password) < minPasswordLength {
+			return ValidationError{}.Add("Password", ErrTooShort)
+		}
+		hash, err := hashPassword(password)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("BLOG_ADMIN_PASS_HASH='%s'\n", hash)
+		return nil
+	}
+
+	store, err := eventstore.NewOnDisk("_events")
+	if err != nil {
+		return err
+	}
+
+	app := Application{Store: store}
+	if err := app.Init(); err != nil {
+		return err
+	}
+
+	author := app.views.authors.ByLogin(args[0])
+	if author == nil {
+		return ErrNotFound
+	}
+
+	_, err = app.HandleCommand(&ChangePasswordCommand{
+		AuthorId: author.Id,
+		Password: password,
+	})
+	return err
+}
+
+// orderedByPosition sorts ids by the numeric position given for each
+// id at the same index in positions.
+func orderedByPosition(ids, positions []string) []string {
+	type entry struct {
+		id       string
+		position int
+	}
+
+	entries := []entry{}
+	for i, id := range ids {
+		position := len(ids)
+		if i < len(positions) {
+			if n, err := strconv.Atoi(positions[i]); err == nil {
+				position = n
+			}
+		}
+		entries = append(entries, entry{id: id, position: position})
 	}
 
-	return user == expectedUser && pass == expectedPass
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].position < entries[j].position
+	})
+
+	result := []string{}
+	for _, e := range entries {
+		result = append(result, e.id)
+	}
+	return result
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "passwd" {
+		if err := passwd(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if os.Getenv("BLOG_ADMIN_PASS_HASH") == "" && os.Getenv("BLOG_ADMIN_PASS") != "" {
+		log.Printf("BLOG_ADMIN_PASS is stored in plain text, use \"blog passwd\" to set BLOG_ADMIN_PASS_HASH instead\n")
+	}
+
 	assetServer := http.FileServer(http.Dir("assets"))
 
 	store, err := eventstore.NewOnDisk("_events")
@@ -64,7 +340,28 @@ func main() {
 	}
 
 	http.HandleFunc("/comments/", func(w http.ResponseWriter, req *http.Request) {
-		commentId := req.URL.Path[len("/comments/"):]
+		action := ""
+		fields := strings.Split(req.URL.Path[len("/comments/"):], "/")
+		commentId := fields[0]
+		if len(fields) > 1 {
+			action = fields[1]
+		}
+
+		if action != "" {
+			manageComment(&app, w, req, commentId, action)
+			return
+		}
+		if _, isFeed := feedContentTypes[commentId]; isFeed && (req.Method == "GET" || req.Method == "HEAD") {
+			serveFeed(w, req, app.views.feed.AllComments(), commentId)
+			return
+		}
+
+		token := commentId
+		commentId, err := app.signer.VerifyCommentAuthenticationToken(token)
+		if err != nil {
+			respondWithError(w, ValidationError{}.Add("Token", err))
+			return
+		}
 		postId := app.types.posts.IdForComment(commentId)
 
 		switch req.Method {
@@ -78,7 +375,7 @@ func main() {
 			}
 		case "POST":
 			cmd := &PostAuthenticateCommentCommand{
-				CommentId: commentId,
+				Token: token,
 			}
 
 			if _, err := app.HandleCommand(cmd); err != nil {
@@ -97,21 +394,23 @@ func main() {
 		switch req.Method {
 		case "POST":
 			cmd := &CommentOnPostCommand{
-				PostId:  req.FormValue("post_id"),
-				Author:  req.FormValue("author"),
-				Email:   req.FormValue("email"),
-				Content: req.FormValue("content"),
+				PostId:   req.FormValue("post_id"),
+				ParentId: req.FormValue("parent_id"),
+				Author:   req.FormValue("author"),
+				Email:    req.FormValue("email"),
+				Content:  req.FormValue("content"),
+
+				RemoteAddr: remoteIP(req, os.Getenv("BLOG_PROXY") != ""),
+				Honeypot:   req.FormValue("website"),
+				FormToken:  req.FormValue("form_token"),
 			}
 
-			if _, err := app.HandleCommand(cmd); err != nil {
+			if events, err := app.HandleCommand(cmd); err != nil {
 				respondWithError(w, err)
 			} else {
-				post := app.views.allPosts.ById(cmd.PostId)
+				commented := events.Items()[0].(*PostCommentedEvent)
 				w.Header().Set("Content-Type", "text/html; charset=utf-8")
-				w.Write(renderTemplate("views/comment_received.html", map[string]interface{}{
-					"Post":  post,
-					"Email": cmd.Email,
-				}))
+				w.Write(commentReceived(&app, commented.PostId, commented.CommentId, cmd.Email, false))
 			}
 		default:
 			http.Error(w, "Only POST is allowed.", http.StatusMethodNotAllowed)
@@ -122,17 +421,51 @@ func main() {
 	http.HandleFunc("/posts.html", func(w http.ResponseWriter, req *http.Request) {
 		switch req.Method {
 		case "GET":
+			page, err := Paginate(app.views.allPosts.Collection, pageNumber(req), app.pageSize, indexPageUrl)
+			if err != nil {
+				respondWithError(w, err)
+				return
+			}
 			w.Header().Set("Content-Type", "text/html; charset=utf-8")
-			w.Write(app.views.allPosts.RenderHTML())
+			w.Write(app.views.allPosts.RenderHTML(page))
 		default:
 			http.Error(w, "Only GET is allowed.", http.StatusMethodNotAllowed)
 		}
 	})
 
-	http.HandleFunc("/posts/", func(w http.ResponseWriter, req *http.Request) {
+	http.HandleFunc("/page/", func(w http.ResponseWriter, req *http.Request) {
 		switch req.Method {
 		case "GET":
+			fields := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+			number, err := strconv.Atoi(fields[len(fields)-1])
+			if len(fields) != 2 || err != nil {
+				respondWithError(w, ErrNotFound)
+				return
+			}
+			if number == 1 {
+				http.Redirect(w, req, indexPageUrl(1).String(), http.StatusMovedPermanently)
+				return
+			}
+			page, err := Paginate(app.views.allPosts.Collection, number, app.pageSize, indexPageUrl)
+			if err != nil {
+				respondWithError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(app.views.allPosts.RenderHTML(page))
+		default:
+			http.Error(w, "Only GET is allowed.", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/posts/", func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case "GET", "HEAD":
 			fields := strings.Split(req.URL.Path, "/")
+			if len(fields) == 4 {
+				serveFeed(w, req, app.views.feed.CommentsOn(fields[2]), fields[3])
+				return
+			}
 			postSlug := strings.Replace(fields[len(fields)-1], ".html", "", 1)
 			view := app.views.allPosts.BySlug(postSlug)
 			if view == nil {
@@ -146,8 +479,688 @@ func main() {
 		}
 	})
 
+	http.HandleFunc("/search", func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case "GET":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(app.views.search.Search(truncateQuery(req.FormValue("q"))).RenderHTML())
+		default:
+			http.Error(w, "Only GET is allowed.", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/tags.html", func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case "GET":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(app.views.tags.RenderHTML())
+		default:
+			http.Error(w, "Only GET is allowed.", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/tags/", func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case "GET", "HEAD":
+			fields := strings.Split(req.URL.Path, "/")
+			if len(fields) == 4 {
+				serveFeed(w, req, app.views.feed.ForTag(fields[2]), fields[3])
+				return
+			}
+			tagName := strings.Replace(fields[len(fields)-1], ".html", "", 1)
+			view := app.views.tags.ByName(tagName)
+			if view == nil {
+				respondWithError(w, ErrNotFound)
+				return
+			}
+			page, err := Paginate(view.Posts, pageNumber(req), app.pageSize, queryPageUrl(view.Url))
+			if err != nil {
+				respondWithError(w, err)
+			} else {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.Write(view.RenderHTML(page))
+			}
+		default:
+			http.Error(w, "Only GET is allowed.", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/archive/", func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case "GET":
+			fields := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+			if len(fields) == 1 {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.Write(app.views.archive.RenderHTML())
+				return
+			}
+
+			var page *Pagination
+			var err error = ErrNotFound
+			var render func(*Pagination) []byte
+			switch len(fields) {
+			case 2:
+				if year := app.views.archive.ByYear(fields[1]); year != nil {
+					page, err = Paginate(year.Posts, pageNumber(req), app.pageSize, queryPageUrl(year.Url))
+					render = year.RenderHTML
+				}
+			case 3:
+				if month := app.views.archive.ByMonth(fields[1], fields[2]); month != nil {
+					page, err = Paginate(month.Posts, pageNumber(req), app.pageSize, queryPageUrl(month.Url))
+					render = month.RenderHTML
+				}
+			}
+			if err != nil {
+				respondWithError(w, err)
+			} else {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.Write(render(page))
+			}
+		default:
+			http.Error(w, "Only GET is allowed.", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/authors/", func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case "GET", "HEAD":
+			fields := strings.Split(req.URL.Path, "/")
+			if len(fields) == 4 {
+				serveFeed(w, req, app.views.feed.ForAuthor(fields[2]), fields[3])
+				return
+			}
+			login := strings.Replace(fields[len(fields)-1], ".html", "", 1)
+			view := app.views.authors.ByLogin(login)
+			if view == nil {
+				respondWithError(w, ErrNotFound)
+			} else {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.Write(view.RenderHTML())
+			}
+		default:
+			http.Error(w, "Only GET is allowed.", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/series/", func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case "GET":
+			fields := strings.Split(req.URL.Path, "/")
+			seriesSlug := strings.Replace(fields[len(fields)-1], ".html", "", 1)
+			view := app.views.series.BySlug(seriesSlug)
+			if view == nil {
+				respondWithError(w, ErrNotFound)
+			} else {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.Write(view.RenderHTML())
+			}
+		default:
+			http.Error(w, "Only GET is allowed.", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/admin/series/", func(w http.ResponseWriter, req *http.Request) {
+		principal := requirePermission(&app, w, req, PermManageSite)
+		if principal == nil {
+			return
+		}
+
+		action := ""
+		fields := strings.Split(req.URL.Path[len("/admin/series/"):], "/")
+		seriesId := fields[0]
+		if len(fields) > 1 {
+			action = fields[1]
+		}
+
+		switch req.Method {
+		case "POST":
+			var cmd Command
+			switch action {
+			case "posts":
+				position, _ := strconv.Atoi(req.FormValue("position"))
+				cmd = &AddPostToSeriesCommand{
+					SeriesId: seriesId,
+					PostId:   req.FormValue("post_id"),
+					Position: position,
+				}
+			case "reorder":
+				req.ParseForm()
+				cmd = &ReorderSeriesCommand{
+					SeriesId: seriesId,
+					PostIds:  orderedByPosition(req.Form["post_id"], req.Form["position"]),
+				}
+			default:
+				respondWithError(w, ErrNotFound)
+				return
+			}
+
+			if _, err := app.HandleCommandAs(principal, cmd); err != nil {
+				respondWithError(w, err)
+			} else {
+				http.Redirect(w, req, "/admin/series", http.StatusSeeOther)
+			}
+		default:
+			http.Error(w, "Only POST is allowed.", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/admin/series", func(w http.ResponseWriter, req *http.Request) {
+		principal := requirePermission(&app, w, req, PermManageSite)
+		if principal == nil {
+			return
+		}
+
+		switch req.Method {
+		case "GET":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(renderTemplate("views/admin_series.html", map[string]interface{}{
+				"Series": app.views.series.Collection,
+				"Posts":  app.views.allPosts.Collection,
+			}))
+		case "POST":
+			cmd := &CreateSeriesCommand{
+				Title:       req.FormValue("title"),
+				Description: req.FormValue("description"),
+			}
+			if _, err := app.HandleCommandAs(principal, cmd); err != nil {
+				respondWithError(w, err)
+			} else {
+				http.Redirect(w, req, "/admin/series", http.StatusSeeOther)
+			}
+		default:
+			http.Error(w, "Only GET,POST is allowed.", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/admin/comments/", func(w http.ResponseWriter, req *http.Request) {
+		principal := requirePermission(&app, w, req, PermModerate)
+		if principal == nil {
+			return
+		}
+
+		action := ""
+		fields := strings.Split(req.URL.Path[len("/admin/comments/"):], "/")
+		commentId := fields[0]
+		if len(fields) > 1 {
+			action = fields[1]
+		}
+		postId := req.FormValue("post_id")
+
+		switch req.Method {
+		case "POST":
+			var cmd Command
+			switch action {
+			case "approve":
+				cmd = &ApproveCommentCommand{PostId: postId, CommentId: commentId}
+			case "reject":
+				cmd = &RejectCommentCommand{PostId: postId, CommentId: commentId}
+			case "hide":
+				cmd = &HideCommentCommand{PostId: postId, CommentId: commentId}
+			case "delete":
+				cmd = &DeleteCommentCommand{PostId: postId, CommentId: commentId}
+			default:
+				respondWithError(w, ErrNotFound)
+				return
+			}
+
+			if _, err := app.HandleCommandAs(principal, cmd); err != nil {
+				respondWithError(w, err)
+			} else {
+				http.Redirect(w, req, "/admin/comments", http.StatusSeeOther)
+			}
+		default:
+			http.Error(w, "Only POST is allowed.", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/admin/comments", func(w http.ResponseWriter, req *http.Request) {
+		principal := authenticate(&app, w, req)
+		if principal == nil {
+			return
+		}
+
+		switch req.Method {
+		case "GET":
+			if !principal.May(PermModerate) {
+				respondWithError(w, ErrForbidden)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(app.views.comments.RenderHTML())
+		case "POST":
+			cmd := &AuthorCommentOnPostCommand{
+				PostId:   req.FormValue("post_id"),
+				ParentId: req.FormValue("parent_id"),
+				Content:  req.FormValue("content"),
+				Author:   authorName(),
+				Email:    os.Getenv("BLOG_AUTHOR_EMAIL"),
+			}
+			if author := app.views.authors.ById(principal.AuthorId); author != nil {
+				cmd.Author, cmd.Email = author.Name, author.Email
+			}
+
+			if _, err := app.HandleCommandAs(principal, cmd); err != nil {
+				respondWithError(w, err)
+			} else {
+				post := app.views.allPosts.ById(cmd.PostId)
+				http.Redirect(w, req, post.Url.String(), http.StatusSeeOther)
+			}
+		default:
+			http.Error(w, "Only GET,POST is allowed.", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/admin/spam/", func(w http.ResponseWriter, req *http.Request) {
+		principal := requirePermission(&app, w, req, PermModerate)
+		if principal == nil {
+			return
+		}
+
+		switch req.Method {
+		case "POST":
+			var cmd Command
+			switch req.URL.Path[len("/admin/spam/"):] {
+			case "block-email":
+				cmd = &BlockEmailCommand{Email: req.FormValue("email")}
+			case "unblock-email":
+				cmd = &UnblockEmailCommand{Email: req.FormValue("email")}
+			case "block-domain":
+				cmd = &BlockDomainCommand{Domain: req.FormValue("domain")}
+			case "unblock-domain":
+				cmd = &UnblockDomainCommand{Domain: req.FormValue("domain")}
+			default:
+				respondWithError(w, ErrNotFound)
+				return
+			}
+
+			if _, err := app.HandleCommandAs(principal, cmd); err != nil {
+				respondWithError(w, err)
+			} else {
+				http.Redirect(w, req, "/admin/spam", http.StatusSeeOther)
+			}
+		default:
+			http.Error(w, "Only POST is allowed.", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/admin/spam", func(w http.ResponseWriter, req *http.Request) {
+		if requirePermission(&app, w, req, PermModerate) == nil {
+			return
+		}
+
+		switch req.Method {
+		case "GET":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(app.types.blocklist.RenderHTML())
+		default:
+			http.Error(w, "Only GET is allowed.", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/admin/pages/", func(w http.ResponseWriter, req *http.Request) {
+		principal := requirePermission(&app, w, req, PermManageSite)
+		if principal == nil {
+			return
+		}
+
+		action := ""
+		fields := strings.Split(req.URL.Path[len("/admin/pages/"):], "/")
+		pageId := fields[0]
+		if len(fields) > 1 {
+			action = fields[1]
+		}
+		page := app.views.pages.ById(pageId)
+
+		if page == nil {
+			respondWithError(w, ErrNotFound)
+			return
+		}
+
+		switch req.Method {
+		case "GET":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(renderTemplate("views/edit_page.html", page))
+		case "POST":
+			var cmd Command
+			switch action {
+			case "":
+				position, _ := strconv.Atoi(req.FormValue("position"))
+				cmd = &EditPageCommand{
+					PageId:     pageId,
+					Title:      req.FormValue("title"),
+					Content:    req.FormValue("content"),
+					Navigation: req.FormValue("navigation") != "",
+					Position:   position,
+				}
+			case "delete":
+				cmd = &DeletePageCommand{PageId: pageId}
+			default:
+				respondWithError(w, ErrNotFound)
+				return
+			}
+
+			if _, err := app.HandleCommandAs(principal, cmd); err != nil {
+				respondWithError(w, err)
+			} else {
+				http.Redirect(w, req, "/admin/pages", http.StatusSeeOther)
+			}
+		default:
+			http.Error(w, "Only GET,POST is allowed.", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/admin/pages", func(w http.ResponseWriter, req *http.Request) {
+		principal := requirePermission(&app, w, req, PermManageSite)
+		if principal == nil {
+			return
+		}
+
+		switch req.Method {
+		case "GET":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(app.views.pages.RenderHTML())
+		case "POST":
+			position, _ := strconv.Atoi(req.FormValue("position"))
+			cmd := &CreatePageCommand{
+				Title:      req.FormValue("title"),
+				Slug:       req.FormValue("slug"),
+				Content:    req.FormValue("content"),
+				Navigation: req.FormValue("navigation") != "",
+				Position:   position,
+			}
+
+			if _, err := app.HandleCommandAs(principal, cmd); err != nil {
+				respondWithError(w, err)
+			} else {
+				http.Redirect(w, req, "/admin/pages", http.StatusSeeOther)
+			}
+		default:
+			http.Error(w, "Only GET,POST is allowed.", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/admin/authors/", func(w http.ResponseWriter, req *http.Request) {
+		principal := authenticate(&app, w, req)
+		if principal == nil {
+			return
+		}
+
+		action := ""
+		fields := strings.Split(req.URL.Path[len("/admin/authors/"):], "/")
+		authorId := fields[0]
+		if len(fields) > 1 {
+			action = fields[1]
+		}
+		author := app.views.authors.ById(authorId)
+
+		if author == nil {
+			respondWithError(w, ErrNotFound)
+			return
+		}
+
+		switch req.Method {
+		case "GET":
+			if !principal.May(PermManageUsers) && principal.AuthorId != authorId {
+				respondWithError(w, ErrForbidden)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			switch action {
+			case "":
+				w.Write(renderTemplate("views/edit_author.html", map[string]interface{}{
+					"Author":    author,
+					"Principal": principal,
+				}))
+			case "two-factor":
+				if principal.AuthorId != authorId {
+					respondWithError(w, ErrForbidden)
+					return
+				}
+				w.Write(NewTwoFactorEnrolment(author).RenderHTML())
+			default:
+				respondWithError(w, ErrNotFound)
+			}
+		case "POST":
+			var cmd Command
+			switch action {
+			case "two-factor":
+				enable := &EnableTwoFactorCommand{
+					AuthorId: authorId,
+					Secret:   req.FormValue("secret"),
+					Code:     req.FormValue("code"),
+				}
+				_, err := app.HandleCommandAs(principal, enable)
+				if verr, invalid := err.(ValidationError); invalid && verr.Len() == 1 && verr.Get("Code") != nil {
+					// Keep the secret, which might already
+					// have been added to the app.
+					w.Header().Set("Content-Type", "text/html; charset=utf-8")
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write((&TwoFactorEnrolment{
+						Author: author,
+						Secret: enable.Secret,
+						URI:    totpURI(enable.Secret, author.Login),
+						Error:  verr.Get("Code"),
+					}).RenderHTML())
+				} else if err != nil {
+					respondWithError(w, err)
+				} else {
+					w.Header().Set("Content-Type", "text/html; charset=utf-8")
+					w.Write((&TwoFactorEnrolment{
+						Author:        author,
+						RecoveryCodes: enable.RecoveryCodes(),
+					}).RenderHTML())
+				}
+				return
+			case "disable-two-factor":
+				cmd = &DisableTwoFactorCommand{
+					AuthorId:   authorId,
+					Code:       req.FormValue("code"),
+					RemoteAddr: remoteIP(req, os.Getenv("BLOG_PROXY") != ""),
+				}
+			case "":
+				cmd = &UpdateAuthorProfileCommand{
+					AuthorId: authorId,
+					Name:     req.FormValue("name"),
+					Email:    req.FormValue("email"),
+					Bio:      req.FormValue("bio"),
+				}
+			case "password":
+				if req.FormValue("password") != req.FormValue("confirmation") {
+					respondWithError(w, ValidationError{}.Add("Confirmation", ErrMismatch))
+					return
+				}
+				cmd = &ChangePasswordCommand{AuthorId: authorId, Password: req.FormValue("password")}
+			case "deactivate":
+				cmd = &DeactivateAuthorCommand{AuthorId: authorId}
+			case "grant":
+				cmd = &GrantRoleCommand{AuthorId: authorId, Role: req.FormValue("role")}
+			case "revoke":
+				cmd = &RevokeRoleCommand{AuthorId: authorId, Role: req.FormValue("role")}
+			default:
+				respondWithError(w, ErrNotFound)
+				return
+			}
+
+			if _, err := app.HandleCommandAs(principal, cmd); err != nil {
+				respondWithError(w, err)
+			} else if principal.May(PermManageUsers) {
+				http.Redirect(w, req, "/admin/authors", http.StatusSeeOther)
+			} else {
+				http.Redirect(w, req, "/admin", http.StatusSeeOther)
+			}
+		default:
+			http.Error(w, "Only GET,POST is allowed.", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/admin/authors", func(w http.ResponseWriter, req *http.Request) {
+		principal := requirePermission(&app, w, req, PermManageUsers)
+		if principal == nil {
+			return
+		}
+
+		switch req.Method {
+		case "GET":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(app.views.authors.RenderHTML())
+		case "POST":
+			req.ParseForm()
+			cmd := &RegisterAuthorCommand{
+				Login:    req.FormValue("login"),
+				Name:     req.FormValue("name"),
+				Email:    req.FormValue("email"),
+				Password: req.FormValue("password"),
+				Roles:    req.Form["role"],
+			}
+
+			if _, err := app.HandleCommandAs(principal, cmd); err != nil {
+				respondWithError(w, err)
+			} else {
+				http.Redirect(w, req, "/admin/authors", http.StatusSeeOther)
+			}
+		default:
+			http.Error(w, "Only GET,POST is allowed.", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/admin/media", func(w http.ResponseWriter, req *http.Request) {
+		principal := authenticate(&app, w, req)
+		if principal == nil {
+			return
+		}
+
+		switch req.Method {
+		case "GET":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(app.views.media.RenderHTML())
+		case "POST":
+			req.Body = http.MaxBytesReader(w, req.Body, maxMediaSize+1<<20)
+			file, header, err := req.FormFile("file")
+			if err != nil {
+				respondWithError(w, ValidationError{}.Add("File", ErrEmpty))
+				return
+			}
+			defer file.Close()
+
+			content, err := ioutil.ReadAll(file)
+			if err != nil {
+				respondWithError(w, ValidationError{}.Add("File", ErrTooLarge))
+				return
+			}
+
+			cmd := &UploadMediaCommand{
+				Name:     header.Filename,
+				MimeType: http.DetectContentType(content),
+				Content:  content,
+			}
+
+			if _, err := app.HandleCommandAs(principal, cmd); err != nil {
+				respondWithError(w, err)
+			} else {
+				http.Redirect(w, req, "/admin/media", http.StatusSeeOther)
+			}
+		default:
+			http.Error(w, "Only GET,POST is allowed.", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/media/", func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case "GET", "HEAD":
+			item, width := app.views.media.VariantByUrl(req.URL.Path)
+			if item == nil {
+				respondWithError(w, ErrNotFound)
+				return
+			}
+
+			// Variants are created when uploading, so images
+			// uploaded before they existed are served in full.
+			filename, contentType := app.media.Path(item.Hash), item.MimeType
+			if width != 0 {
+				variant := app.media.VariantPath(item.Hash, item.MimeType, width)
+				if _, err := os.Stat(variant); err == nil {
+					filename, contentType = variant, "image/png"
+					if item.MimeType == "image/jpeg" {
+						contentType = "image/jpeg"
+					}
+				}
+			}
+
+			file, err := os.Open(filename)
+			if err != nil {
+				respondWithError(w, ErrNotFound)
+				return
+			}
+			defer file.Close()
+
+			// The content of a URL never changes, since it is
+			// addressed by its hash.
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+			w.Header().Set("ETag", `"`+path.Base(req.URL.Path)+`"`)
+			http.ServeContent(w, req, "", time.Time{}, file)
+		default:
+			http.Error(w, "Only GET is allowed.", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/admin/login", func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case "GET":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(renderTemplate("views/login.html", map[string]interface{}{
+				"Next": req.FormValue("next"),
+			}))
+		case "POST":
+			cmd := &LogInCommand{
+				Login:      req.FormValue("login"),
+				Password:   req.FormValue("password"),
+				Code:       req.FormValue("code"),
+				RemoteAddr: remoteIP(req, os.Getenv("BLOG_PROXY") != ""),
+			}
+
+			if _, err := app.HandleCommand(cmd); err == ErrInvalidCredentials || err == ErrCodeRequired || err == ErrInvalidCode {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write(renderTemplate("views/login.html", map[string]interface{}{
+					"Next":  req.FormValue("next"),
+					"Login": cmd.Login,
+					"Error": err,
+				}))
+			} else if err != nil {
+				respondWithError(w, err)
+			} else {
+				http.SetCookie(w, app.SessionCookie(cmd.SessionId()))
+				http.Redirect(w, req, safeRedirect(req.FormValue("next")), http.StatusSeeOther)
+			}
+		default:
+			http.Error(w, "Only GET,POST is allowed.", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/admin/logout", func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case "POST":
+			if sessionId := app.sessionId(req); sessionId != "" {
+				if _, err := app.HandleCommand(&LogOutCommand{SessionId: sessionId}); err != nil && err != ErrNotFound {
+					respondWithError(w, err)
+					return
+				}
+			}
+
+			http.SetCookie(w, &http.Cookie{
+				Name:   sessionCookie,
+				Path:   "/admin",
+				MaxAge: -1,
+			})
+			http.Redirect(w, req, "/admin/login", http.StatusSeeOther)
+		default:
+			http.Error(w, "Only POST is allowed.", http.StatusMethodNotAllowed)
+		}
+	})
+
 	http.HandleFunc("/admin/posts/new", func(w http.ResponseWriter, req *http.Request) {
-		if !authenticated(w, req) {
+		if authenticate(&app, w, req) == nil {
 			return
 		}
 		switch req.Method {
@@ -160,7 +1173,8 @@ func main() {
 	})
 
 	http.HandleFunc("/admin/posts/preview", func(w http.ResponseWriter, req *http.Request) {
-		if !authenticated(w, req) {
+		principal := authenticate(&app, w, req)
+		if principal == nil {
 			return
 		}
 
@@ -170,9 +1184,10 @@ func main() {
 				PublishPostCommand: &PublishPostCommand{
 					Title:   req.FormValue("title"),
 					Content: req.FormValue("content"),
+					Tags:    parseTags(req.FormValue("tags")),
 				},
 			}
-			if _, err := app.HandleCommand(cmd); err != nil {
+			if _, err := app.HandleCommandAs(principal, cmd); err != nil {
 				respondWithError(w, err)
 			} else {
 				w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -184,7 +1199,8 @@ func main() {
 	})
 
 	http.HandleFunc("/admin/posts/", func(w http.ResponseWriter, req *http.Request) {
-		if !authenticated(w, req) {
+		principal := authenticate(&app, w, req)
+		if principal == nil {
 			return
 		}
 
@@ -198,6 +1214,7 @@ func main() {
 
 		if post == nil {
 			respondWithError(w, ErrNotFound)
+			return
 		}
 
 		switch req.Method {
@@ -206,18 +1223,68 @@ func main() {
 			case "reword":
 				w.Header().Set("Content-Type", "text/html; charset=utf-8")
 				w.Write(renderTemplate("views/reword_post.html", post))
+			case "revisions":
+				revisions := app.views.revisions.ForPost(postId)
+				if len(fields) < 3 || fields[2] == "" {
+					w.Header().Set("Content-Type", "text/html; charset=utf-8")
+					w.Write(revisions.RenderHTML(principal.MayChange(post)))
+					return
+				}
+
+				number, _ := strconv.Atoi(fields[2])
+				if revision := revisions.Get(number); revision == nil {
+					respondWithError(w, ErrNotFound)
+				} else {
+					w.Header().Set("Content-Type", "text/html; charset=utf-8")
+					w.Write(revision.RenderHTML(post, principal.MayChange(post)))
+				}
+			case "diff":
+				from, _ := strconv.Atoi(req.FormValue("from"))
+				to, _ := strconv.Atoi(req.FormValue("to"))
+				revisions := app.views.revisions.ForPost(postId)
+				if diff, err := revisions.Diff(from, to, req.FormValue("mode") == "words"); err != nil {
+					respondWithError(w, err)
+				} else {
+					w.Header().Set("Content-Type", "text/html; charset=utf-8")
+					w.Write(diff.RenderHTML())
+				}
 			default:
 				respondWithError(w, ErrNotFound)
 			}
 		case "POST":
-			cmd := &RewordPostCommand{
-				PostId:     postId,
-				Reason:     req.FormValue("reason"),
-				NewContent: req.FormValue("content"),
+			var cmd Command
+			switch action {
+			case "":
+				cmd = &RewordPostCommand{
+					PostId:     postId,
+					Reason:     req.FormValue("reason"),
+					NewContent: req.FormValue("content"),
+				}
+			case "revert":
+				revision, _ := strconv.Atoi(req.FormValue("revision"))
+				cmd = &RevertPostCommand{
+					PostId:   postId,
+					Revision: revision,
+				}
+			case "tag":
+				cmd = &TagPostCommand{
+					PostId: postId,
+					Tag:    req.FormValue("tag"),
+				}
+			case "untag":
+				cmd = &UntagPostCommand{
+					PostId: postId,
+					Tag:    req.FormValue("tag"),
+				}
+			default:
+				respondWithError(w, ErrNotFound)
+				return
 			}
 
-			if _, err := app.HandleCommand(cmd); err != nil {
+			if _, err := app.HandleCommandAs(principal, cmd); err != nil {
 				respondWithError(w, err)
+			} else if action == "revert" {
+				http.Redirect(w, req, "/admin/posts/"+postId+"/revisions", http.StatusSeeOther)
 			} else {
 				http.Redirect(w, req, "/admin", http.StatusSeeOther)
 			}
@@ -227,7 +1294,8 @@ func main() {
 	})
 
 	http.HandleFunc("/admin/posts", func(w http.ResponseWriter, req *http.Request) {
-		if !authenticated(w, req) {
+		principal := authenticate(&app, w, req)
+		if principal == nil {
 			return
 		}
 
@@ -236,8 +1304,9 @@ func main() {
 			cmd := &PublishPostCommand{
 				Title:   req.FormValue("title"),
 				Content: req.FormValue("content"),
+				Tags:    parseTags(req.FormValue("tags")),
 			}
-			if _, err := app.HandleCommand(cmd); err != nil {
+			if _, err := app.HandleCommandAs(principal, cmd); err != nil {
 				respondWithError(w, err)
 			} else {
 				http.Redirect(w, req, "/posts.html", http.StatusSeeOther)
@@ -248,14 +1317,18 @@ func main() {
 	})
 
 	http.HandleFunc("/admin/", func(w http.ResponseWriter, req *http.Request) {
-		if !authenticated(w, req) {
+		principal := authenticate(&app, w, req)
+		if principal == nil {
 			return
 		}
 
 		switch req.Method {
 		case "GET":
 			w.Header().Set("Content-Type", "text/html; charsetf=utf-8")
-			w.Write(renderTemplate("views/admin.html", app.views.allPosts))
+			w.Write(renderTemplate("views/admin.html", map[string]interface{}{
+				"Posts":     app.views.allPosts.Collection,
+				"Principal": principal,
+			}))
 		default:
 			http.Error(w, "Only GET is allowed.", http.StatusMethodNotAllowed)
 		}
@@ -264,8 +1337,13 @@ func main() {
 	http.HandleFunc("/posts", func(w http.ResponseWriter, req *http.Request) {
 		switch req.Method {
 		case "GET":
+			page, err := Paginate(app.views.allPosts.Collection, pageNumber(req), app.pageSize, indexPageUrl)
+			if err != nil {
+				respondWithError(w, err)
+				return
+			}
 			w.Header().Set("Content-Type", "text/html; charset=utf-8")
-			w.Write(app.views.allPosts.RenderHTML())
+			w.Write(app.views.allPosts.RenderHTML(page))
 		default:
 			http.Error(w, "Only GET,POST is allowed.", http.StatusMethodNotAllowed)
 		}
@@ -281,6 +1359,17 @@ func main() {
 		}
 	})
 
+	for format := range feedContentTypes {
+		http.HandleFunc("/"+format, func(w http.ResponseWriter, req *http.Request) {
+			switch req.Method {
+			case "GET", "HEAD":
+				serveFeed(w, req, app.views.feed.All(), path.Base(req.URL.Path))
+			default:
+				http.Error(w, "Only GET is allowed.", http.StatusMethodNotAllowed)
+			}
+		})
+	}
+
 	http.Handle("/index.html", http.RedirectHandler("/posts.html", http.StatusSeeOther))
 	http.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 
@@ -290,8 +1379,12 @@ func main() {
 			return
 		}
 
+		slug := strings.TrimSuffix(req.URL.Path[1:], ".html")
 		if req.URL.Path == "/" {
 			http.Redirect(w, req, "/posts.html", http.StatusSeeOther)
+		} else if page := app.views.pages.BySlug(slug); page != nil && page.Url.Path == req.URL.Path {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(page.RenderHTML())
 		} else {
 			assetServer.ServeHTTP(w, req)
 		}
@@ -302,8 +1395,8 @@ func main() {
 			os.Getenv("BLOG_HOST"),
 			http.RedirectHandler(fmt.Sprintf("https://%s/", os.Getenv("BLOG_TLS_HOST")), http.StatusMovedPermanently),
 		)
-		log.Fatal(http.ListenAndServeTLS(os.Getenv("BLOG_TLS_HOST"), app.tls.cert, app.tls.key, nil))
+		log.Fatal(http.ListenAndServeTLS(os.Getenv("BLOG_TLS_HOST"), app.tls.cert, app.tls.key, app.CSRFProtection(http.DefaultServeMux)))
 	} else {
-		log.Fatal(http.ListenAndServe(os.Getenv("BLOG_HOST"), nil))
+		log.Fatal(http.ListenAndServe(os.Getenv("BLOG_HOST"), app.CSRFProtection(http.DefaultServeMux)))
 	}
 }
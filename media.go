@@ -0,0 +1,290 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxMediaSize is the maximum size of an uploaded file in bytes.
+const maxMediaSize = 20 << 20
+
+// mediaExtensions lists the types of files that can be uploaded,
+// together with the extension used in their URLs.
+var mediaExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+	"audio/mpeg":      ".mp3",
+	"video/mp4":       ".mp4",
+}
+
+// MediaStore keeps uploaded files on disk, addressed by the SHA-256
+// hash of their content.
+type MediaStore struct {
+	dir string
+}
+
+func NewMediaStore(dir string) (*MediaStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("NewMediaStore: %s", err)
+	}
+
+	return &MediaStore{dir: dir}, nil
+}
+
+func mediaHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Path returns the name of the file storing the content with hash.
+func (store *MediaStore) Path(hash string) string {
+	return filepath.Join(store.dir, hash[:2], hash)
+}
+
+// Put stores content unless content with the same hash has been stored
+// before.
+func (store *MediaStore) Put(hash string, content []byte) error {
+	filename := store.Path(hash)
+	if _, err := os.Stat(filename); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return fmt.Errorf("MediaStore.Put: %s", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "upload-")
+	if err != nil {
+		return fmt.Errorf("MediaStore.Put: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("MediaStore.Put: %s", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("MediaStore.Put: %s", err)
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
+
+func (store *MediaStore) Open(hash string) (*os.File, error) {
+	return os.Open(store.Path(hash))
+}
+
+// AllMedia keeps track of the uploaded files.
+type AllMedia struct{}
+
+func (all *AllMedia) New() Aggregate {
+	return &Media{}
+}
+
+type Media struct {
+	id string
+}
+
+func (media *Media) HandleEvent(event Event) error {
+	switch evt := event.(type) {
+	case *MediaUploadedEvent:
+		media.id = evt.MediaId
+	}
+
+	return nil
+}
+
+func (media *Media) HandleCommand(command Command) (*Events, error) {
+	switch cmd := command.(type) {
+	case *UploadMediaCommand:
+		return media.upload(cmd)
+	}
+
+	return NoEvents, nil
+}
+
+func (media *Media) upload(cmd *UploadMediaCommand) (*Events, error) {
+	verr := ValidationError{}
+	if cmd.Name == "" {
+		verr.Add("Name", ErrEmpty)
+	}
+	if len(cmd.Content) == 0 {
+		verr.Add("Content", ErrEmpty)
+	} else if len(cmd.Content) > maxMediaSize {
+		verr.Add("Content", ErrTooLarge)
+	}
+	if _, ok := mediaExtensions[cmd.MimeType]; !ok {
+		verr.Add("MimeType", ErrUnsupported)
+	}
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
+	}
+
+	uploaded := &MediaUploadedEvent{
+		MediaId:    Id(),
+		Name:       cmd.Name,
+		Hash:       mediaHash(cmd.Content),
+		MimeType:   cmd.MimeType,
+		Size:       int64(len(cmd.Content)),
+		UploadedAt: time.Now(),
+	}
+
+	if resizable(cmd.MimeType) {
+		width, height, animated, err := decodeImageConfig(cmd.MimeType, cmd.Content)
+		if err == ErrTooLarge {
+			return NoEvents, verr.Add("Content", ErrTooLarge)
+		} else if err != nil {
+			return NoEvents, verr.Add("Content", ErrUnsupported)
+		}
+		uploaded.Width, uploaded.Height, uploaded.Animated = width, height, animated
+	}
+
+	return ListOfEvents(uploaded), nil
+}
+
+func mediaUrl(hash, mimeType string) *url.URL {
+	return &url.URL{Path: "/media/" + hash + mediaExtensions[mimeType]}
+}
+
+type MediaItem struct {
+	Id       string
+	Name     string
+	Hash     string
+	MimeType string
+	Size     string
+	Url      *url.URL
+	Uploaded string
+
+	Width    int
+	Height   int
+	Animated bool
+
+	uploadedAt time.Time
+}
+
+func (item *MediaItem) IsImage() bool {
+	return strings.HasPrefix(item.MimeType, "image/")
+}
+
+// Markdown returns the snippet for referencing item in a post.
+func (item *MediaItem) Markdown() string {
+	title := strings.TrimSuffix(item.Name, path.Ext(item.Name))
+	if item.IsImage() {
+		return fmt.Sprintf("![%s](%s)", title, item.Url)
+	}
+	return fmt.Sprintf("[%s](%s)", title, item.Url)
+}
+
+// formatSize returns size in a human readable form.
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f kB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", size)
+}
+
+// MediaLibraryView lists all uploaded files, most recent first.
+type MediaLibraryView struct {
+	Items []*MediaItem
+
+	byHash map[string]*MediaItem
+}
+
+func NewMediaLibraryView() *MediaLibraryView {
+	return &MediaLibraryView{
+		Items:  []*MediaItem{},
+		byHash: map[string]*MediaItem{},
+	}
+}
+
+func (view *MediaLibraryView) Len() int { return len(view.Items) }
+func (view *MediaLibraryView) Swap(i, j int) {
+	view.Items[i], view.Items[j] = view.Items[j], view.Items[i]
+}
+func (view *MediaLibraryView) Less(i, j int) bool {
+	return view.Items[j].uploadedAt.Before(view.Items[i].uploadedAt)
+}
+
+func (view *MediaLibraryView) HandleEvent(event Event) error {
+	switch evt := event.(type) {
+	case *MediaUploadedEvent:
+		item := &MediaItem{
+			Id:         evt.MediaId,
+			Name:       evt.Name,
+			Hash:       evt.Hash,
+			MimeType:   evt.MimeType,
+			Size:       formatSize(evt.Size),
+			Url:        mediaUrl(evt.Hash, evt.MimeType),
+			Uploaded:   evt.UploadedAt.Format("02 Jan 2006 15:04"),
+			Width:      evt.Width,
+			Height:     evt.Height,
+			Animated:   evt.Animated,
+			uploadedAt: evt.UploadedAt,
+		}
+		view.Items = append(view.Items, item)
+		if view.byHash[evt.Hash] == nil {
+			view.byHash[evt.Hash] = item
+		}
+		sort.Sort(view)
+	}
+
+	return nil
+}
+
+func (view *MediaLibraryView) ByHash(hash string) *MediaItem {
+	return view.byHash[hash]
+}
+
+// ByUrl returns the uploaded file with the given URL.
+func (view *MediaLibraryView) ByUrl(urlPath string) *MediaItem {
+	item, width := view.VariantByUrl(urlPath)
+	if width != 0 {
+		return nil
+	}
+	return item
+}
+
+// VariantByUrl returns the uploaded file with the given URL together
+// with the width it has been resized to, which is 0 for the original.
+func (view *MediaLibraryView) VariantByUrl(urlPath string) (*MediaItem, int) {
+	if !strings.HasPrefix(urlPath, "/media/") {
+		return nil, 0
+	}
+
+	name := strings.TrimSuffix(urlPath[len("/media/"):], path.Ext(urlPath))
+	fields := strings.SplitN(name, "-", 2)
+	item := view.ByHash(fields[0])
+	if item == nil {
+		return nil, 0
+	}
+	if len(fields) == 1 && item.Url.Path == urlPath {
+		return item, 0
+	}
+
+	for _, width := range item.Widths() {
+		if variantUrl(item.Hash, item.MimeType, width).Path == urlPath {
+			return item, width
+		}
+	}
+	return nil, 0
+}
+
+func (view *MediaLibraryView) RenderHTML() []byte {
+	return renderTemplate("views/admin_media.html", view)
+}
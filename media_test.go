@@ -0,0 +1,173 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color/palette"
+	"image/gif"
+	"image/png"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dhamidi/blog"
+)
+
+func TestMedia_Upload_RequiresSupportedType(t *testing.T) {
+	all := &main.AllMedia{}
+	media := all.New()
+
+	_, err := media.HandleCommand(&main.UploadMediaCommand{
+		Name:     "page.html",
+		MimeType: "text/html; charset=utf-8",
+		Content:  []byte("<script>alert(1)</script>"),
+	})
+
+	if err == nil {
+		t.Fatal("Expected an error.")
+	}
+
+	verr := err.(main.ValidationError)
+	if verr.Get("MimeType") != main.ErrUnsupported {
+		t.Fatalf("Expected MimeType to be %s, got %s", main.ErrUnsupported, verr.Get("MimeType"))
+	}
+}
+
+func TestMedia_Upload_AddressesContentByHash(t *testing.T) {
+	all := &main.AllMedia{}
+	media := all.New()
+
+	cmd := &main.UploadMediaCommand{
+		Name:     "../../paper.pdf",
+		MimeType: "application/pdf",
+		Content:  []byte("%PDF-1.4"),
+	}
+	cmd.Sanitize()
+	events, err := media.HandleCommand(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uploaded := events.Items()[0].(*main.MediaUploadedEvent)
+	if uploaded.Name != "paper.pdf" {
+		t.Fatalf("Expected name %q, got %q", "paper.pdf", uploaded.Name)
+	}
+	if expected := "e16fa5d9b51928755db85b917f0297babaf22c7a47e97d9212adab56e61ba04e"; uploaded.Hash != expected {
+		t.Fatalf("Expected hash %q, got %q", expected, uploaded.Hash)
+	}
+	if uploaded.Size != 8 {
+		t.Fatalf("Expected size 8, got %d", uploaded.Size)
+	}
+}
+
+func TestMediaItem_Srcset_ListsSmallerWidths(t *testing.T) {
+	item := &main.MediaItem{
+		Hash:     "abc",
+		MimeType: "image/jpeg",
+		Url:      &url.URL{Path: "/media/abc.jpg"},
+		Width:    800,
+		Height:   600,
+	}
+
+	expected := "/media/abc-320w.jpg 320w, /media/abc-640w.jpg 640w, /media/abc.jpg 800w"
+	if srcset := item.Srcset(); srcset != expected {
+		t.Fatalf("Expected %q, got %q", expected, srcset)
+	}
+
+	item.Animated = true
+	if srcset := item.Srcset(); srcset != "" {
+		t.Fatalf("Expected no srcset for animated images, got %q", srcset)
+	}
+}
+
+func TestMedia_Upload_RefusesImagesWithTooManyPixels(t *testing.T) {
+	small := &bytes.Buffer{}
+	if err := png.Encode(small, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	// Claim a size of 8000x8000 in the IHDR chunk without any more
+	// image data.
+	huge := small.Bytes()
+	binary.BigEndian.PutUint32(huge[16:], 8000)
+	binary.BigEndian.PutUint32(huge[20:], 8000)
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+
+	// A GIF of 41 frames of 1000x1000 pixels each, with the same
+	// tiny image data in every frame.
+	animated := []byte("GIF89a\xe8\x03\xe8\x03\x80\x00\x00\x00\x00\x00\xff\xff\xff")
+	for i := 0; i < 41; i++ {
+		animated = append(animated, 0x2c, 0, 0, 0, 0, 0xe8, 0x03, 0xe8, 0x03, 0, 2, 2, 0x44, 0x01, 0)
+	}
+	animated = append(animated, 0x3b)
+
+	for mimeType, content := range map[string][]byte{"image/png": huge, "image/gif": animated} {
+		all := &main.AllMedia{}
+		_, err := all.New().HandleCommand(&main.UploadMediaCommand{
+			Name:     "bomb",
+			MimeType: mimeType,
+			Content:  content,
+		})
+
+		if err == nil {
+			t.Fatalf("Expected an error for %s.", mimeType)
+		}
+		verr := err.(main.ValidationError)
+		if verr.Get("Content") != main.ErrTooLarge {
+			t.Fatalf("Expected %s content to be %s, got %s", mimeType, main.ErrTooLarge, verr.Get("Content"))
+		}
+	}
+}
+
+func TestMedia_Upload_RecognizesAnimatedGIFs(t *testing.T) {
+	frames := &gif.GIF{}
+	for i := 0; i < 2; i++ {
+		frames.Image = append(frames.Image, image.NewPaletted(image.Rect(0, 0, 20, 10), palette.Plan9))
+		frames.Delay = append(frames.Delay, 10)
+	}
+	content := &bytes.Buffer{}
+	if err := gif.EncodeAll(content, frames); err != nil {
+		t.Fatal(err)
+	}
+
+	all := &main.AllMedia{}
+	events, err := all.New().HandleCommand(&main.UploadMediaCommand{
+		Name:     "animation.gif",
+		MimeType: "image/gif",
+		Content:  content.Bytes(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uploaded := events.Items()[0].(*main.MediaUploadedEvent)
+	if !uploaded.Animated || uploaded.Width != 20 || uploaded.Height != 10 {
+		t.Fatalf("Expected a 20x10 animation, got %#v", uploaded)
+	}
+}
+
+func TestApplication_UploadMedia_ResizesImages(t *testing.T) {
+	app, dir := newApplication(t)
+	defer os.RemoveAll(dir)
+
+	content := &bytes.Buffer{}
+	if err := png.Encode(content, image.NewRGBA(image.Rect(0, 0, 700, 10))); err != nil {
+		t.Fatal(err)
+	}
+
+	admin := &main.Principal{Login: "admin", Roles: []string{main.RoleAdmin}}
+	events, err := app.HandleCommandAs(admin, &main.UploadMediaCommand{Name: "wide.png", MimeType: "image/png", Content: content.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := events.Items()[0].(*main.MediaUploadedEvent).Hash
+	for _, width := range []string{"320", "640"} {
+		variant := filepath.Join(dir, "media", hash[:2], hash+"-"+width+"w.png")
+		if _, err := os.Stat(variant); err != nil {
+			t.Fatalf("Expected variant %s to be created when uploading: %s", width, err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"html/template"
+	"sort"
+	"time"
+)
+
+const (
+	CommentUnauthenticated = "unauthenticated"
+	CommentPending         = "pending"
+	CommentPublished       = "published"
+	CommentHidden          = "hidden"
+)
+
+type ModerationComment struct {
+	Id          string
+	Post        *AllPostsPost
+	Author      string
+	Email       string
+	ContentHTML template.HTML
+	Created     string
+	Status      string
+
+	createdAt time.Time
+}
+
+type moderationComments []*ModerationComment
+
+func (c moderationComments) Len() int           { return len(c) }
+func (c moderationComments) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c moderationComments) Less(i, j int) bool { return c[j].createdAt.Before(c[i].createdAt) }
+
+// CommentModerationView lists the comments of all posts together
+// with their moderation status for the admin.
+type CommentModerationView struct {
+	allPosts *AllPostsView
+	comments map[string]*ModerationComment
+}
+
+func NewCommentModerationView(allPosts *AllPostsView) *CommentModerationView {
+	return &CommentModerationView{
+		allPosts: allPosts,
+		comments: map[string]*ModerationComment{},
+	}
+}
+
+func (view *CommentModerationView) HandleEvent(event Event) error {
+	switch evt := event.(type) {
+	case *PostCommentedEvent:
+		view.comments[evt.CommentId] = &ModerationComment{
+			Id:          evt.CommentId,
+			Post:        view.allPosts.ById(evt.PostId),
+			Author:      evt.AuthorName,
+			Email:       evt.AuthorEmail,
+			ContentHTML: textToHTML(evt.Content, true),
+			Created:     evt.CommentedAt.Format("02 Jan 2006 15:04"),
+			Status:      CommentUnauthenticated,
+
+			createdAt: evt.CommentedAt,
+		}
+	case *PostCommentAuthenticatedEvent:
+		if evt.PendingApproval {
+			view.setStatus(evt.CommentId, CommentPending)
+		} else {
+			view.setStatus(evt.CommentId, CommentPublished)
+		}
+	case *CommentEditedEvent:
+		if comment := view.comments[evt.CommentId]; comment != nil {
+			comment.ContentHTML = textToHTML(evt.Content, true)
+		}
+		if evt.PendingApproval {
+			view.setStatus(evt.CommentId, CommentPending)
+		}
+	case *CommentWithdrawnEvent:
+		delete(view.comments, evt.CommentId)
+	case *CommentExpiredEvent:
+		delete(view.comments, evt.CommentId)
+	case *PostCommentApprovedEvent:
+		view.setStatus(evt.CommentId, CommentPublished)
+	case *PostCommentHiddenEvent:
+		view.setStatus(evt.CommentId, CommentHidden)
+	case *PostCommentRejectedEvent:
+		delete(view.comments, evt.CommentId)
+	case *PostCommentDeletedEvent:
+		delete(view.comments, evt.CommentId)
+	}
+
+	return nil
+}
+
+func (view *CommentModerationView) setStatus(commentId, status string) {
+	if comment := view.comments[commentId]; comment != nil {
+		comment.Status = status
+	}
+}
+
+func (view *CommentModerationView) withStatus(status string) []*ModerationComment {
+	result := moderationComments{}
+	for _, comment := range view.comments {
+		if comment.Status == status {
+			result = append(result, comment)
+		}
+	}
+
+	sort.Sort(result)
+	return result
+}
+
+func (view *CommentModerationView) Unauthenticated() []*ModerationComment {
+	return view.withStatus(CommentUnauthenticated)
+}
+
+func (view *CommentModerationView) Pending() []*ModerationComment {
+	return view.withStatus(CommentPending)
+}
+
+func (view *CommentModerationView) Published() []*ModerationComment {
+	return view.withStatus(CommentPublished)
+}
+
+func (view *CommentModerationView) Hidden() []*ModerationComment {
+	return view.withStatus(CommentHidden)
+}
+
+func (view *CommentModerationView) ById(id string) *ModerationComment {
+	return view.comments[id]
+}
+
+func (view *CommentModerationView) RenderHTML() []byte {
+	return renderTemplate("views/admin_comments.html", view)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// defaultNavigation are the links shown on every page unless
+// BLOG_NAVIGATION is set.
+const defaultNavigation = "Home=/,Tags=/tags.html,Archive=/archive/"
+
+// NavigationLink is an entry of the navigation shown on every page.
+// Links with an Icon are shown as that icon of the icon font instead of
+// their title.
+type NavigationLink struct {
+	Title string
+	Url   *url.URL
+	Icon  string
+}
+
+// parseNavigation parses a comma separated list of Title=URL entries.
+func parseNavigation(value string) ([]*NavigationLink, error) {
+	links := []*NavigationLink{}
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		fields := strings.SplitN(entry, "=", 2)
+		if len(fields) != 2 || strings.TrimSpace(fields[0]) == "" {
+			return nil, fmt.Errorf("parseNavigation: invalid entry %q", entry)
+		}
+
+		loc, err := url.Parse(strings.TrimSpace(fields[1]))
+		if err != nil {
+			return nil, fmt.Errorf("parseNavigation: %s", err)
+		}
+
+		links = append(links, &NavigationLink{
+			Title: strings.TrimSpace(fields[0]),
+			Url:   loc,
+		})
+	}
+
+	return links, nil
+}
+
+// navigationFromEnv parses the environment variable name as a list of
+// links, using def if it is not set.
+func navigationFromEnv(name string, def string) []*NavigationLink {
+	value, found := os.LookupEnv(name)
+	if !found {
+		value = def
+	}
+
+	links, err := parseNavigation(value)
+	if err != nil {
+		log.Fatalf("%s: not a list of Title=URL entries: %q", name, value)
+	}
+
+	return links
+}
+
+// socialLinksFromEnv parses the environment variable name as a list of
+// name=URL entries, where name is one of the social networks of the
+// icon font.
+func socialLinksFromEnv(name string) []*NavigationLink {
+	links := navigationFromEnv(name, "")
+	for _, link := range links {
+		link.Icon = "icon-" + strings.ToLower(link.Title) + "-squared"
+	}
+
+	return links
+}
+
+// Navigation returns the links shown on every page: the configured
+// links followed by the pages that have been added to the navigation.
+func (app *Application) Navigation() []*NavigationLink {
+	links := append([]*NavigationLink{}, app.navigation.links...)
+	for _, page := range app.views.pages.Navigation() {
+		links = append(links, &NavigationLink{Title: page.Title, Url: page.Url})
+	}
+
+	return links
+}
+
+// SocialLinks returns the links to the author's profiles on social
+// networks, which are shown as icons.
+func (app *Application) SocialLinks() []*NavigationLink {
+	return app.navigation.social
+}
@@ -0,0 +1,36 @@
+package main_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/dhamidi/blog"
+)
+
+func TestApplication_Navigation_ListsConfiguredLinksAndPages(t *testing.T) {
+	os.Setenv("BLOG_NAVIGATION", "Home=/, Projects=https://example.com/projects")
+	defer os.Unsetenv("BLOG_NAVIGATION")
+	app, dir := newApplication(t)
+	defer os.RemoveAll(dir)
+
+	admin := &main.Principal{Login: "admin", Roles: []string{main.RoleAdmin}}
+	for _, cmd := range []*main.CreatePageCommand{
+		{Title: "About", Content: "content", Navigation: true},
+		{Title: "Imprint", Content: "content"},
+	} {
+		if _, err := app.HandleCommandAs(admin, cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{"Home /", "Projects https://example.com/projects", "About /about.html"}
+	links := app.Navigation()
+	if len(links) != len(expected) {
+		t.Fatalf("Expected %d links, got %d", len(expected), len(links))
+	}
+	for i, link := range links {
+		if actual := link.Title + " " + link.Url.String(); actual != expected[i] {
+			t.Fatalf("Expected link %d to be %q, got %q", i, expected[i], actual)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+const (
+	// wordsPerMinute is the reading speed assumed for estimating
+	// the time it takes to read a post.
+	wordsPerMinute = 200
+
+	// tableOfContentsMinHeadings is the number of headings a post
+	// needs to have for showing a table of contents.
+	tableOfContentsMinHeadings = 3
+)
+
+// OutlineHeading is a heading in the content of a post.  Level starts
+// at 1 for the most important headings used in the post.
+type OutlineHeading struct {
+	Level int
+	Id    string
+	Title string
+}
+
+// headingTag matches the headings rendered by blackfriday, which
+// assigns each heading an id.
+var headingTag = regexp.MustCompile(`(?s)<h([1-6]) id="([^"]+)">(.*?)</h[1-6]>`)
+
+// extractOutline returns the headings in content in the order they
+// appear.
+func extractOutline(content string) []*OutlineHeading {
+	outline := []*OutlineHeading{}
+	top := 6
+	for _, match := range headingTag.FindAllStringSubmatch(content, -1) {
+		level := int(match[1][0] - '0')
+		if level < top {
+			top = level
+		}
+		outline = append(outline, &OutlineHeading{
+			Level: level,
+			Id:    html.UnescapeString(match[2]),
+			Title: strings.Join(strings.Fields(html.UnescapeString(htmlTag.ReplaceAllString(match[3], ""))), " "),
+		})
+	}
+
+	for _, heading := range outline {
+		heading.Level -= top - 1
+	}
+
+	return outline
+}
+
+// countWords returns the number of words in the text of content.
+func countWords(content string) int {
+	return len(strings.Fields(html.UnescapeString(htmlTag.ReplaceAllString(content, " "))))
+}
+
+// analyzeContent extracts the outline of the post and estimates how
+// long it takes to read it.
+func (post *AllPostsPost) analyzeContent() {
+	post.Outline = extractOutline(string(post.ContentHTML))
+	post.WordCount = countWords(string(post.ContentHTML))
+	post.ReadingTime = (post.WordCount + wordsPerMinute - 1) / wordsPerMinute
+	if post.ReadingTime < 1 {
+		post.ReadingTime = 1
+	}
+}
+
+// HasTableOfContents returns true if the post is long enough for a
+// table of contents.
+func (post *AllPostsPost) HasTableOfContents() bool {
+	return len(post.Outline) >= tableOfContentsMinHeadings
+}
@@ -0,0 +1,49 @@
+package main_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhamidi/blog"
+)
+
+func TestAllPostsPost_OutlineAndReadingTime(t *testing.T) {
+	content := strings.Join([]string{
+		"Intro.",
+		"## Getting *started*",
+		strings.Repeat("word ", 250),
+		"### Installing",
+		"Text.",
+		"## Going further {#further}",
+		"Text.",
+	}, "\n\n")
+	view := &main.AllPostsView{}
+	postId := main.Id()
+	view.HandleEvent(&main.PostPublishedEvent{PostId: postId, Title: "Guide", Content: content, PublishedAt: time.Now()})
+
+	post := view.ById(postId)
+	if !post.HasTableOfContents() {
+		t.Fatalf("Expected a table of contents, got %#v", post.Outline)
+	}
+
+	expected := []main.OutlineHeading{
+		{Level: 1, Id: "getting-started", Title: "Getting started"},
+		{Level: 2, Id: "installing", Title: "Installing"},
+		{Level: 1, Id: "further", Title: "Going further"},
+	}
+	for i, heading := range expected {
+		if *post.Outline[i] != heading {
+			t.Errorf("Expected heading %d to be %#v, got %#v", i, heading, post.Outline[i])
+		}
+	}
+
+	if post.ReadingTime != 2 {
+		t.Fatalf("Expected %d words to take 2 minutes, got %d", post.WordCount, post.ReadingTime)
+	}
+
+	view.HandleEvent(&main.PostRewordedEvent{PostId: postId, RewordedContent: "Short."})
+	if post.HasTableOfContents() || post.WordCount != 1 || post.ReadingTime != 1 {
+		t.Fatalf("Expected reworded post to be short, got %#v", post)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"html/template"
+	"net/url"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// reservedSlugs are the slugs that cannot be used for pages, since
+// their URLs are already taken.
+var reservedSlugs = map[string]bool{
+	"index":   true,
+	"posts":   true,
+	"tags":    true,
+	"sitemap": true,
+}
+
+// validSlug matches the slugs that can be used in a page's URL without
+// escaping and without being mistaken for a URL of another kind.
+var validSlug = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+func pageUrl(slug string) *url.URL {
+	return &url.URL{Path: "/" + slug + ".html"}
+}
+
+// AllPages keeps track of the slugs that are used by pages.
+type AllPages struct {
+	slugs map[string]string
+}
+
+func (all *AllPages) New() Aggregate {
+	return &Page{
+		all: all,
+	}
+}
+
+func (all *AllPages) HandleEvent(event Event) error {
+	if all.slugs == nil {
+		all.slugs = map[string]string{}
+	}
+
+	switch evt := event.(type) {
+	case *PageCreatedEvent:
+		all.slugs[evt.Slug] = evt.PageId
+	case *PageDeletedEvent:
+		for slug, pageId := range all.slugs {
+			if pageId == evt.PageId {
+				delete(all.slugs, slug)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (all *AllPages) UniqueSlug(slug string) bool {
+	_, taken := all.slugs[slug]
+	return !taken && !reservedSlugs[slug]
+}
+
+// Page is a page that is not part of the chronological list of posts,
+// like an "About" page.
+type Page struct {
+	all     *AllPages
+	id      string
+	deleted bool
+}
+
+func (page *Page) HandleEvent(event Event) error {
+	switch evt := event.(type) {
+	case *PageCreatedEvent:
+		page.id = evt.PageId
+	case *PageDeletedEvent:
+		page.deleted = true
+	}
+
+	return nil
+}
+
+func (page *Page) HandleCommand(command Command) (*Events, error) {
+	switch cmd := command.(type) {
+	case *CreatePageCommand:
+		return page.create(cmd)
+	case *EditPageCommand:
+		return page.edit(cmd)
+	case *DeletePageCommand:
+		return page.delete(cmd)
+	}
+
+	return NoEvents, nil
+}
+
+func (page *Page) create(cmd *CreatePageCommand) (*Events, error) {
+	verr := ValidationError{}
+	if cmd.Title == "" {
+		verr.Add("Title", ErrEmpty)
+	}
+	if cmd.Content == "" {
+		verr.Add("Content", ErrEmpty)
+	}
+	if cmd.Slug == "" {
+		verr.Add("Slug", ErrEmpty)
+	} else if !validSlug.MatchString(cmd.Slug) {
+		verr.Add("Slug", ErrInvalid)
+	} else if !page.all.UniqueSlug(cmd.Slug) {
+		verr.Add("Slug", ErrNotUnique)
+	}
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
+	}
+
+	return ListOfEvents(&PageCreatedEvent{
+		PageId:     Id(),
+		Title:      cmd.Title,
+		Slug:       cmd.Slug,
+		Content:    cmd.Content,
+		Navigation: cmd.Navigation,
+		Position:   cmd.Position,
+		CreatedAt:  time.Now(),
+	}), nil
+}
+
+func (page *Page) edit(cmd *EditPageCommand) (*Events, error) {
+	verr := ValidationError{}
+	if cmd.PageId != page.id || page.deleted {
+		verr.Add("Page", ErrNotFound)
+	}
+	if cmd.Title == "" {
+		verr.Add("Title", ErrEmpty)
+	}
+	if cmd.Content == "" {
+		verr.Add("Content", ErrEmpty)
+	}
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
+	}
+
+	return ListOfEvents(&PageEditedEvent{
+		PageId:     page.id,
+		Title:      cmd.Title,
+		Content:    cmd.Content,
+		Navigation: cmd.Navigation,
+		Position:   cmd.Position,
+		EditedAt:   time.Now(),
+	}), nil
+}
+
+func (page *Page) delete(cmd *DeletePageCommand) (*Events, error) {
+	if cmd.PageId != page.id || page.deleted {
+		return NoEvents, ValidationError{}.Add("Page", ErrNotFound)
+	}
+
+	return ListOfEvents(&PageDeletedEvent{
+		PageId:    page.id,
+		DeletedAt: time.Now(),
+	}), nil
+}
+
+type PagesPage struct {
+	Id          string
+	Title       string
+	Slug        string
+	Url         *url.URL
+	Content     string
+	ContentHTML template.HTML
+	Navigation  bool
+	Position    int
+	Updated     string
+}
+
+func (page *PagesPage) update(title, content string, navigation bool, position int, at time.Time) {
+	page.Title = title
+	page.Content = content
+	page.ContentHTML = textToHTML(content, false)
+	page.Navigation = navigation
+	page.Position = position
+	page.Updated = at.Format("02 Jan 2006")
+}
+
+func (page *PagesPage) RenderHTML() []byte {
+	return renderTemplate("views/page.html", page)
+}
+
+// PagesView maintains the static pages and the navigation entries
+// pointing to them.
+type PagesView struct {
+	Collection []*PagesPage
+
+	byId   map[string]*PagesPage
+	bySlug map[string]*PagesPage
+}
+
+func NewPagesView() *PagesView {
+	return &PagesView{
+		Collection: []*PagesPage{},
+		byId:       map[string]*PagesPage{},
+		bySlug:     map[string]*PagesPage{},
+	}
+}
+
+func (view *PagesView) Len() int { return len(view.Collection) }
+func (view *PagesView) Swap(i, j int) {
+	view.Collection[i], view.Collection[j] = view.Collection[j], view.Collection[i]
+}
+func (view *PagesView) Less(i, j int) bool {
+	a, b := view.Collection[i], view.Collection[j]
+	if a.Position != b.Position {
+		return a.Position < b.Position
+	}
+	return a.Title < b.Title
+}
+
+func (view *PagesView) HandleEvent(event Event) error {
+	switch evt := event.(type) {
+	case *PageCreatedEvent:
+		page := &PagesPage{
+			Id:   evt.PageId,
+			Slug: evt.Slug,
+			Url:  pageUrl(evt.Slug),
+		}
+		page.update(evt.Title, evt.Content, evt.Navigation, evt.Position, evt.CreatedAt)
+		view.byId[page.Id] = page
+		view.bySlug[page.Slug] = page
+		view.Collection = append(view.Collection, page)
+		sort.Sort(view)
+	case *PageEditedEvent:
+		if page := view.byId[evt.PageId]; page != nil {
+			page.update(evt.Title, evt.Content, evt.Navigation, evt.Position, evt.EditedAt)
+			sort.Sort(view)
+		}
+	case *PageDeletedEvent:
+		if page := view.byId[evt.PageId]; page != nil {
+			delete(view.byId, page.Id)
+			delete(view.bySlug, page.Slug)
+			for i, existing := range view.Collection {
+				if existing == page {
+					view.Collection = append(view.Collection[:i], view.Collection[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (view *PagesView) ById(id string) *PagesPage {
+	return view.byId[id]
+}
+
+func (view *PagesView) BySlug(slug string) *PagesPage {
+	return view.bySlug[slug]
+}
+
+// Navigation returns the pages that should be linked to from every
+// page of the blog.
+func (view *PagesView) Navigation() []*PagesPage {
+	pages := []*PagesPage{}
+	for _, page := range view.Collection {
+		if page.Navigation {
+			pages = append(pages, page)
+		}
+	}
+
+	return pages
+}
+
+func (view *PagesView) RenderHTML() []byte {
+	return renderTemplate("views/admin_pages.html", view)
+}
@@ -0,0 +1,62 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/dhamidi/blog"
+)
+
+func TestPage_Create_RequiresUniqueSlug(t *testing.T) {
+	all := &main.AllPages{}
+	all.HandleEvent(&main.PageCreatedEvent{PageId: main.Id(), Slug: "about"})
+
+	for _, slug := range []string{"About", "posts"} {
+		cmd := &main.CreatePageCommand{Title: "Title", Slug: slug, Content: "content"}
+		cmd.Sanitize()
+		_, err := all.New().HandleCommand(cmd)
+
+		if err == nil {
+			t.Fatalf("Expected an error for slug %q.", slug)
+		}
+
+		verr := err.(main.ValidationError)
+		if verr.Get("Slug") != main.ErrNotUnique {
+			t.Fatalf("Expected Slug to be %s, got %s", main.ErrNotUnique, verr.Get("Slug"))
+		}
+	}
+}
+
+func TestPage_Create_RequiresValidSlug(t *testing.T) {
+	all := &main.AllPages{}
+
+	for _, slug := range []string{"über-uns", "admin/x", "page/2", "a?b", "a#b", "-about"} {
+		_, err := all.New().HandleCommand(&main.CreatePageCommand{Title: "Title", Slug: slug, Content: "content"})
+		if verr, invalid := err.(main.ValidationError); !invalid || verr.Get("Slug") != main.ErrInvalid {
+			t.Fatalf("Expected slug %q to be %s, got %v", slug, main.ErrInvalid, err)
+		}
+	}
+
+	cmd := &main.CreatePageCommand{Title: "About me", Content: "content"}
+	cmd.Sanitize()
+	if _, err := all.New().HandleCommand(cmd); err != nil {
+		t.Fatalf("Expected slug %q derived from the title to be valid, got %v", cmd.Slug, err)
+	}
+}
+
+func TestPage_Edit_RequiresExistingPage(t *testing.T) {
+	created := &main.PageCreatedEvent{PageId: main.Id(), Slug: "about"}
+	all := &main.AllPages{}
+	page := all.New()
+	page.HandleEvent(created)
+	page.HandleEvent(&main.PageDeletedEvent{PageId: created.PageId})
+
+	_, err := page.HandleCommand(&main.EditPageCommand{
+		PageId:  created.PageId,
+		Title:   "About",
+		Content: "content",
+	})
+
+	if err == nil {
+		t.Fatal("Expected an error.")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// defaultPageSize is the number of posts listed per page unless
+// BLOG_PAGE_SIZE is set.
+const defaultPageSize = 10
+
+// Pagination is one page of a list of posts.
+type Pagination struct {
+	Posts  []*AllPostsPost
+	Number int
+	Pages  int
+
+	// Prev and Next point to the pages with newer and older posts
+	// respectively and are nil on the first and last page.
+	Prev *url.URL
+	Next *url.URL
+}
+
+// Paginate returns page number of posts, with size posts per page.
+// pageUrl returns the URL of a page given its number.  The first page
+// always exists, even if there are no posts.
+func Paginate(posts []*AllPostsPost, number, size int, pageUrl func(int) *url.URL) (*Pagination, error) {
+	if size < 1 {
+		size = defaultPageSize
+	}
+
+	pages := (len(posts) + size - 1) / size
+	if pages == 0 {
+		pages = 1
+	}
+	if number < 1 || number > pages {
+		return nil, ErrNotFound
+	}
+
+	start := (number - 1) * size
+	end := start + size
+	if end > len(posts) {
+		end = len(posts)
+	}
+
+	page := &Pagination{
+		Posts:  posts[start:end],
+		Number: number,
+		Pages:  pages,
+	}
+	if number > 1 {
+		page.Prev = pageUrl(number - 1)
+	}
+	if number < pages {
+		page.Next = pageUrl(number + 1)
+	}
+
+	return page, nil
+}
+
+// indexPageUrl returns the URL of a page of the post index.
+func indexPageUrl(number int) *url.URL {
+	if number == 1 {
+		return &url.URL{Path: "/posts.html"}
+	}
+
+	return &url.URL{Path: fmt.Sprintf("/page/%d/", number)}
+}
+
+// queryPageUrl returns a function for the URLs of the pages of the
+// listing at base, which refer to pages other than the first one with
+// the page parameter.
+func queryPageUrl(base *url.URL) func(int) *url.URL {
+	return func(number int) *url.URL {
+		loc := *base
+		if number > 1 {
+			loc.RawQuery = url.Values{"page": {strconv.Itoa(number)}}.Encode()
+		}
+		return &loc
+	}
+}
+
+// pageNumber returns the page requested with the page parameter of
+// req, which defaults to the first page.  Invalid page numbers are
+// returned as 0, which does not exist.
+func pageNumber(req *http.Request) int {
+	value := req.FormValue("page")
+	if value == "" {
+		return 1
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0
+	}
+
+	return number
+}
@@ -0,0 +1,44 @@
+package main_test
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/dhamidi/blog"
+)
+
+func TestPaginate_LinksNeighbouringPages(t *testing.T) {
+	posts := []*main.AllPostsPost{}
+	for i := 0; i < 5; i++ {
+		posts = append(posts, &main.AllPostsPost{Title: strconv.Itoa(i)})
+	}
+	pageUrl := func(number int) *url.URL {
+		return &url.URL{Path: "/page/" + strconv.Itoa(number) + "/"}
+	}
+
+	first, err := main.Paginate(posts, 1, 2, pageUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Pages != 3 || len(first.Posts) != 2 || first.Prev != nil || first.Next.Path != "/page/2/" {
+		t.Fatalf("Unexpected first page: %#v", first)
+	}
+
+	last, err := main.Paginate(posts, 3, 2, pageUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(last.Posts) != 1 || last.Posts[0].Title != "4" || last.Next != nil || last.Prev.Path != "/page/2/" {
+		t.Fatalf("Unexpected last page: %#v", last)
+	}
+
+	for _, number := range []int{0, 4} {
+		if _, err := main.Paginate(posts, number, 2, pageUrl); err != main.ErrNotFound {
+			t.Fatalf("Expected page %d to be %s, got %v", number, main.ErrNotFound, err)
+		}
+	}
+	if empty, err := main.Paginate(nil, 1, 2, pageUrl); err != nil || empty.Pages != 1 {
+		t.Fatalf("Expected an empty first page, got %#v, %v", empty, err)
+	}
+}
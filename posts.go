@@ -1,17 +1,54 @@
 package main
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 type Posts struct {
 	titles map[string]bool
 
 	commentIds map[string]string
+
+	// commentPosts maps the id of every comment to the id of its
+	// post.
+	commentPosts map[string]string
+
+	// authors maps the id of every post to the id of its author.
+	authors map[string]string
+
+	config PostsConfig
+}
+
+// PostsConfig holds the settings that govern how comments are handled.
+type PostsConfig struct {
+	// RequireApproval makes authenticated comments wait for the
+	// admin's approval before they are published.
+	RequireApproval bool
+
+	// EditWindow is the time after commenting during which the
+	// author of a comment may still edit it.
+	EditWindow time.Duration
+
+	// AuthenticationExpiry is the time after requesting an
+	// authentication mail during which a comment can be
+	// authenticated.
+	AuthenticationExpiry time.Duration
+
+	// MaxResends limits how often the authentication mail for a
+	// comment can be sent again.
+	MaxResends int
+}
+
+func NewPosts(config PostsConfig) *Posts {
+	return &Posts{config: config}
 }
 
 func (posts *Posts) New() Aggregate {
 	return &Post{
 		posts:    posts,
 		comments: map[string]*PostComment{},
+		tags:     map[string]bool{},
 	}
 }
 
@@ -22,23 +59,55 @@ func (posts *Posts) HandleEvent(event Event) error {
 	if posts.commentIds == nil {
 		posts.commentIds = map[string]string{}
 	}
+	if posts.commentPosts == nil {
+		posts.commentPosts = map[string]string{}
+	}
+	if posts.authors == nil {
+		posts.authors = map[string]string{}
+	}
 
 	switch evt := event.(type) {
 	case *PostPublishedEvent:
 		posts.titles[evt.Title] = true
+		posts.authors[evt.PostId] = evt.AuthorId
 	case *PostCommentedEvent:
 		posts.commentIds[evt.CommentId] = evt.PostId
+		posts.commentPosts[evt.CommentId] = evt.PostId
 	case *PostCommentAuthenticatedEvent:
 		delete(posts.commentIds, evt.CommentId)
+	case *PostCommentRejectedEvent:
+		delete(posts.commentIds, evt.CommentId)
+	case *PostCommentDeletedEvent:
+		delete(posts.commentIds, evt.CommentId)
+	case *CommentWithdrawnEvent:
+		delete(posts.commentIds, evt.CommentId)
+	case *CommentExpiredEvent:
+		delete(posts.commentIds, evt.CommentId)
 	}
 
 	return nil
 }
 
+// IdForComment returns the id of the post for a comment that still
+// needs to be authenticated.
 func (posts *Posts) IdForComment(commentId string) string {
 	return posts.commentIds[commentId]
 }
 
+// PostForComment returns the id of the post commentId belongs to.
+func (posts *Posts) PostForComment(commentId string) string {
+	return posts.commentPosts[commentId]
+}
+
+// AuthorOf returns the id of the author of the post with postId.
+func (posts *Posts) AuthorOf(postId string) string {
+	return posts.authors[postId]
+}
+
+func (posts *Posts) Config() PostsConfig {
+	return posts.config
+}
+
 func (posts *Posts) UniqueTitle(title string) bool {
 	return posts.titles[title] != true
 }
@@ -48,11 +117,52 @@ type Post struct {
 	id       string
 	content  string
 	comments map[string]*PostComment
+	tags     map[string]bool
+
+	// revisions holds every version of the post's content, starting
+	// with the one it was published with.
+	revisions []string
 }
 
 type PostComment struct {
-	id            string
-	authenticated bool
+	id              string
+	parentId        string
+	content         string
+	authorName      string
+	authorEmail     string
+	commentedAt     time.Time
+	requestedAt     time.Time
+	resends         int
+	authenticated   bool
+	pendingApproval bool
+	hidden          bool
+	rejected        bool
+	deleted         bool
+	withdrawn       bool
+	expired         bool
+}
+
+// gone returns true if the comment has been removed for any reason.
+func (comment *PostComment) gone() bool {
+	return comment.deleted || comment.rejected || comment.withdrawn || comment.expired
+}
+
+// orphaned returns true if comment is a reply to a comment that has
+// been removed by a moderator or has expired.  Withdrawn comments remain
+// in place for their replies.
+func (post *Post) orphaned(comment *PostComment) bool {
+	if comment.parentId == "" {
+		return false
+	}
+
+	parent := post.comments[comment.parentId]
+	return parent == nil || parent.deleted || parent.rejected || parent.expired
+}
+
+// published returns true if the comment is visible to readers.
+func (comment *PostComment) published() bool {
+	return comment.authenticated && !comment.pendingApproval &&
+		!comment.hidden && !comment.gone()
 }
 
 func (post *Post) HandleEvent(event Event) error {
@@ -60,11 +170,26 @@ func (post *Post) HandleEvent(event Event) error {
 	case *PostPublishedEvent:
 		post.id = evt.PostId
 		post.content = evt.Content
+		post.revisions = append(post.revisions, evt.Content)
+		for _, tag := range evt.Tags {
+			post.tags[tag] = true
+		}
 	case *PostRewordedEvent:
 		post.content = evt.RewordedContent
+		post.revisions = append(post.revisions, evt.RewordedContent)
+	case *PostTaggedEvent:
+		post.tags[evt.TagName] = true
+	case *PostUntaggedEvent:
+		delete(post.tags, evt.TagName)
 	case *PostCommentedEvent:
 		comment := &PostComment{
 			id:            evt.CommentId,
+			parentId:      evt.ParentId,
+			content:       evt.Content,
+			authorName:    evt.AuthorName,
+			authorEmail:   evt.AuthorEmail,
+			commentedAt:   evt.CommentedAt,
+			requestedAt:   evt.CommentedAt,
 			authenticated: false,
 		}
 		if evt.CommentId == "" {
@@ -74,7 +199,47 @@ func (post *Post) HandleEvent(event Event) error {
 		}
 		post.comments[evt.CommentId] = comment
 	case *PostCommentAuthenticatedEvent:
-		post.comments[evt.CommentId].authenticated = true
+		if comment := post.comments[evt.CommentId]; comment != nil {
+			comment.authenticated = true
+			comment.pendingApproval = evt.PendingApproval
+		}
+	case *CommentAuthenticationRequestedEvent:
+		if comment := post.comments[evt.CommentId]; comment != nil {
+			comment.requestedAt = evt.RequestedAt
+			comment.resends++
+		}
+	case *CommentExpiredEvent:
+		if comment := post.comments[evt.CommentId]; comment != nil {
+			comment.expired = true
+		}
+	case *CommentEditedEvent:
+		if comment := post.comments[evt.CommentId]; comment != nil {
+			comment.content = evt.Content
+			if evt.PendingApproval {
+				comment.pendingApproval = true
+			}
+		}
+	case *CommentWithdrawnEvent:
+		if comment := post.comments[evt.CommentId]; comment != nil {
+			comment.withdrawn = true
+		}
+	case *PostCommentApprovedEvent:
+		if comment := post.comments[evt.CommentId]; comment != nil {
+			comment.pendingApproval = false
+			comment.hidden = false
+		}
+	case *PostCommentRejectedEvent:
+		if comment := post.comments[evt.CommentId]; comment != nil {
+			comment.rejected = true
+		}
+	case *PostCommentHiddenEvent:
+		if comment := post.comments[evt.CommentId]; comment != nil {
+			comment.hidden = true
+		}
+	case *PostCommentDeletedEvent:
+		if comment := post.comments[evt.CommentId]; comment != nil {
+			comment.deleted = true
+		}
 	}
 
 	return nil
@@ -86,10 +251,34 @@ func (post *Post) HandleCommand(command Command) (*Events, error) {
 		return post.publish(cmd)
 	case *RewordPostCommand:
 		return post.reword(cmd)
+	case *RevertPostCommand:
+		return post.revert(cmd)
+	case *TagPostCommand:
+		return post.tag(cmd)
+	case *UntagPostCommand:
+		return post.untag(cmd)
 	case *CommentOnPostCommand:
 		return post.comment(cmd)
+	case *AuthorCommentOnPostCommand:
+		return post.authorComment(cmd)
 	case *PostAuthenticateCommentCommand:
 		return post.authenticateComment(cmd)
+	case *ResendCommentAuthenticationCommand:
+		return post.resendCommentAuthentication(cmd)
+	case *ExpireCommentCommand:
+		return post.expireComment(cmd)
+	case *EditCommentCommand:
+		return post.editComment(cmd)
+	case *WithdrawCommentCommand:
+		return post.withdrawComment(cmd)
+	case *ApproveCommentCommand:
+		return post.approveComment(cmd)
+	case *RejectCommentCommand:
+		return post.rejectComment(cmd)
+	case *HideCommentCommand:
+		return post.hideComment(cmd)
+	case *DeleteCommentCommand:
+		return post.deleteComment(cmd)
 	}
 
 	return NoEvents, nil
@@ -113,8 +302,10 @@ func (post *Post) publish(cmd *PublishPostCommand) (*Events, error) {
 	} else {
 		return ListOfEvents(&PostPublishedEvent{
 			PostId:      Id(),
+			AuthorId:    cmd.AuthorId,
 			Title:       cmd.Title,
 			Content:     cmd.Content,
+			Tags:        cmd.Tags,
 			PublishedAt: time.Now(),
 		}), nil
 	}
@@ -138,40 +329,322 @@ func (post *Post) reword(cmd *RewordPostCommand) (*Events, error) {
 	}), nil
 }
 
+// revert rewords the post with the content of an earlier revision.
+func (post *Post) revert(cmd *RevertPostCommand) (*Events, error) {
+	verr := ValidationError{}
+	if cmd.PostId != post.id {
+		verr.Add("Post", ErrNotFound)
+	}
+	if cmd.Revision < 1 || cmd.Revision > len(post.revisions) {
+		verr.Add("Revision", ErrOutOfRange)
+	}
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
+	}
+
+	content := post.revisions[cmd.Revision-1]
+	if content == post.content {
+		return NoEvents, nil
+	}
+
+	return ListOfEvents(&PostRewordedEvent{
+		PostId:          post.id,
+		Reason:          fmt.Sprintf("Reverted to revision %d", cmd.Revision),
+		RewordedContent: content,
+		RewordedAt:      time.Now(),
+	}), nil
+}
+
+func (post *Post) tag(cmd *TagPostCommand) (*Events, error) {
+	verr := ValidationError{}
+	if cmd.Tag == "" {
+		verr.Add("Tag", ErrEmpty)
+	}
+	if cmd.PostId != post.id {
+		verr.Add("Post", ErrNotFound)
+	}
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
+	}
+
+	if post.tags[cmd.Tag] {
+		return NoEvents, nil
+	}
+
+	return ListOfEvents(&PostTaggedEvent{
+		PostId:   post.id,
+		TagName:  cmd.Tag,
+		TaggedAt: time.Now(),
+	}), nil
+}
+
+func (post *Post) untag(cmd *UntagPostCommand) (*Events, error) {
+	verr := ValidationError{}
+	if cmd.Tag == "" {
+		verr.Add("Tag", ErrEmpty)
+	} else if !post.tags[cmd.Tag] {
+		verr.Add("Tag", ErrNotFound)
+	}
+	if cmd.PostId != post.id {
+		verr.Add("Post", ErrNotFound)
+	}
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
+	}
+
+	return ListOfEvents(&PostUntaggedEvent{
+		PostId:     post.id,
+		TagName:    cmd.Tag,
+		UntaggedAt: time.Now(),
+	}), nil
+}
+
 func (post *Post) authenticateComment(cmd *PostAuthenticateCommentCommand) (*Events, error) {
 	verr := ValidationError{}
 	comment := post.comments[cmd.CommentId]
-	if comment == nil {
+	if comment == nil || comment.deleted || comment.rejected || comment.withdrawn {
 		verr.Add("Comment", ErrNotFound)
 	} else if comment.authenticated {
 		verr.Add("Comment", ErrAlreadyAuthenticated)
+	} else if comment.expired || post.authenticationExpired(comment) {
+		verr.Add("Comment", ErrExpired)
+	} else if post.orphaned(comment) {
+		verr.Add("Parent", ErrNotFound)
 	}
 
 	return ListOfEvents(&PostCommentAuthenticatedEvent{
 		PostId:          post.id,
 		CommentId:       cmd.CommentId,
 		AuthenticatedAt: time.Now(),
+		PendingApproval: post.posts.config.RequireApproval,
 	}), verr.Return()
 }
 
-func (post *Post) comment(cmd *CommentOnPostCommand) (*Events, error) {
+// authenticationExpired returns true if comment has not been
+// authenticated in time.
+func (post *Post) authenticationExpired(comment *PostComment) bool {
+	expiry := post.posts.config.AuthenticationExpiry
+	return expiry > 0 && time.Since(comment.requestedAt) > expiry
+}
+
+func (post *Post) resendCommentAuthentication(cmd *ResendCommentAuthenticationCommand) (*Events, error) {
+	verr := ValidationError{}
+	comment := post.comments[cmd.CommentId]
+	if comment == nil || comment.gone() {
+		verr.Add("Comment", ErrNotFound)
+	} else if comment.authenticated {
+		verr.Add("Comment", ErrAlreadyAuthenticated)
+	} else if comment.resends >= post.posts.config.MaxResends {
+		verr.Add("Comment", ErrTooManyResends)
+	}
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
+	}
+
+	return ListOfEvents(&CommentAuthenticationRequestedEvent{
+		PostId:      post.id,
+		CommentId:   comment.id,
+		AuthorName:  comment.authorName,
+		AuthorEmail: comment.authorEmail,
+		RequestedAt: time.Now(),
+	}), nil
+}
+
+func (post *Post) expireComment(cmd *ExpireCommentCommand) (*Events, error) {
+	verr := ValidationError{}
+	comment := post.moderatedComment(cmd.PostId, cmd.CommentId, verr)
+	if comment != nil && comment.authenticated {
+		verr.Add("Comment", ErrAlreadyAuthenticated)
+	}
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
+	}
+
+	if comment.gone() || !post.authenticationExpired(comment) {
+		return NoEvents, nil
+	}
+
+	return ListOfEvents(&CommentExpiredEvent{
+		PostId:    post.id,
+		CommentId: comment.id,
+		ExpiredAt: time.Now(),
+	}), nil
+}
+
+// ownComment returns the comment identified by commentId if its author
+// can still change it.
+func (post *Post) ownComment(commentId string, verr ValidationError) *PostComment {
+	comment := post.comments[commentId]
+	if comment == nil || comment.deleted || comment.rejected || comment.expired {
+		verr.Add("Comment", ErrNotFound)
+		return nil
+	}
+	if comment.withdrawn {
+		verr.Add("Comment", ErrWithdrawn)
+		return nil
+	}
+
+	return comment
+}
+
+func (post *Post) editComment(cmd *EditCommentCommand) (*Events, error) {
 	verr := ValidationError{}
 	if cmd.Content == "" {
 		verr.Add("Content", ErrEmpty)
 	}
-	if cmd.Author == "" {
-		verr.Add("Author", ErrEmpty)
+	comment := post.ownComment(cmd.CommentId, verr)
+	if comment != nil && time.Since(comment.commentedAt) > post.posts.config.EditWindow {
+		verr.Add("Comment", ErrEditWindowClosed)
 	}
-	if cmd.Email == "" {
-		verr.Add("Email", ErrEmpty)
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
 	}
-	if cmd.PostId != post.id {
+
+	if cmd.Content == comment.content {
+		return NoEvents, nil
+	}
+
+	return ListOfEvents(&CommentEditedEvent{
+		PostId:    post.id,
+		CommentId: cmd.CommentId,
+		Content:   cmd.Content,
+		EditedAt:  time.Now(),
+
+		// Otherwise moderation could be bypassed by editing a
+		// comment after it has been approved.
+		PendingApproval: post.posts.config.RequireApproval && comment.published(),
+	}), nil
+}
+
+func (post *Post) withdrawComment(cmd *WithdrawCommentCommand) (*Events, error) {
+	verr := ValidationError{}
+	post.ownComment(cmd.CommentId, verr)
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
+	}
+
+	return ListOfEvents(&CommentWithdrawnEvent{
+		PostId:      post.id,
+		CommentId:   cmd.CommentId,
+		WithdrawnAt: time.Now(),
+	}), nil
+}
+
+// moderatedComment returns the comment identified by commentId if it
+// can be moderated, i.e. if it exists and has not been deleted.
+func (post *Post) moderatedComment(postId, commentId string, verr ValidationError) *PostComment {
+	if postId != post.id {
 		verr.Add("Post", ErrNotFound)
 	}
 
+	comment := post.comments[commentId]
+	if comment == nil || comment.deleted {
+		verr.Add("Comment", ErrNotFound)
+		return nil
+	}
+
+	return comment
+}
+
+func (post *Post) approveComment(cmd *ApproveCommentCommand) (*Events, error) {
+	verr := ValidationError{}
+	comment := post.moderatedComment(cmd.PostId, cmd.CommentId, verr)
+	if comment != nil {
+		if comment.gone() {
+			verr.Add("Comment", ErrNotFound)
+		} else if !comment.authenticated {
+			verr.Add("Comment", ErrNotAuthenticated)
+		} else if comment.published() {
+			verr.Add("Comment", ErrAlreadyPublished)
+		} else if post.orphaned(comment) {
+			verr.Add("Parent", ErrNotFound)
+		}
+	}
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
+	}
+
+	return ListOfEvents(&PostCommentApprovedEvent{
+		PostId:     post.id,
+		CommentId:  cmd.CommentId,
+		ApprovedAt: time.Now(),
+	}), nil
+}
+
+func (post *Post) rejectComment(cmd *RejectCommentCommand) (*Events, error) {
+	verr := ValidationError{}
+	comment := post.moderatedComment(cmd.PostId, cmd.CommentId, verr)
+	if comment != nil && (comment.published() || comment.hidden) {
+		verr.Add("Comment", ErrAlreadyPublished)
+	}
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
+	}
+
+	if comment.rejected {
+		return NoEvents, nil
+	}
+
+	return ListOfEvents(&PostCommentRejectedEvent{
+		PostId:     post.id,
+		CommentId:  cmd.CommentId,
+		RejectedAt: time.Now(),
+	}), nil
+}
+
+func (post *Post) hideComment(cmd *HideCommentCommand) (*Events, error) {
+	verr := ValidationError{}
+	comment := post.moderatedComment(cmd.PostId, cmd.CommentId, verr)
+	if comment != nil && !comment.published() {
+		verr.Add("Comment", ErrNotPublished)
+	}
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
+	}
+
+	return ListOfEvents(&PostCommentHiddenEvent{
+		PostId:    post.id,
+		CommentId: cmd.CommentId,
+		HiddenAt:  time.Now(),
+	}), nil
+}
+
+func (post *Post) deleteComment(cmd *DeleteCommentCommand) (*Events, error) {
+	verr := ValidationError{}
+	post.moderatedComment(cmd.PostId, cmd.CommentId, verr)
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
+	}
+
+	return ListOfEvents(&PostCommentDeletedEvent{
+		PostId:    post.id,
+		CommentId: cmd.CommentId,
+		DeletedAt: time.Now(),
+	}), nil
+}
+
+func (post *Post) comment(cmd *CommentOnPostCommand) (*Events, error) {
+	verr := ValidationError{}
+	if cmd.Email == "" {
+		verr.Add("Email", ErrEmpty)
+	}
+	post.validateComment(cmd.PostId, cmd.ParentId, cmd.Author, cmd.Content, verr)
+
 	return ListOfEvents(&PostCommentedEvent{
 		PostId:      post.id,
 		CommentId:   Id(),
+		ParentId:    cmd.ParentId,
 		Content:     cmd.Content,
 		AuthorName:  cmd.Author,
 		AuthorEmail: cmd.Email,
@@ -179,6 +652,59 @@ func (post *Post) comment(cmd *CommentOnPostCommand) (*Events, error) {
 	}), verr.Return()
 }
 
+func (post *Post) authorComment(cmd *AuthorCommentOnPostCommand) (*Events, error) {
+	verr := ValidationError{}
+	post.validateComment(cmd.PostId, cmd.ParentId, cmd.Author, cmd.Content, verr)
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
+	}
+
+	now := time.Now()
+	commentId := Id()
+	return ListOfEvents(
+		&PostCommentedEvent{
+			PostId:      post.id,
+			CommentId:   commentId,
+			ParentId:    cmd.ParentId,
+			Content:     cmd.Content,
+			AuthorName:  cmd.Author,
+			AuthorEmail: cmd.Email,
+			CommentedAt: now,
+			ByAuthor:    true,
+		},
+		&PostCommentAuthenticatedEvent{
+			PostId:          post.id,
+			CommentId:       commentId,
+			AuthenticatedAt: now,
+		},
+	), nil
+}
+
+func (post *Post) validateComment(postId, parentId, author, content string, verr ValidationError) {
+	if content == "" {
+		verr.Add("Content", ErrEmpty)
+	}
+	if author == "" {
+		verr.Add("Author", ErrEmpty)
+	}
+	if postId != post.id {
+		verr.Add("Post", ErrNotFound)
+	}
+	if parentId != "" {
+		parent := post.comments[parentId]
+		if parent == nil || parent.gone() {
+			verr.Add("Parent", ErrNotFound)
+		} else if !parent.authenticated {
+			verr.Add("Parent", ErrNotAuthenticated)
+		} else if !parent.published() {
+			// Replies to comments readers cannot see would be
+			// published without their context.
+			verr.Add("Parent", ErrNotFound)
+		}
+	}
+}
+
 func (post *Post) uniqueTitle(title string) bool {
 	return post.posts.UniqueTitle(title)
 }
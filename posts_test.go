@@ -1,7 +1,9 @@
 package main_test
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/dhamidi/blog"
 )
@@ -98,3 +100,506 @@ func TestPost_Comment_RequiresExistingPost(t *testing.T) {
 		t.Fatalf("Expected post to be %s, got %s", main.ErrNotFound, perr)
 	}
 }
+
+func TestPost_Tag_IgnoresExistingTag(t *testing.T) {
+	published := &main.PostPublishedEvent{
+		PostId:  main.Id(),
+		Title:   "post-title",
+		Content: "post-content",
+		Tags:    []string{"go"},
+	}
+	posts := &main.Posts{}
+	post := posts.New()
+	post.HandleEvent(published)
+
+	cmd := &main.TagPostCommand{PostId: published.PostId, Tag: " Go "}
+	cmd.Sanitize()
+	events, err := post.HandleCommand(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if events.Len() != 0 {
+		t.Fatalf("Expected no events, got %d", events.Len())
+	}
+}
+
+func TestPost_Revert_RestoresEarlierRevision(t *testing.T) {
+	published := &main.PostPublishedEvent{
+		PostId:  main.Id(),
+		Title:   "post-title",
+		Content: "original",
+	}
+	posts := &main.Posts{}
+	post := posts.New()
+	post.HandleEvent(published)
+	post.HandleEvent(&main.PostRewordedEvent{PostId: published.PostId, RewordedContent: "reworded"})
+
+	if _, err := post.HandleCommand(&main.RevertPostCommand{PostId: published.PostId, Revision: 3}); err == nil {
+		t.Fatal("Expected an error.")
+	}
+
+	events, err := post.HandleCommand(&main.RevertPostCommand{PostId: published.PostId, Revision: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reworded := events.Items()[0].(*main.PostRewordedEvent)
+	if reworded.RewordedContent != published.Content {
+		t.Fatalf("Expected content %q, got %q", published.Content, reworded.RewordedContent)
+	}
+	if reworded.Reason == "" {
+		t.Fatal("Expected a reason.")
+	}
+}
+
+func TestPost_Untag_RequiresExistingTag(t *testing.T) {
+	published := &main.PostPublishedEvent{
+		PostId:  main.Id(),
+		Title:   "post-title",
+		Content: "post-content",
+	}
+	posts := &main.Posts{}
+	post := posts.New()
+	post.HandleEvent(published)
+
+	_, err := post.HandleCommand(&main.UntagPostCommand{
+		PostId: published.PostId,
+		Tag:    "go",
+	})
+
+	if err == nil {
+		t.Fatal("Expected an error.")
+	}
+
+	verr := err.(main.ValidationError)
+	if terr := verr.Get("Tag"); terr != main.ErrNotFound {
+		t.Fatalf("Expected tag to be %s, got %s", main.ErrNotFound, terr)
+	}
+}
+
+func TestPost_ApproveComment_RequiresAuthentication(t *testing.T) {
+	published := &main.PostPublishedEvent{
+		PostId:  main.Id(),
+		Title:   "post-title",
+		Content: "post-content",
+	}
+	commented := &main.PostCommentedEvent{
+		PostId:    published.PostId,
+		CommentId: main.Id(),
+	}
+	posts := main.NewPosts(main.PostsConfig{RequireApproval: true})
+	post := posts.New()
+	post.HandleEvent(published)
+	post.HandleEvent(commented)
+
+	approve := &main.ApproveCommentCommand{
+		PostId:    published.PostId,
+		CommentId: commented.CommentId,
+	}
+	_, err := post.HandleCommand(approve)
+	if err == nil {
+		t.Fatal("Expected an error.")
+	}
+
+	verr := err.(main.ValidationError)
+	if cerr := verr.Get("Comment"); cerr != main.ErrNotAuthenticated {
+		t.Fatalf("Expected comment to be %s, got %s", main.ErrNotAuthenticated, cerr)
+	}
+
+	events, err := post.HandleCommand(&main.PostAuthenticateCommentCommand{
+		CommentId: commented.CommentId,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticated := events.Items()[0].(*main.PostCommentAuthenticatedEvent)
+	if !authenticated.PendingApproval {
+		t.Fatal("Expected comment to be pending approval.")
+	}
+	post.HandleEvent(authenticated)
+
+	if _, err := post.HandleCommand(approve); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPost_Comment_RequiresAuthenticatedParent(t *testing.T) {
+	published := &main.PostPublishedEvent{
+		PostId:  main.Id(),
+		Title:   "post-title",
+		Content: "post-content",
+	}
+	parent := &main.PostCommentedEvent{
+		PostId:    published.PostId,
+		CommentId: main.Id(),
+	}
+	posts := &main.Posts{}
+	post := posts.New()
+	post.HandleEvent(published)
+	post.HandleEvent(parent)
+
+	_, err := post.HandleCommand(&main.CommentOnPostCommand{
+		PostId:   published.PostId,
+		ParentId: parent.CommentId,
+		Author:   "author",
+		Email:    "author@example.com",
+		Content:  "comment-content",
+	})
+
+	if err == nil {
+		t.Fatal("Expected an error.")
+	}
+
+	verr := err.(main.ValidationError)
+	if perr := verr.Get("Parent"); perr != main.ErrNotAuthenticated {
+		t.Fatalf("Expected parent to be %s, got %s", main.ErrNotAuthenticated, perr)
+	}
+}
+
+func TestPost_Comment_RequiresPublishedParent(t *testing.T) {
+	published := &main.PostPublishedEvent{
+		PostId:  main.Id(),
+		Title:   "post-title",
+		Content: "post-content",
+	}
+	parent := &main.PostCommentedEvent{
+		PostId:    published.PostId,
+		CommentId: main.Id(),
+	}
+	posts := main.NewPosts(main.PostsConfig{RequireApproval: true})
+	post := posts.New()
+	post.HandleEvent(published)
+	post.HandleEvent(parent)
+	post.HandleEvent(&main.PostCommentAuthenticatedEvent{
+		PostId:          published.PostId,
+		CommentId:       parent.CommentId,
+		PendingApproval: true,
+	})
+
+	reply := &main.CommentOnPostCommand{
+		PostId:   published.PostId,
+		ParentId: parent.CommentId,
+		Author:   "author",
+		Email:    "author@example.com",
+		Content:  "comment-content",
+	}
+	_, err := post.HandleCommand(reply)
+	if err == nil {
+		t.Fatal("Expected an error.")
+	}
+
+	verr := err.(main.ValidationError)
+	if perr := verr.Get("Parent"); perr != main.ErrNotFound {
+		t.Fatalf("Expected parent to be %s, got %s", main.ErrNotFound, perr)
+	}
+
+	post.HandleEvent(&main.PostCommentApprovedEvent{PostId: published.PostId, CommentId: parent.CommentId})
+	post.HandleEvent(&main.PostCommentHiddenEvent{PostId: published.PostId, CommentId: parent.CommentId})
+	if _, err := post.HandleCommand(reply); err == nil {
+		t.Fatal("Expected replies to hidden comments to be refused.")
+	}
+}
+
+func TestPost_AuthorComment_IsAuthenticatedImmediately(t *testing.T) {
+	published := &main.PostPublishedEvent{
+		PostId:  main.Id(),
+		Title:   "post-title",
+		Content: "post-content",
+	}
+	posts := main.NewPosts(main.PostsConfig{RequireApproval: true})
+	post := posts.New()
+	post.HandleEvent(published)
+
+	events, err := post.HandleCommand(&main.AuthorCommentOnPostCommand{
+		PostId:  published.PostId,
+		Author:  "author",
+		Content: "comment-content",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commented := events.Items()[0].(*main.PostCommentedEvent)
+	if !commented.ByAuthor {
+		t.Fatal("Expected comment to be marked as written by the author.")
+	}
+
+	authenticated := events.Items()[1].(*main.PostCommentAuthenticatedEvent)
+	if authenticated.CommentId != commented.CommentId || authenticated.PendingApproval {
+		t.Fatalf("Expected comment to be published, got %#v", authenticated)
+	}
+}
+
+func TestPost_EditComment_RequiresOpenEditWindow(t *testing.T) {
+	published := &main.PostPublishedEvent{
+		PostId:  main.Id(),
+		Title:   "post-title",
+		Content: "post-content",
+	}
+	commented := &main.PostCommentedEvent{
+		PostId:      published.PostId,
+		CommentId:   main.Id(),
+		Content:     "comment-content",
+		CommentedAt: time.Now().Add(-time.Hour),
+	}
+	posts := main.NewPosts(main.PostsConfig{EditWindow: 15 * time.Minute})
+	post := posts.New()
+	post.HandleEvent(published)
+	post.HandleEvent(commented)
+
+	_, err := post.HandleCommand(&main.EditCommentCommand{
+		CommentId: commented.CommentId,
+		Content:   "new-content",
+	})
+
+	if err == nil {
+		t.Fatal("Expected an error.")
+	}
+
+	verr := err.(main.ValidationError)
+	if cerr := verr.Get("Comment"); cerr != main.ErrEditWindowClosed {
+		t.Fatalf("Expected comment to be %s, got %s", main.ErrEditWindowClosed, cerr)
+	}
+
+	if _, err := post.HandleCommand(&main.WithdrawCommentCommand{
+		CommentId: commented.CommentId,
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPost_EditComment_RequiresApprovalAgain(t *testing.T) {
+	published := &main.PostPublishedEvent{
+		PostId:  main.Id(),
+		Title:   "post-title",
+		Content: "post-content",
+	}
+	commented := &main.PostCommentedEvent{
+		PostId:      published.PostId,
+		CommentId:   main.Id(),
+		Content:     "comment-content",
+		CommentedAt: time.Now(),
+	}
+	posts := main.NewPosts(main.PostsConfig{RequireApproval: true, EditWindow: 15 * time.Minute})
+	post := posts.New()
+	post.HandleEvent(published)
+	post.HandleEvent(commented)
+	post.HandleEvent(&main.PostCommentAuthenticatedEvent{
+		PostId:          published.PostId,
+		CommentId:       commented.CommentId,
+		PendingApproval: true,
+	})
+	post.HandleEvent(&main.PostCommentApprovedEvent{PostId: published.PostId, CommentId: commented.CommentId})
+
+	events, err := post.HandleCommand(&main.EditCommentCommand{
+		CommentId: commented.CommentId,
+		Content:   "new-content",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edited := events.Items()[0].(*main.CommentEditedEvent)
+	if !edited.PendingApproval {
+		t.Fatal("Expected edited comment to be pending approval.")
+	}
+}
+
+func TestPost_ResendCommentAuthentication_IsLimited(t *testing.T) {
+	published := &main.PostPublishedEvent{
+		PostId:  main.Id(),
+		Title:   "post-title",
+		Content: "post-content",
+	}
+	commented := &main.PostCommentedEvent{
+		PostId:      published.PostId,
+		CommentId:   main.Id(),
+		CommentedAt: time.Now(),
+	}
+	posts := main.NewPosts(main.PostsConfig{MaxResends: 1})
+	post := posts.New()
+	post.HandleEvent(published)
+	post.HandleEvent(commented)
+
+	resend := &main.ResendCommentAuthenticationCommand{CommentId: commented.CommentId}
+	events, err := post.HandleCommand(resend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events.ApplyTo(post)
+
+	_, err = post.HandleCommand(resend)
+	if err == nil {
+		t.Fatal("Expected an error.")
+	}
+
+	verr := err.(main.ValidationError)
+	if cerr := verr.Get("Comment"); cerr != main.ErrTooManyResends {
+		t.Fatalf("Expected comment to be %s, got %s", main.ErrTooManyResends, cerr)
+	}
+}
+
+func TestSigner_VerifyCommentAuthenticationToken(t *testing.T) {
+	signer := main.NewSigner("secret")
+	commentId := main.Id()
+
+	token := signer.CommentAuthenticationToken(commentId, time.Now().Add(time.Hour))
+	if id, err := signer.VerifyCommentAuthenticationToken(token); err != nil || id != commentId {
+		t.Fatalf("Expected token for %s to be valid, got %q, %v", commentId, id, err)
+	}
+
+	expired := signer.CommentAuthenticationToken(commentId, time.Now().Add(-time.Minute))
+	if _, err := signer.VerifyCommentAuthenticationToken(expired); err != main.ErrExpired {
+		t.Fatalf("Expected expired token to be %s, got %v", main.ErrExpired, err)
+	}
+
+	fields := strings.Split(expired, ".")
+	later := strings.Split(token, ".")[1]
+	tampered := []string{
+		main.Id() + "." + fields[1] + "." + fields[2],
+		fields[0] + "." + later + "." + fields[2],
+		fields[0] + "." + fields[1] + "." + strings.Split(token, ".")[2],
+		fields[0] + "." + fields[1],
+		main.NewSigner("other secret").CommentAuthenticationToken(commentId, time.Now().Add(time.Hour)),
+	}
+	for _, token := range tampered {
+		if _, err := signer.VerifyCommentAuthenticationToken(token); err != main.ErrInvalidSignature {
+			t.Fatalf("Expected tampered token %q to be %s, got %v", token, main.ErrInvalidSignature, err)
+		}
+	}
+}
+
+func TestCommentExpiryProcessor_ExpiresUnauthenticatedComments(t *testing.T) {
+	published := &main.PostPublishedEvent{
+		PostId:  main.Id(),
+		Title:   "post-title",
+		Content: "post-content",
+	}
+	expired := &main.PostCommentedEvent{
+		PostId:      published.PostId,
+		CommentId:   main.Id(),
+		CommentedAt: time.Now().Add(-2 * time.Hour),
+	}
+	authenticated := &main.PostCommentedEvent{
+		PostId:      published.PostId,
+		CommentId:   main.Id(),
+		CommentedAt: time.Now().Add(-2 * time.Hour),
+	}
+	events := []main.Event{
+		published,
+		expired,
+		authenticated,
+		&main.PostCommentAuthenticatedEvent{PostId: published.PostId, CommentId: authenticated.CommentId},
+	}
+
+	proc := main.NewCommentExpiryProcessor(time.Hour)
+	post := main.NewPosts(main.PostsConfig{AuthenticationExpiry: time.Hour}).New()
+	for _, event := range events {
+		proc.HandleEvent(event)
+		post.HandleEvent(event)
+	}
+
+	commands := proc.Expired(time.Now())
+	if len(commands) != 1 || commands[0].CommentId != expired.CommentId {
+		t.Fatalf("Expected only %s to be expired, got %v", expired.CommentId, commands)
+	}
+
+	result, err := post.HandleCommand(commands[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result.Items()[0].(*main.CommentExpiredEvent); !ok {
+		t.Fatalf("Expected comment to expire, got %v", result.Items())
+	}
+	result.ApplyTo(post)
+
+	if _, err := post.HandleCommand(&main.PostAuthenticateCommentCommand{CommentId: expired.CommentId}); err == nil {
+		t.Fatal("Expected expired comment not to be authenticated.")
+	}
+}
+
+func TestPost_AuthenticateComment_RefusesRepliesToDeletedComments(t *testing.T) {
+	published := &main.PostPublishedEvent{
+		PostId:  main.Id(),
+		Title:   "post-title",
+		Content: "post-content",
+	}
+	parent := &main.PostCommentedEvent{
+		PostId:    published.PostId,
+		CommentId: main.Id(),
+	}
+	reply := &main.PostCommentedEvent{
+		PostId:    published.PostId,
+		CommentId: main.Id(),
+		ParentId:  parent.CommentId,
+	}
+	events := []main.Event{
+		published,
+		parent,
+		&main.PostCommentAuthenticatedEvent{PostId: published.PostId, CommentId: parent.CommentId},
+		reply,
+		&main.PostCommentDeletedEvent{PostId: published.PostId, CommentId: parent.CommentId},
+	}
+
+	post := main.NewPosts(main.PostsConfig{}).New()
+	view := &main.AllPostsView{}
+	for _, event := range events {
+		post.HandleEvent(event)
+		view.HandleEvent(event)
+	}
+
+	_, err := post.HandleCommand(&main.PostAuthenticateCommentCommand{CommentId: reply.CommentId})
+	if verr, invalid := err.(main.ValidationError); !invalid || verr.Get("Parent") != main.ErrNotFound {
+		t.Fatalf("Expected Parent to be %s, got %v", main.ErrNotFound, err)
+	}
+
+	view.HandleEvent(&main.PostCommentAuthenticatedEvent{PostId: published.PostId, CommentId: reply.CommentId})
+	if comments := view.ById(published.PostId).Comments; len(comments) != 0 {
+		t.Fatalf("Expected the reply not to be published as a top-level comment, got %d comments", len(comments))
+	}
+}
+
+func TestPost_ApproveComment_RequiresExistingComment(t *testing.T) {
+	published := &main.PostPublishedEvent{
+		PostId:  main.Id(),
+		Title:   "post-title",
+		Content: "post-content",
+	}
+
+	for _, removed := range []func(commentId string) main.Event{
+		func(commentId string) main.Event {
+			return &main.CommentWithdrawnEvent{PostId: published.PostId, CommentId: commentId}
+		},
+		func(commentId string) main.Event {
+			return &main.PostCommentRejectedEvent{PostId: published.PostId, CommentId: commentId}
+		},
+		func(commentId string) main.Event {
+			return &main.CommentExpiredEvent{PostId: published.PostId, CommentId: commentId}
+		},
+	} {
+		commented := &main.PostCommentedEvent{
+			PostId:    published.PostId,
+			CommentId: main.Id(),
+		}
+		post := main.NewPosts(main.PostsConfig{RequireApproval: true}).New()
+		post.HandleEvent(published)
+		post.HandleEvent(commented)
+		post.HandleEvent(&main.PostCommentAuthenticatedEvent{
+			PostId:          published.PostId,
+			CommentId:       commented.CommentId,
+			PendingApproval: true,
+		})
+		event := removed(commented.CommentId)
+		post.HandleEvent(event)
+
+		_, err := post.HandleCommand(&main.ApproveCommentCommand{
+			PostId:    published.PostId,
+			CommentId: commented.CommentId,
+		})
+		if verr, invalid := err.(main.ValidationError); !invalid || verr.Get("Comment") != main.ErrNotFound {
+			t.Fatalf("Expected approving after %T to be %s, got %v", event, main.ErrNotFound, err)
+		}
+	}
+}
@@ -2,26 +2,37 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"net/url"
 	"os"
+	"sync"
+	"time"
 )
 
 type PostCommentProcessor struct {
 	mailer Mailer
 	posts  *AllPostsView
+	signer *Signer
+	config PostsConfig
 	useTls bool
 }
 
 func (proc *PostCommentProcessor) HandleEvent(event Event) error {
 	switch evt := event.(type) {
 	case *PostCommentedEvent:
-		return proc.authenticateComment(evt)
+		if evt.ByAuthor {
+			return nil
+		}
+		return proc.authenticateComment(evt.PostId, evt.CommentId, evt.AuthorName, evt.AuthorEmail, evt.CommentedAt)
+	case *CommentAuthenticationRequestedEvent:
+		return proc.authenticateComment(evt.PostId, evt.CommentId, evt.AuthorName, evt.AuthorEmail, evt.RequestedAt)
 	}
 
 	return nil
 }
 
-func (proc *PostCommentProcessor) authenticateComment(evt *PostCommentedEvent) error {
+// link returns an absolute URL for path on this blog.
+func (proc *PostCommentProcessor) link(path string, query url.Values) *url.URL {
 	scheme := "https"
 	host := os.Getenv("BLOG_PROXY")
 	if host == "" {
@@ -33,12 +44,22 @@ func (proc *PostCommentProcessor) authenticateComment(evt *PostCommentedEvent) e
 		}
 	}
 
-	post := proc.posts.ById(evt.PostId)
-	link := &url.URL{
-		Scheme: scheme,
-		Host:   host,
-		Path:   "/comments/" + evt.CommentId,
+	return &url.URL{
+		Scheme:   scheme,
+		Host:     host,
+		Path:     path,
+		RawQuery: query.Encode(),
 	}
+}
+
+func (proc *PostCommentProcessor) authenticateComment(postId, commentId, authorName, authorEmail string, requestedAt time.Time) error {
+	post := proc.posts.ById(postId)
+	expires := requestedAt.Add(proc.config.AuthenticationExpiry)
+	token := proc.signer.CommentAuthenticationToken(commentId, expires)
+	link := proc.link("/comments/"+token, nil)
+	manageLink := proc.link("/comments/"+commentId+"/manage", url.Values{
+		"signature": {proc.signer.SignCommentManagement(commentId)},
+	})
 
 	body := fmt.Sprintf(`Subject: Authenticate your comment
 
@@ -48,12 +69,119 @@ Please authenticate your comment on
 
     %q
 
-By clicking this link:
+By clicking this link before %s:
+
+    %s
+
+You can edit your comment during the first %s or withdraw it at any
+time by visiting this link:
 
-    %s`, evt.AuthorName, post.Title, link)
+    %s
+
+Please keep this link to yourself, anybody who has it can change your
+comment.`, authorName, post.Title,
+		expires.Format("02 Jan 2006 15:04 MST"), link,
+		proc.config.EditWindow, manageLink)
 
 	return proc.mailer.SendMessage(&MailMessage{
-		To:   []string{evt.AuthorEmail},
+		To:   []string{authorEmail},
 		Body: []byte(body),
 	})
 }
+
+// CommentExpiryProcessor expires comments that have not been
+// authenticated in time.  It needs to see all events, including those
+// replayed on startup, in order to know which comments are pending.
+type CommentExpiryProcessor struct {
+	expiry time.Duration
+
+	lock    sync.Mutex
+	pending map[string]*pendingComment
+}
+
+type pendingComment struct {
+	postId      string
+	requestedAt time.Time
+}
+
+func NewCommentExpiryProcessor(expiry time.Duration) *CommentExpiryProcessor {
+	return &CommentExpiryProcessor{
+		expiry:  expiry,
+		pending: map[string]*pendingComment{},
+	}
+}
+
+func (proc *CommentExpiryProcessor) HandleEvent(event Event) error {
+	proc.lock.Lock()
+	defer proc.lock.Unlock()
+
+	switch evt := event.(type) {
+	case *PostCommentedEvent:
+		if evt.CommentId == "" || evt.ByAuthor {
+			break
+		}
+		proc.pending[evt.CommentId] = &pendingComment{
+			postId:      evt.PostId,
+			requestedAt: evt.CommentedAt,
+		}
+	case *CommentAuthenticationRequestedEvent:
+		if comment := proc.pending[evt.CommentId]; comment != nil {
+			comment.requestedAt = evt.RequestedAt
+		}
+	case *PostCommentAuthenticatedEvent:
+		delete(proc.pending, evt.CommentId)
+	case *PostCommentRejectedEvent:
+		delete(proc.pending, evt.CommentId)
+	case *PostCommentDeletedEvent:
+		delete(proc.pending, evt.CommentId)
+	case *CommentWithdrawnEvent:
+		delete(proc.pending, evt.CommentId)
+	case *CommentExpiredEvent:
+		delete(proc.pending, evt.CommentId)
+	}
+
+	return nil
+}
+
+// Expired returns commands for expiring all comments whose
+// authentication period has passed at now.
+func (proc *CommentExpiryProcessor) Expired(now time.Time) []*ExpireCommentCommand {
+	proc.lock.Lock()
+	defer proc.lock.Unlock()
+
+	commands := []*ExpireCommentCommand{}
+	for commentId, comment := range proc.pending {
+		if now.Sub(comment.requestedAt) > proc.expiry {
+			commands = append(commands, &ExpireCommentCommand{
+				PostId:    comment.postId,
+				CommentId: commentId,
+			})
+		}
+	}
+
+	return commands
+}
+
+func (proc *CommentExpiryProcessor) forget(commentId string) {
+	proc.lock.Lock()
+	defer proc.lock.Unlock()
+
+	delete(proc.pending, commentId)
+}
+
+// Run checks for expired comments every interval and lets handler
+// expire them.  It never returns.
+func (proc *CommentExpiryProcessor) Run(handler CommandHandler, interval time.Duration) {
+	if proc.expiry <= 0 {
+		return
+	}
+
+	for now := range time.Tick(interval) {
+		for _, cmd := range proc.Expired(now) {
+			if _, err := handler.HandleCommand(cmd); err != nil {
+				log.Printf("CommentExpiryProcessor.Run: %s\n", err)
+				proc.forget(cmd.CommentId)
+			}
+		}
+	}
+}
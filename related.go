@@ -0,0 +1,214 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// relatedPostsCount is the number of related posts shown below
+	// a post.
+	relatedPostsCount = 5
+
+	// relatedTagWeight is how much sharing tags counts towards the
+	// similarity of two posts, compared to the similarity of their
+	// content.
+	relatedTagWeight = 0.5
+
+	// relatedMinScore is the similarity two posts need to have at
+	// least to be considered related.
+	relatedMinScore = 0.05
+)
+
+// relatedDocument holds the terms and tags of a post.
+type relatedDocument struct {
+	post  *AllPostsPost
+	terms map[string]int
+	tags  map[string]bool
+}
+
+func newRelatedDocument(post *AllPostsPost) *relatedDocument {
+	doc := &relatedDocument{
+		post:  post,
+		terms: map[string]int{},
+		tags:  map[string]bool{},
+	}
+
+	words := append(tokenize(post.Title), tokenize(plainText(post.Content))...)
+	language := detectLanguage(words)
+	for _, word := range words {
+		if !isStopWord(word, language) {
+			doc.terms[Stem(word, language)]++
+		}
+	}
+	for _, tag := range post.Tags {
+		doc.tags[tag] = true
+	}
+
+	return doc
+}
+
+// RelatedPostsView finds posts that are similar to each other, based on
+// the tags they share and the TF-IDF similarity of their content.  The
+// most similar posts are stored in the Related field of each post.
+type RelatedPostsView struct {
+	allPosts *AllPostsView
+
+	documents map[string]*relatedDocument
+	docFreq   map[string]int
+
+	// scores holds the similarity of every pair of posts.  Only the
+	// scores of a post that has changed are recomputed, so scores of
+	// other pairs are based on the document frequencies at the time
+	// they have been computed.
+	scores map[string]map[string]float64
+}
+
+func NewRelatedPostsView(allPosts *AllPostsView) *RelatedPostsView {
+	return &RelatedPostsView{
+		allPosts:  allPosts,
+		documents: map[string]*relatedDocument{},
+		docFreq:   map[string]int{},
+		scores:    map[string]map[string]float64{},
+	}
+}
+
+func (view *RelatedPostsView) HandleEvent(event Event) error {
+	switch evt := event.(type) {
+	case *PostPublishedEvent:
+		view.update(evt.PostId)
+	case *PostRewordedEvent:
+		view.update(evt.PostId)
+	case *PostTaggedEvent:
+		view.update(evt.PostId)
+	case *PostUntaggedEvent:
+		view.update(evt.PostId)
+	}
+
+	return nil
+}
+
+// update recomputes the similarity of the post with postId to all other
+// posts and the related posts of every post affected by the change.
+func (view *RelatedPostsView) update(postId string) {
+	post := view.allPosts.ById(postId)
+	if post == nil {
+		return
+	}
+
+	if old := view.documents[postId]; old != nil {
+		for term := range old.terms {
+			view.docFreq[term]--
+			if view.docFreq[term] == 0 {
+				delete(view.docFreq, term)
+			}
+		}
+	}
+
+	doc := newRelatedDocument(post)
+	for term := range doc.terms {
+		view.docFreq[term]++
+	}
+	view.documents[postId] = doc
+	if view.scores[postId] == nil {
+		view.scores[postId] = map[string]float64{}
+	}
+
+	for id, other := range view.documents {
+		if id == postId {
+			continue
+		}
+
+		score := view.similarity(doc, other)
+		view.scores[postId][id] = score
+		view.scores[id][postId] = score
+		if view.affects(other.post, post, score) {
+			view.rank(other.post)
+		}
+	}
+
+	view.rank(post)
+}
+
+// affects returns true if changing the similarity of changed to post
+// can change the related posts of post.
+func (view *RelatedPostsView) affects(post, changed *AllPostsPost, score float64) bool {
+	for _, related := range post.Related {
+		if related == changed {
+			return true
+		}
+	}
+
+	if len(post.Related) < relatedPostsCount {
+		return score >= relatedMinScore
+	}
+
+	least := post.Related[len(post.Related)-1]
+	return score > view.scores[post.Id][least.Id]
+}
+
+// rank picks the posts most similar to post as its related posts.
+func (view *RelatedPostsView) rank(post *AllPostsPost) {
+	scores := view.scores[post.Id]
+	candidates := []*AllPostsPost{}
+	for id, score := range scores {
+		if score >= relatedMinScore {
+			candidates = append(candidates, view.documents[id].post)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := scores[candidates[i].Id], scores[candidates[j].Id]
+		if a == b {
+			return candidates[j].publishedAt.Before(candidates[i].publishedAt)
+		}
+		return a > b
+	})
+	if len(candidates) > relatedPostsCount {
+		candidates = candidates[:relatedPostsCount]
+	}
+
+	post.Related = candidates
+}
+
+// similarity returns a score between 0 and 1 of how similar two posts
+// are, combining the Jaccard index of their tags and the cosine
+// similarity of their TF-IDF weighted terms.
+func (view *RelatedPostsView) similarity(a, b *relatedDocument) float64 {
+	shared, all := 0, len(a.tags)
+	for tag := range b.tags {
+		if a.tags[tag] {
+			shared++
+		} else {
+			all++
+		}
+	}
+	tags := 0.0
+	if all > 0 {
+		tags = float64(shared) / float64(all)
+	}
+
+	n := float64(len(view.documents))
+	weight := func(term string, count int) float64 {
+		return float64(count) * math.Log(1+n/float64(view.docFreq[term]))
+	}
+
+	dot, normA, normB := 0.0, 0.0, 0.0
+	for term, count := range a.terms {
+		w := weight(term, count)
+		normA += w * w
+		if other, found := b.terms[term]; found {
+			dot += w * weight(term, other)
+		}
+	}
+	for term, count := range b.terms {
+		w := weight(term, count)
+		normB += w * w
+	}
+	content := 0.0
+	if normA > 0 && normB > 0 {
+		content = dot / math.Sqrt(normA*normB)
+	}
+
+	return relatedTagWeight*tags + (1-relatedTagWeight)*content
+}
@@ -0,0 +1,45 @@
+package main_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dhamidi/blog"
+)
+
+func TestRelatedPostsView_PrefersSimilarPosts(t *testing.T) {
+	allPosts := &main.AllPostsView{}
+	related := main.NewRelatedPostsView(allPosts)
+	handle := func(event main.Event) {
+		allPosts.HandleEvent(event)
+		related.HandleEvent(event)
+	}
+	publish := func(title, content string, tags ...string) string {
+		id := main.Id()
+		handle(&main.PostPublishedEvent{PostId: id, Title: title, Content: content, Tags: tags, PublishedAt: time.Now()})
+		return id
+	}
+
+	goroutines := publish("Goroutines", "Goroutines and channels make concurrent programs simple.", "go")
+	channels := publish("Channels", "Buffered channels decouple goroutines in concurrent programs.", "go")
+	baking := publish("Baking bread", "Flour, water, salt and yeast are all you need for bread.", "food")
+
+	post := allPosts.ById(goroutines)
+	if len(post.Related) != 1 || post.Related[0].Id != channels {
+		t.Fatalf("Expected channels to be related to goroutines, got %#v", post.Related)
+	}
+
+	handle(&main.PostRewordedEvent{PostId: baking, RewordedContent: "Goroutines and channels, explained while baking bread."})
+	handle(&main.PostTaggedEvent{PostId: baking, TagName: "go"})
+	if len(post.Related) != 2 {
+		t.Fatalf("Expected the reworded post to become related, got %#v", post.Related)
+	}
+
+	handle(&main.PostRewordedEvent{PostId: channels, RewordedContent: "Nothing in common."})
+	handle(&main.PostUntaggedEvent{PostId: channels, TagName: "go"})
+	for _, other := range post.Related {
+		if other.Id == channels {
+			t.Fatalf("Expected channels to be no longer related, got %#v", post.Related)
+		}
+	}
+}
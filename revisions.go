@@ -0,0 +1,326 @@
+package main
+
+import (
+	"html/template"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	DiffEqual    = "equal"
+	DiffInserted = "inserted"
+	DiffDeleted  = "deleted"
+)
+
+// DiffChunk is a piece of text that is either present in both versions
+// of a text, or only in the old or the new one.
+type DiffChunk struct {
+	Kind string
+	Text string
+}
+
+// maxDiffCells limits the size of the table used for computing a diff.
+// Larger changes are shown as deleting the old and inserting the new
+// text.
+const maxDiffCells = 4000000
+
+// diffTokens returns the chunks that turn a into b, based on the
+// longest common subsequence of both.
+func diffTokens(a, b []string) []DiffChunk {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	chunks := []DiffChunk{}
+	chunks = appendChunk(chunks, DiffEqual, a[:prefix]...)
+
+	oldMiddle, newMiddle := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	n, m := len(oldMiddle), len(newMiddle)
+	if (n+1)*(m+1) > maxDiffCells {
+		chunks = appendChunk(chunks, DiffDeleted, oldMiddle...)
+		chunks = appendChunk(chunks, DiffInserted, newMiddle...)
+	} else {
+		// lcs[i][j] is the length of the longest common
+		// subsequence of oldMiddle[i:] and newMiddle[j:].
+		lcs := make([][]int32, n+1)
+		for i := range lcs {
+			lcs[i] = make([]int32, m+1)
+		}
+		for i := n - 1; i >= 0; i-- {
+			for j := m - 1; j >= 0; j-- {
+				if oldMiddle[i] == newMiddle[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < n && j < m {
+			switch {
+			case oldMiddle[i] == newMiddle[j]:
+				chunks = appendChunk(chunks, DiffEqual, oldMiddle[i])
+				i++
+				j++
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				chunks = appendChunk(chunks, DiffDeleted, oldMiddle[i])
+				i++
+			default:
+				chunks = appendChunk(chunks, DiffInserted, newMiddle[j])
+				j++
+			}
+		}
+		chunks = appendChunk(chunks, DiffDeleted, oldMiddle[i:]...)
+		chunks = appendChunk(chunks, DiffInserted, newMiddle[j:]...)
+	}
+
+	return appendChunk(chunks, DiffEqual, a[len(a)-suffix:]...)
+}
+
+// appendChunk adds tokens to chunks, merging them into the last chunk
+// if it is of the same kind.
+func appendChunk(chunks []DiffChunk, kind string, tokens ...string) []DiffChunk {
+	if len(tokens) == 0 {
+		return chunks
+	}
+
+	text := strings.Join(tokens, "")
+	if last := len(chunks) - 1; last >= 0 && chunks[last].Kind == kind {
+		chunks[last].Text += text
+		return chunks
+	}
+
+	return append(chunks, DiffChunk{Kind: kind, Text: text})
+}
+
+// splitLines splits text into lines, keeping the line endings.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// splitWords splits text into words and the whitespace between them.
+func splitWords(text string) []string {
+	words := []string{}
+	start, space := 0, false
+	for i, r := range text {
+		if i > start && unicode.IsSpace(r) != space {
+			words = append(words, text[start:i])
+			start = i
+		}
+		space = unicode.IsSpace(r)
+	}
+	if start < len(text) {
+		words = append(words, text[start:])
+	}
+	return words
+}
+
+// diffLines returns a line by line diff between from and to.
+func diffLines(from, to string) []DiffChunk {
+	return diffTokens(splitLines(from), splitLines(to))
+}
+
+// diffWords returns a word by word diff between from and to.  Lines are
+// compared first, so that only changed lines are compared word by word.
+func diffWords(from, to string) []DiffChunk {
+	chunks := []DiffChunk{}
+	lines := diffLines(from, to)
+	for i := 0; i < len(lines); i++ {
+		chunk := lines[i]
+		if chunk.Kind == DiffDeleted && i+1 < len(lines) && lines[i+1].Kind == DiffInserted {
+			for _, word := range diffTokens(splitWords(chunk.Text), splitWords(lines[i+1].Text)) {
+				chunks = appendChunk(chunks, word.Kind, word.Text)
+			}
+			i++
+		} else {
+			chunks = appendChunk(chunks, chunk.Kind, chunk.Text)
+		}
+	}
+
+	return chunks
+}
+
+// PostRevision is a version of a post's content.  The first revision
+// of every post is the one it was published with.
+type PostRevision struct {
+	Number      int
+	PostId      string
+	Content     string
+	ContentHTML template.HTML
+	Reason      string
+	Created     string
+
+	// Changes is a word diff against the previous revision, which is
+	// computed once when the revision is added.
+	Changes []DiffChunk
+
+	previous *PostRevision
+}
+
+func (revision *PostRevision) Previous() *PostRevision {
+	return revision.previous
+}
+
+// RenderHTML shows revision, offering to revert post to it if
+// mayRevert is set.
+func (revision *PostRevision) RenderHTML(post *AllPostsPost, mayRevert bool) []byte {
+	return renderTemplate("views/admin_revision.html", map[string]interface{}{
+		"Post":      post,
+		"Revision":  revision,
+		"MayRevert": mayRevert,
+	})
+}
+
+// PostRevisions lists all revisions of a post, oldest first.
+type PostRevisions struct {
+	Post      *AllPostsPost
+	Revisions []*PostRevision
+}
+
+// Get returns the revision with the given number, which starts at 1.
+func (revisions *PostRevisions) Get(number int) *PostRevision {
+	if number < 1 || number > len(revisions.Revisions) {
+		return nil
+	}
+
+	return revisions.Revisions[number-1]
+}
+
+// Edits returns all revisions after the first one, most recent first.
+func (revisions *PostRevisions) Edits() []*PostRevision {
+	edits := []*PostRevision{}
+	for i := len(revisions.Revisions) - 1; i > 0; i-- {
+		edits = append(edits, revisions.Revisions[i])
+	}
+
+	return edits
+}
+
+func (revisions *PostRevisions) RenderHTML(mayRevert bool) []byte {
+	return renderTemplate("views/admin_revisions.html", map[string]interface{}{
+		"Post":      revisions.Post,
+		"Revisions": revisions.Revisions,
+		"MayRevert": mayRevert,
+	})
+}
+
+// RevisionDiff compares two revisions of a post.
+type RevisionDiff struct {
+	Post   *AllPostsPost
+	From   *PostRevision
+	To     *PostRevision
+	Words  bool
+	Chunks []DiffChunk
+}
+
+// Diff compares the revisions from and to, either line by line or word
+// by word.
+func (revisions *PostRevisions) Diff(from, to int, words bool) (*RevisionDiff, error) {
+	verr := ValidationError{}
+	fromRevision, toRevision := revisions.Get(from), revisions.Get(to)
+	if fromRevision == nil {
+		verr.Add("From", ErrNotFound)
+	}
+	if toRevision == nil {
+		verr.Add("To", ErrNotFound)
+	}
+
+	if err := verr.Return(); err != nil {
+		return nil, err
+	}
+
+	diff := &RevisionDiff{
+		Post:  revisions.Post,
+		From:  fromRevision,
+		To:    toRevision,
+		Words: words,
+	}
+	if words {
+		diff.Chunks = diffWords(fromRevision.Content, toRevision.Content)
+	} else {
+		diff.Chunks = diffLines(fromRevision.Content, toRevision.Content)
+	}
+
+	return diff, nil
+}
+
+func (diff *RevisionDiff) RenderHTML() []byte {
+	return renderTemplate("views/admin_revision_diff.html", diff)
+}
+
+// RevisionsView keeps every version of every post.  If public is set,
+// the revisions are attached to the posts, so that readers can see
+// what has been changed.
+type RevisionsView struct {
+	allPosts *AllPostsView
+	public   bool
+	byPost   map[string]*PostRevisions
+}
+
+func NewRevisionsView(allPosts *AllPostsView, public bool) *RevisionsView {
+	return &RevisionsView{
+		allPosts: allPosts,
+		public:   public,
+		byPost:   map[string]*PostRevisions{},
+	}
+}
+
+func (view *RevisionsView) HandleEvent(event Event) error {
+	switch evt := event.(type) {
+	case *PostPublishedEvent:
+		revisions := &PostRevisions{
+			Post:      view.allPosts.ById(evt.PostId),
+			Revisions: []*PostRevision{},
+		}
+		view.byPost[evt.PostId] = revisions
+		view.addRevision(evt.PostId, evt.Content, "", evt.PublishedAt)
+		if view.public && revisions.Post != nil {
+			revisions.Post.Revisions = revisions
+		}
+	case *PostRewordedEvent:
+		view.addRevision(evt.PostId, evt.RewordedContent, evt.Reason, evt.RewordedAt)
+	}
+
+	return nil
+}
+
+func (view *RevisionsView) addRevision(postId, content, reason string, at time.Time) {
+	revisions := view.byPost[postId]
+	if revisions == nil {
+		return
+	}
+
+	revision := &PostRevision{
+		Number:      len(revisions.Revisions) + 1,
+		PostId:      postId,
+		Content:     content,
+		ContentHTML: textToHTML(content, false),
+		Reason:      reason,
+		Created:     at.Format("02 Jan 2006, 15:04:05 MST"),
+		Changes:     []DiffChunk{},
+	}
+	if n := len(revisions.Revisions); n > 0 {
+		revision.previous = revisions.Revisions[n-1]
+		revision.Changes = diffWords(revision.previous.Content, content)
+	}
+
+	revisions.Revisions = append(revisions.Revisions, revision)
+}
+
+func (view *RevisionsView) ForPost(postId string) *PostRevisions {
+	return view.byPost[postId]
+}
@@ -0,0 +1,78 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/dhamidi/blog"
+)
+
+func TestRevisions_Diff_ComparesWords(t *testing.T) {
+	allPosts := &main.AllPostsView{}
+	view := main.NewRevisionsView(allPosts, false)
+	postId := main.Id()
+	for _, event := range []main.Event{
+		&main.PostPublishedEvent{PostId: postId, Title: "title", Content: "first line\nthe quick fox\n"},
+		&main.PostRewordedEvent{PostId: postId, RewordedContent: "first line\nthe slow fox\n"},
+	} {
+		allPosts.HandleEvent(event)
+		view.HandleEvent(event)
+	}
+
+	diff, err := view.ForPost(postId).Diff(1, 2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []main.DiffChunk{
+		{Kind: main.DiffEqual, Text: "first line\nthe "},
+		{Kind: main.DiffDeleted, Text: "quick"},
+		{Kind: main.DiffInserted, Text: "slow"},
+		{Kind: main.DiffEqual, Text: " fox\n"},
+	}
+	if len(diff.Chunks) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, diff.Chunks)
+	}
+	for i, chunk := range diff.Chunks {
+		if chunk != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, diff.Chunks)
+		}
+	}
+}
+
+func TestRevisions_Diff_RequiresExistingRevisions(t *testing.T) {
+	allPosts := &main.AllPostsView{}
+	view := main.NewRevisionsView(allPosts, false)
+	postId := main.Id()
+	view.HandleEvent(&main.PostPublishedEvent{PostId: postId, Content: "content"})
+
+	_, err := view.ForPost(postId).Diff(1, 2, false)
+	if err == nil {
+		t.Fatal("Expected an error.")
+	}
+
+	verr := err.(main.ValidationError)
+	if verr.Get("To") != main.ErrNotFound {
+		t.Fatalf("Expected To to be %s, got %s", main.ErrNotFound, verr.Get("To"))
+	}
+}
+
+func TestRevisions_Edits_StoreChanges(t *testing.T) {
+	allPosts := &main.AllPostsView{}
+	view := main.NewRevisionsView(allPosts, true)
+	postId := main.Id()
+	for _, event := range []main.Event{
+		&main.PostPublishedEvent{PostId: postId, Title: "title", Content: "the quick fox"},
+		&main.PostRewordedEvent{PostId: postId, RewordedContent: "the slow fox"},
+	} {
+		allPosts.HandleEvent(event)
+		view.HandleEvent(event)
+	}
+
+	edits := view.ForPost(postId).Edits()
+	if len(edits) != 1 {
+		t.Fatalf("Expected one edit, got %d", len(edits))
+	}
+	if changes := edits[0].Changes; len(changes) != 4 || changes[1].Kind != main.DiffDeleted || changes[2].Text != "slow" {
+		t.Fatalf("Expected the reworded word to be changed, got %v", changes)
+	}
+}
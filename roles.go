@@ -0,0 +1,113 @@
+package main
+
+import "sort"
+
+// Roles that can be granted to authors.  Every author can write posts
+// and change their own posts without any role.
+const (
+	RoleAdmin     = "admin"
+	RoleEditor    = "editor"
+	RoleModerator = "moderator"
+)
+
+// Permissions are granted through roles and required for handling
+// commands.
+const (
+	PermWritePosts   = "write-posts"
+	PermEditAllPosts = "edit-all-posts"
+	PermManageSite   = "manage-site"
+	PermModerate     = "moderate"
+	PermManageUsers  = "manage-users"
+)
+
+// rolePermissions lists the permissions of every role.  Admins have
+// all permissions.
+var rolePermissions = map[string][]string{
+	RoleAdmin:     {},
+	RoleEditor:    {PermEditAllPosts, PermManageSite},
+	RoleModerator: {PermModerate},
+}
+
+func validRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// requiredPermission returns the permission needed for handling
+// command.  Commands not listed here can only be handled by admins.
+func requiredPermission(command Command) string {
+	switch command.(type) {
+	case *PublishPostCommand, *PreviewPostCommand, *UploadMediaCommand, *AuthorCommentOnPostCommand:
+		return PermWritePosts
+	case *RewordPostCommand, *RevertPostCommand, *TagPostCommand, *UntagPostCommand:
+		return PermEditAllPosts
+	case *CreateSeriesCommand, *AddPostToSeriesCommand, *ReorderSeriesCommand,
+		*CreatePageCommand, *EditPageCommand, *DeletePageCommand:
+		return PermManageSite
+	case *ApproveCommentCommand, *RejectCommentCommand, *HideCommentCommand, *DeleteCommentCommand,
+		*BlockEmailCommand, *UnblockEmailCommand, *BlockDomainCommand, *UnblockDomainCommand:
+		return PermModerate
+	case *RegisterAuthorCommand, *UpdateAuthorProfileCommand, *ChangePasswordCommand,
+		*DeactivateAuthorCommand, *GrantRoleCommand, *RevokeRoleCommand, *DisableTwoFactorCommand:
+		return PermManageUsers
+	}
+
+	return ""
+}
+
+// Principal is the user on whose behalf a command is handled.  The
+// administrator configured through the environment has no AuthorId.
+type Principal struct {
+	AuthorId string
+	Login    string
+	Roles    []string
+}
+
+func (principal *Principal) Has(role string) bool {
+	for _, granted := range principal.Roles {
+		if granted == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// May returns true if one of principal's roles grants permission.
+func (principal *Principal) May(permission string) bool {
+	if principal.Has(RoleAdmin) || permission == PermWritePosts {
+		return true
+	}
+
+	for _, role := range principal.Roles {
+		for _, granted := range rolePermissions[role] {
+			if granted == permission {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// MayChange returns true if principal is allowed to change post.
+func (principal *Principal) MayChange(post *AllPostsPost) bool {
+	if principal.May(PermEditAllPosts) {
+		return true
+	}
+
+	return post.Author != nil && post.Author.Id == principal.AuthorId
+}
+
+// sortedRoles returns the roles set in roles in alphabetical order.
+func sortedRoles(roles map[string]bool) []string {
+	result := []string{}
+	for role, granted := range roles {
+		if granted {
+			result = append(result, role)
+		}
+	}
+	sort.Strings(result)
+
+	return result
+}
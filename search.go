@@ -0,0 +1,356 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Parameters of the Okapi BM25 ranking function.
+const (
+	searchK1 = 1.2
+	searchB  = 0.75
+)
+
+const (
+	// searchTitleWeight is how many times words in the title of a
+	// post count compared to words in the content.
+	searchTitleWeight = 3
+
+	// searchCommentWeight scales the score of matching comments
+	// relative to the post they belong to.
+	searchCommentWeight = 0.5
+
+	// searchMaxResults limits the number of posts listed.
+	searchMaxResults = 50
+
+	// searchSnippetWords is the number of words shown around the
+	// first match in a result.
+	searchSnippetWords = 30
+)
+
+// searchDocument is a post or comment in the search index.
+type searchDocument struct {
+	id       string
+	postId   string
+	title    string
+	language string
+	text     string
+	terms    map[string]int
+	length   int
+}
+
+// SearchIndex is an inverted index of the content of posts and their
+// published comments.
+type SearchIndex struct {
+	allPosts *AllPostsView
+
+	// postings maps terms to the documents containing them and how
+	// often they contain them.
+	postings    map[string]map[string]int
+	documents   map[string]*searchDocument
+	totalLength int
+
+	// pending holds the content of comments until they are
+	// published.
+	pending map[string]*PostCommentedEvent
+}
+
+func NewSearchIndex(allPosts *AllPostsView) *SearchIndex {
+	return &SearchIndex{
+		allPosts:  allPosts,
+		postings:  map[string]map[string]int{},
+		documents: map[string]*searchDocument{},
+		pending:   map[string]*PostCommentedEvent{},
+	}
+}
+
+func (index *SearchIndex) HandleEvent(event Event) error {
+	switch evt := event.(type) {
+	case *PostPublishedEvent:
+		index.add(evt.PostId, evt.PostId, evt.Title, evt.Content)
+	case *PostRewordedEvent:
+		if doc := index.documents[evt.PostId]; doc != nil {
+			index.add(evt.PostId, evt.PostId, doc.title, evt.RewordedContent)
+		}
+	case *PostCommentedEvent:
+		commented := *evt
+		index.pending[evt.CommentId] = &commented
+	case *PostCommentAuthenticatedEvent:
+		if !evt.PendingApproval {
+			index.publishComment(evt.CommentId)
+		}
+	case *PostCommentApprovedEvent:
+		index.publishComment(evt.CommentId)
+	case *CommentEditedEvent:
+		if commented := index.pending[evt.CommentId]; commented != nil {
+			commented.Content = evt.Content
+		}
+		if evt.PendingApproval {
+			index.remove(evt.CommentId)
+		} else if _, published := index.documents[evt.CommentId]; published {
+			index.publishComment(evt.CommentId)
+		}
+	case *PostCommentHiddenEvent:
+		index.remove(evt.CommentId)
+	case *CommentWithdrawnEvent:
+		index.removeComment(evt.CommentId)
+	case *PostCommentRejectedEvent:
+		index.removeComment(evt.CommentId)
+	case *PostCommentDeletedEvent:
+		index.removeComment(evt.CommentId)
+	case *CommentExpiredEvent:
+		index.removeComment(evt.CommentId)
+	}
+
+	return nil
+}
+
+func (index *SearchIndex) publishComment(commentId string) {
+	if commented := index.pending[commentId]; commented != nil {
+		index.add(commentId, commented.PostId, "", commented.Content)
+	}
+}
+
+func (index *SearchIndex) removeComment(commentId string) {
+	index.remove(commentId)
+	delete(index.pending, commentId)
+}
+
+// add indexes the document with id, replacing any previous version of
+// it.  Only the postings of the document itself are touched.
+func (index *SearchIndex) add(id, postId, title, content string) {
+	index.remove(id)
+
+	text := plainText(content)
+	words := tokenize(text)
+	language := detectLanguage(append(tokenize(title), words...))
+	doc := &searchDocument{
+		id:       id,
+		postId:   postId,
+		title:    title,
+		language: language,
+		text:     text,
+		terms:    map[string]int{},
+	}
+
+	for _, word := range tokenize(title) {
+		if !isStopWord(word, language) {
+			doc.terms[Stem(word, language)] += searchTitleWeight
+			doc.length += searchTitleWeight
+		}
+	}
+	for _, word := range words {
+		if !isStopWord(word, language) {
+			doc.terms[Stem(word, language)]++
+			doc.length++
+		}
+	}
+
+	for term, count := range doc.terms {
+		if index.postings[term] == nil {
+			index.postings[term] = map[string]int{}
+		}
+		index.postings[term][id] = count
+	}
+	index.documents[id] = doc
+	index.totalLength += doc.length
+}
+
+func (index *SearchIndex) remove(id string) {
+	doc := index.documents[id]
+	if doc == nil {
+		return
+	}
+
+	for term := range doc.terms {
+		delete(index.postings[term], id)
+		if len(index.postings[term]) == 0 {
+			delete(index.postings, term)
+		}
+	}
+	delete(index.documents, id)
+	index.totalLength -= doc.length
+}
+
+// queryTerms returns the stems of the words in query.  The language of
+// a query is not known, so words are stemmed for every language.
+func queryTerms(query string) map[string]bool {
+	terms := map[string]bool{}
+	for _, word := range tokenize(query) {
+		for _, language := range []string{English, German} {
+			if !isStopWord(word, language) {
+				terms[Stem(word, language)] = true
+			}
+		}
+	}
+
+	return terms
+}
+
+// score returns the BM25 score of every document matching terms.
+func (index *SearchIndex) score(terms map[string]bool) map[string]float64 {
+	scores := map[string]float64{}
+	if len(index.documents) == 0 {
+		return scores
+	}
+
+	n := float64(len(index.documents))
+	averageLength := float64(index.totalLength) / n
+	for term := range terms {
+		postings := index.postings[term]
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for id, count := range postings {
+			tf := float64(count)
+			length := float64(index.documents[id].length)
+			scores[id] += idf * tf * (searchK1 + 1) / (tf + searchK1*(1-searchB+searchB*length/averageLength))
+		}
+	}
+
+	return scores
+}
+
+// Search returns the posts matching query, best matches first.
+func (index *SearchIndex) Search(query string) *SearchResults {
+	results := &SearchResults{Query: query, Results: []*SearchResult{}}
+	terms := queryTerms(query)
+	if len(terms) == 0 {
+		return results
+	}
+
+	byPost := map[string]*SearchResult{}
+	best := map[string]float64{}
+	for id, score := range index.score(terms) {
+		doc := index.documents[id]
+		if doc.id != doc.postId {
+			score *= searchCommentWeight
+		}
+
+		result := byPost[doc.postId]
+		if result == nil {
+			post := index.allPosts.ById(doc.postId)
+			if post == nil {
+				continue
+			}
+			result = &SearchResult{Post: post}
+			byPost[doc.postId] = result
+		}
+		result.Score += score
+
+		if score > best[doc.postId] {
+			best[doc.postId] = score
+			result.Snippet = snippet(doc, terms)
+			result.InComment = doc.id != doc.postId
+		}
+	}
+
+	for _, result := range byPost {
+		results.Results = append(results.Results, result)
+	}
+	sort.Slice(results.Results, func(i, j int) bool {
+		if results.Results[i].Score == results.Results[j].Score {
+			return results.Results[j].Post.publishedAt.Before(results.Results[i].Post.publishedAt)
+		}
+		return results.Results[i].Score > results.Results[j].Score
+	})
+	if len(results.Results) > searchMaxResults {
+		results.Results = results.Results[:searchMaxResults]
+	}
+
+	return results
+}
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// plainText returns the text of the markdown in content without any
+// markup.
+func plainText(content string) string {
+	text := htmlTag.ReplaceAllString(string(textToHTML(content, true)), " ")
+	return strings.Join(strings.Fields(html.UnescapeString(text)), " ")
+}
+
+// snippet returns an excerpt of the text of doc around the first word
+// matching terms, with all matching words highlighted.
+func snippet(doc *searchDocument, terms map[string]bool) template.HTML {
+	words := strings.Fields(doc.text)
+	matches := func(word string) bool {
+		word = strings.ToLower(strings.TrimFunc(word, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}))
+		return word != "" && terms[Stem(word, doc.language)]
+	}
+
+	start := 0
+	for i, word := range words {
+		if matches(word) {
+			start = i - searchSnippetWords/3
+			break
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+	end := start + searchSnippetWords
+	if end > len(words) {
+		end = len(words)
+	}
+
+	out := []string{}
+	if start > 0 {
+		out = append(out, "…")
+	}
+	for _, word := range words[start:end] {
+		if matches(word) {
+			out = append(out, "<mark>"+template.HTMLEscapeString(word)+"</mark>")
+		} else {
+			out = append(out, template.HTMLEscapeString(word))
+		}
+	}
+	if end < len(words) {
+		out = append(out, "…")
+	}
+
+	return template.HTML(strings.Join(out, " "))
+}
+
+type SearchResult struct {
+	Post    *AllPostsPost
+	Score   float64
+	Snippet template.HTML
+
+	// InComment is set if the snippet has been taken from a
+	// comment on the post.
+	InComment bool
+}
+
+type SearchResults struct {
+	Query   string
+	Results []*SearchResult
+}
+
+func (results *SearchResults) RenderHTML() []byte {
+	return renderTemplate("views/search.html", results)
+}
+
+// searchQueryLimit is the maximum length of a query in bytes.
+const searchQueryLimit = 200
+
+// truncateQuery shortens query to at most searchQueryLimit bytes
+// without splitting characters.
+func truncateQuery(query string) string {
+	if len(query) <= searchQueryLimit {
+		return query
+	}
+
+	query = query[:searchQueryLimit]
+	for !utf8.ValidString(query) {
+		query = query[:len(query)-1]
+	}
+	return query
+}
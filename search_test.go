@@ -0,0 +1,65 @@
+package main_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhamidi/blog"
+)
+
+func TestStem(t *testing.T) {
+	examples := []struct{ word, language, stem string }{
+		{"caresses", main.English, "caress"},
+		{"ponies", main.English, "poni"},
+		{"relational", main.English, "relat"},
+		{"generalizations", main.English, "gener"},
+		{"hopping", main.English, "hop"},
+		{"hoping", main.English, "hope"},
+		{"connected", main.English, "connect"},
+		{"connection", main.English, "connect"},
+		{"häuser", main.German, "haus"},
+		{"katzen", main.German, "katz"},
+		{"aufeinanderfolgenden", main.German, "aufeinanderfolg"},
+		{"kategorien", main.German, "kategori"},
+		{"fröhlichkeit", main.German, "frohlich"},
+	}
+
+	for _, example := range examples {
+		if stem := main.Stem(example.word, example.language); stem != example.stem {
+			t.Errorf("Expected %s to be stemmed to %s, got %s", example.word, example.stem, stem)
+		}
+	}
+}
+
+func TestSearchIndex_Search_UpdatesOnReword(t *testing.T) {
+	allPosts := &main.AllPostsView{}
+	index := main.NewSearchIndex(allPosts)
+	now := time.Now()
+	for _, event := range []main.Event{
+		&main.PostPublishedEvent{PostId: "cats", Title: "On cats", Content: "Cats are sleeping all day.", PublishedAt: now},
+		&main.PostPublishedEvent{PostId: "dogs", Title: "On dogs", Content: "Dogs are barking at the mailman.", PublishedAt: now},
+	} {
+		allPosts.HandleEvent(event)
+		index.HandleEvent(event)
+	}
+
+	results := index.Search("sleep").Results
+	if len(results) != 1 || results[0].Post.Id != "cats" {
+		t.Fatalf("Expected only cats to match, got %#v", results)
+	}
+	if snippet := string(results[0].Snippet); !strings.Contains(snippet, "<mark>sleeping</mark>") {
+		t.Fatalf("Expected match to be highlighted in %q", snippet)
+	}
+
+	reworded := &main.PostRewordedEvent{PostId: "dogs", RewordedContent: "Dogs are sleeping too.", RewordedAt: now}
+	allPosts.HandleEvent(reworded)
+	index.HandleEvent(reworded)
+
+	if results := index.Search("barking"); len(results.Results) != 0 {
+		t.Fatalf("Expected old content to be removed, got %#v", results.Results)
+	}
+	if results := index.Search("sleeps"); len(results.Results) != 2 {
+		t.Fatalf("Expected both posts to match, got %d", len(results.Results))
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"net/url"
+	"time"
+)
+
+// AllSeries keeps track of the information that is needed across
+// series, like which post belongs to which series.
+type AllSeries struct {
+	titles map[string]bool
+	posts  map[string]bool
+
+	seriesForPost map[string]string
+}
+
+func (all *AllSeries) New() Aggregate {
+	return &Series{
+		all: all,
+	}
+}
+
+func (all *AllSeries) HandleEvent(event Event) error {
+	if all.titles == nil {
+		all.titles = map[string]bool{}
+	}
+	if all.posts == nil {
+		all.posts = map[string]bool{}
+	}
+	if all.seriesForPost == nil {
+		all.seriesForPost = map[string]string{}
+	}
+
+	switch evt := event.(type) {
+	case *PostPublishedEvent:
+		all.posts[evt.PostId] = true
+	case *SeriesCreatedEvent:
+		all.titles[evt.Title] = true
+	case *PostAddedToSeriesEvent:
+		all.seriesForPost[evt.PostId] = evt.SeriesId
+	}
+
+	return nil
+}
+
+func (all *AllSeries) UniqueTitle(title string) bool {
+	return all.titles[title] != true
+}
+
+func (all *AllSeries) PostExists(postId string) bool {
+	return all.posts[postId]
+}
+
+func (all *AllSeries) SeriesForPost(postId string) string {
+	return all.seriesForPost[postId]
+}
+
+type Series struct {
+	all     *AllSeries
+	id      string
+	postIds []string
+}
+
+func (series *Series) HandleEvent(event Event) error {
+	switch evt := event.(type) {
+	case *SeriesCreatedEvent:
+		series.id = evt.SeriesId
+	case *PostAddedToSeriesEvent:
+		series.postIds = insertAt(series.postIds, evt.Position, evt.PostId)
+	case *SeriesReorderedEvent:
+		series.postIds = evt.PostIds
+	}
+
+	return nil
+}
+
+func (series *Series) HandleCommand(command Command) (*Events, error) {
+	switch cmd := command.(type) {
+	case *CreateSeriesCommand:
+		return series.create(cmd)
+	case *AddPostToSeriesCommand:
+		return series.addPost(cmd)
+	case *ReorderSeriesCommand:
+		return series.reorder(cmd)
+	}
+
+	return NoEvents, nil
+}
+
+func (series *Series) create(cmd *CreateSeriesCommand) (*Events, error) {
+	verr := ValidationError{}
+	if cmd.Title == "" {
+		verr.Add("Title", ErrEmpty)
+	} else if !series.all.UniqueTitle(cmd.Title) {
+		verr.Add("Title", ErrNotUnique)
+	}
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
+	}
+
+	return ListOfEvents(&SeriesCreatedEvent{
+		SeriesId:    Id(),
+		Title:       cmd.Title,
+		Description: cmd.Description,
+		CreatedAt:   time.Now(),
+	}), nil
+}
+
+func (series *Series) addPost(cmd *AddPostToSeriesCommand) (*Events, error) {
+	verr := ValidationError{}
+	if cmd.SeriesId != series.id {
+		verr.Add("Series", ErrNotFound)
+	}
+	if !series.all.PostExists(cmd.PostId) {
+		verr.Add("Post", ErrNotFound)
+	} else if series.all.SeriesForPost(cmd.PostId) != "" {
+		verr.Add("Post", ErrAlreadyInSeries)
+	}
+	if cmd.Position < 0 || cmd.Position > len(series.postIds)+1 {
+		verr.Add("Position", ErrOutOfRange)
+	}
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
+	}
+
+	position := cmd.Position
+	if position == 0 {
+		position = len(series.postIds) + 1
+	}
+
+	return ListOfEvents(&PostAddedToSeriesEvent{
+		SeriesId: series.id,
+		PostId:   cmd.PostId,
+		Position: position,
+		AddedAt:  time.Now(),
+	}), nil
+}
+
+func (series *Series) reorder(cmd *ReorderSeriesCommand) (*Events, error) {
+	verr := ValidationError{}
+	if cmd.SeriesId != series.id {
+		verr.Add("Series", ErrNotFound)
+	}
+	if !samePosts(series.postIds, cmd.PostIds) {
+		verr.Add("Posts", ErrMismatch)
+	}
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
+	}
+
+	return ListOfEvents(&SeriesReorderedEvent{
+		SeriesId:    series.id,
+		PostIds:     cmd.PostIds,
+		ReorderedAt: time.Now(),
+	}), nil
+}
+
+// insertAt inserts id into ids so that it ends up at the 1-based
+// position.
+func insertAt(ids []string, position int, id string) []string {
+	i := position - 1
+	if i < 0 || i > len(ids) {
+		i = len(ids)
+	}
+
+	result := make([]string, 0, len(ids)+1)
+	result = append(result, ids[:i]...)
+	result = append(result, id)
+	return append(result, ids[i:]...)
+}
+
+// samePosts returns true if a and b contain the same ids exactly once,
+// regardless of order.
+func samePosts(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	count := map[string]int{}
+	for _, id := range a {
+		count[id]++
+	}
+	for _, id := range b {
+		count[id]--
+		if count[id] < 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func seriesUrl(slug string) *url.URL {
+	return &url.URL{Path: "/series/" + slug + ".html"}
+}
+
+type SeriesViewSeries struct {
+	Id          string
+	Title       string
+	Description string
+	Slug        string
+	Url         *url.URL
+
+	Posts []*AllPostsPost
+}
+
+func (series *SeriesViewSeries) RenderHTML() []byte {
+	return renderTemplate("views/series.html", series)
+}
+
+// PostSeriesNav describes where a post is located inside of its
+// series.
+type PostSeriesNav struct {
+	Series   *SeriesViewSeries
+	Position int
+	Previous *AllPostsPost
+	Next     *AllPostsPost
+}
+
+// SeriesView maintains the table of contents of each series and the
+// previous/next links of the posts in a series.
+type SeriesView struct {
+	Collection []*SeriesViewSeries
+
+	allPosts *AllPostsView
+	byId     map[string]*SeriesViewSeries
+	bySlug   map[string]*SeriesViewSeries
+	postIds  map[string][]string
+}
+
+func NewSeriesView(allPosts *AllPostsView) *SeriesView {
+	return &SeriesView{
+		Collection: []*SeriesViewSeries{},
+		allPosts:   allPosts,
+		byId:       map[string]*SeriesViewSeries{},
+		bySlug:     map[string]*SeriesViewSeries{},
+		postIds:    map[string][]string{},
+	}
+}
+
+func (view *SeriesView) HandleEvent(event Event) error {
+	switch evt := event.(type) {
+	case *SeriesCreatedEvent:
+		slug := slugify(evt.Title)
+		series := &SeriesViewSeries{
+			Id:          evt.SeriesId,
+			Title:       evt.Title,
+			Description: evt.Description,
+			Slug:        slug,
+			Url:         seriesUrl(slug),
+			Posts:       []*AllPostsPost{},
+		}
+		view.byId[series.Id] = series
+		view.bySlug[slug] = series
+		view.Collection = append(view.Collection, series)
+	case *PostAddedToSeriesEvent:
+		view.postIds[evt.SeriesId] = insertAt(view.postIds[evt.SeriesId], evt.Position, evt.PostId)
+		view.update(evt.SeriesId)
+	case *SeriesReorderedEvent:
+		view.postIds[evt.SeriesId] = evt.PostIds
+		view.update(evt.SeriesId)
+	}
+
+	return nil
+}
+
+func (view *SeriesView) update(seriesId string) {
+	series := view.byId[seriesId]
+	if series == nil {
+		return
+	}
+
+	series.Posts = []*AllPostsPost{}
+	for _, postId := range view.postIds[seriesId] {
+		if post := view.allPosts.ById(postId); post != nil {
+			series.Posts = append(series.Posts, post)
+		}
+	}
+
+	for i, post := range series.Posts {
+		nav := &PostSeriesNav{
+			Series:   series,
+			Position: i + 1,
+		}
+		if i > 0 {
+			nav.Previous = series.Posts[i-1]
+		}
+		if i < len(series.Posts)-1 {
+			nav.Next = series.Posts[i+1]
+		}
+		post.Series = nav
+	}
+}
+
+func (view *SeriesView) ById(id string) *SeriesViewSeries {
+	return view.byId[id]
+}
+
+func (view *SeriesView) BySlug(slug string) *SeriesViewSeries {
+	return view.bySlug[slug]
+}
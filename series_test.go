@@ -0,0 +1,64 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/dhamidi/blog"
+)
+
+func TestSeries_AddPost_RejectsPostInOtherSeries(t *testing.T) {
+	postId := main.Id()
+	all := &main.AllSeries{}
+	all.HandleEvent(&main.PostPublishedEvent{PostId: postId})
+	all.HandleEvent(&main.PostAddedToSeriesEvent{SeriesId: main.Id(), PostId: postId})
+
+	created := &main.SeriesCreatedEvent{SeriesId: main.Id(), Title: "series"}
+	series := all.New()
+	series.HandleEvent(created)
+
+	_, err := series.HandleCommand(&main.AddPostToSeriesCommand{
+		SeriesId: created.SeriesId,
+		PostId:   postId,
+	})
+
+	if err == nil {
+		t.Fatal("Expected an error.")
+	}
+
+	verr := err.(main.ValidationError)
+	if perr := verr.Get("Post"); perr != main.ErrAlreadyInSeries {
+		t.Fatalf("Expected post to be %s, got %s", main.ErrAlreadyInSeries, perr)
+	}
+}
+
+func TestSeries_Reorder_RequiresAllPosts(t *testing.T) {
+	first, second := main.Id(), main.Id()
+	created := &main.SeriesCreatedEvent{SeriesId: main.Id(), Title: "series"}
+	all := &main.AllSeries{}
+	series := all.New()
+	series.HandleEvent(created)
+	series.HandleEvent(&main.PostAddedToSeriesEvent{SeriesId: created.SeriesId, PostId: first, Position: 1})
+	series.HandleEvent(&main.PostAddedToSeriesEvent{SeriesId: created.SeriesId, PostId: second, Position: 1})
+
+	_, err := series.HandleCommand(&main.ReorderSeriesCommand{
+		SeriesId: created.SeriesId,
+		PostIds:  []string{first},
+	})
+
+	if err == nil {
+		t.Fatal("Expected an error.")
+	}
+
+	events, err := series.HandleCommand(&main.ReorderSeriesCommand{
+		SeriesId: created.SeriesId,
+		PostIds:  []string{first, second},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reordered := events.Items()[0].(*main.SeriesReorderedEvent)
+	if reordered.PostIds[0] != first {
+		t.Fatalf("Expected %s to come first, got %v", first, reordered.PostIds)
+	}
+}
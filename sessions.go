@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// sessionCookie is the name of the cookie holding the session token
+// of a logged in user.
+const sessionCookie = "session"
+
+// preSessionCookie is the name of the cookie identifying a visitor of
+// the admin pages who has not logged in yet, for protecting the login
+// form against CSRF.
+const preSessionCookie = "pre_session"
+
+// sessionDuration is how long a user stays logged in.
+const sessionDuration = 12 * time.Hour
+
+type activeSession struct {
+	login   string
+	expires time.Time
+
+	// adminCredentials is only set for sessions of the administrator
+	// configured through the environment.
+	adminCredentials string
+}
+
+// Sessions keeps track of the users that are logged in.  Sessions of
+// an author end when the author's password is changed or the author is
+// deactivated.
+type Sessions struct {
+	active map[string]*activeSession
+
+	// logins maps the ids of authors to their logins.
+	logins map[string]string
+}
+
+func (sessions *Sessions) New() Aggregate {
+	return &Session{}
+}
+
+func (sessions *Sessions) HandleEvent(event Event) error {
+	if sessions.active == nil {
+		sessions.active = map[string]*activeSession{}
+		sessions.logins = map[string]string{}
+	}
+
+	switch evt := event.(type) {
+	case *SessionStartedEvent:
+		for id, session := range sessions.active {
+			if session.expires.Before(evt.StartedAt) {
+				delete(sessions.active, id)
+			}
+		}
+		sessions.active[evt.SessionId] = &activeSession{
+			login:            evt.Login,
+			expires:          evt.ExpiresAt,
+			adminCredentials: evt.AdminCredentials,
+		}
+	case *SessionEndedEvent:
+		delete(sessions.active, evt.SessionId)
+	case *AuthorRegisteredEvent:
+		sessions.logins[evt.AuthorId] = evt.Login
+	case *AuthorPasswordChangedEvent:
+		sessions.endAuthorSessions(evt.AuthorId)
+	case *AuthorDeactivatedEvent:
+		sessions.endAuthorSessions(evt.AuthorId)
+	}
+
+	return nil
+}
+
+// endAuthorSessions ends all sessions of the author with authorId.
+func (sessions *Sessions) endAuthorSessions(authorId string) {
+	login := sessions.logins[authorId]
+	for id, session := range sessions.active {
+		if session.login == login && session.adminCredentials == "" {
+			delete(sessions.active, id)
+		}
+	}
+}
+
+// Login returns the login of the user of the session with sessionId,
+// or the empty string if the session has ended or expired.
+func (sessions *Sessions) Login(sessionId string, now time.Time) string {
+	session := sessions.active[sessionId]
+	if session == nil || now.After(session.expires) {
+		return ""
+	}
+
+	return session.login
+}
+
+// Admin returns true if the user of the session with sessionId logged in
+// as the administrator configured through the environment.
+func (sessions *Sessions) Admin(sessionId string) bool {
+	session := sessions.active[sessionId]
+	return session != nil && session.adminCredentials != ""
+}
+
+// AdminCredentials returns the signature of the administrator's
+// password at the time the session with sessionId has been started.
+func (sessions *Sessions) AdminCredentials(sessionId string) string {
+	if session := sessions.active[sessionId]; session != nil {
+		return session.adminCredentials
+	}
+
+	return ""
+}
+
+// Session is the time between a user logging in and out.
+type Session struct {
+	id    string
+	ended bool
+}
+
+func (session *Session) HandleEvent(event Event) error {
+	switch evt := event.(type) {
+	case *SessionStartedEvent:
+		session.id = evt.SessionId
+	case *SessionEndedEvent:
+		session.ended = true
+	}
+
+	return nil
+}
+
+func (session *Session) HandleCommand(command Command) (*Events, error) {
+	switch cmd := command.(type) {
+	case *LogInCommand:
+		return session.start(cmd)
+	case *LogOutCommand:
+		return session.end(cmd)
+	}
+
+	return NoEvents, nil
+}
+
+// start begins a session for a user whose credentials have already
+// been checked.
+func (session *Session) start(cmd *LogInCommand) (*Events, error) {
+	now := time.Now()
+	cmd.sessionId = Id()
+
+	return ListOfEvents(&SessionStartedEvent{
+		SessionId:        cmd.sessionId,
+		Login:            cmd.Login,
+		RemoteAddr:       cmd.RemoteAddr,
+		StartedAt:        now,
+		ExpiresAt:        now.Add(sessionDuration),
+		TwoFactor:        cmd.twoFactor,
+		AdminCredentials: cmd.adminCredentials,
+	}), nil
+}
+
+func (session *Session) end(cmd *LogOutCommand) (*Events, error) {
+	if cmd.SessionId != session.id {
+		return NoEvents, ErrNotFound
+	}
+	if session.ended {
+		return NoEvents, nil
+	}
+
+	return ListOfEvents(&SessionEndedEvent{
+		SessionId: session.id,
+		EndedAt:   time.Now(),
+	}), nil
+}
+
+// SessionCookie returns the cookie holding the token for the session
+// with sessionId.
+func (app *Application) SessionCookie(sessionId string) *http.Cookie {
+	return &http.Cookie{
+		Name:     sessionCookie,
+		Value:    app.signer.SessionToken(sessionId),
+		Path:     "/admin",
+		Expires:  time.Now().Add(sessionDuration),
+		Secure:   app.tls.enabled,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// sessionId returns the id of the session req has been made in, or the
+// empty string if there is no valid session cookie.
+func (app *Application) sessionId(req *http.Request) string {
+	cookie, err := req.Cookie(sessionCookie)
+	if err != nil {
+		return ""
+	}
+
+	sessionId, err := app.signer.VerifySessionToken(cookie.Value)
+	if err != nil {
+		return ""
+	}
+
+	return sessionId
+}
+
+// postForm matches the opening tags of forms that are submitted with
+// POST.
+var postForm = regexp.MustCompile(`(?i)<form\b[^>]*\bmethod="post"[^>]*>`)
+
+// injectCSRFToken adds a hidden field holding token to every form in
+// html that is submitted with POST.
+func injectCSRFToken(html []byte, token string) []byte {
+	field := []byte(`<input type="hidden" name="csrf_token" value="` + token + `">`)
+	return postForm.ReplaceAllFunc(html, func(tag []byte) []byte {
+		return append(append([]byte{}, tag...), field...)
+	})
+}
+
+// csrfResponseWriter adds the CSRF token to the forms of the HTML pages
+// written through it.
+type csrfResponseWriter struct {
+	http.ResponseWriter
+	token string
+}
+
+func (w *csrfResponseWriter) Write(data []byte) (int, error) {
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") || !bytes.Contains(data, []byte("<form")) {
+		return w.ResponseWriter.Write(data)
+	}
+
+	if _, err := w.ResponseWriter.Write(injectCSRFToken(data, w.token)); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// preSessionId returns the id identifying a visitor of the admin pages
+// who has not logged in yet, setting a cookie holding a new one unless
+// the request has been made with one already.  The id is prefixed, so
+// that it never matches the id of a session.
+func (app *Application) preSessionId(w http.ResponseWriter, req *http.Request) string {
+	cookie, err := req.Cookie(preSessionCookie)
+	if err != nil || cookie.Value == "" {
+		cookie = &http.Cookie{
+			Name:     preSessionCookie,
+			Value:    Id(),
+			Path:     "/admin",
+			Secure:   app.tls.enabled,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		}
+		http.SetCookie(w, cookie)
+	}
+
+	return "pre-session:" + cookie.Value
+}
+
+// CSRFProtection rejects POST requests to the admin pages that do not
+// include the CSRF token of the session they are made in, and adds that
+// token to every form on the admin pages.  Before logging in, the token
+// belongs to the visitor's pre-session cookie instead, which protects
+// the login form.
+func (app *Application) CSRFProtection(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/admin" && !strings.HasPrefix(req.URL.Path, "/admin/") {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		sessionId := app.sessionId(req)
+		if sessionId == "" {
+			sessionId = app.preSessionId(w, req)
+		}
+
+		if req.Method == "POST" {
+			req.Body = http.MaxBytesReader(w, req.Body, maxMediaSize+1<<20)
+			if !app.signer.VerifyCSRFToken(req.FormValue("csrf_token"), sessionId) {
+				respondWithError(w, ErrForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(&csrfResponseWriter{ResponseWriter: w, token: app.signer.CSRFToken(sessionId)}, req)
+	})
+}
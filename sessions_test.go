@@ -0,0 +1,174 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhamidi/blog"
+)
+
+func TestSessions_Login_EndsWithSession(t *testing.T) {
+	now := time.Now()
+	sessions := &main.Sessions{}
+	sessions.HandleEvent(&main.SessionStartedEvent{
+		SessionId: "session-id",
+		Login:     "jane",
+		StartedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	})
+
+	if login := sessions.Login("session-id", now); login != "jane" {
+		t.Fatalf("Expected login %q, got %q", "jane", login)
+	}
+	if login := sessions.Login("session-id", now.Add(2*time.Hour)); login != "" {
+		t.Fatalf("Expired session still belongs to %q", login)
+	}
+
+	sessions.HandleEvent(&main.SessionEndedEvent{SessionId: "session-id"})
+	if login := sessions.Login("session-id", now); login != "" {
+		t.Fatalf("Ended session still belongs to %q", login)
+	}
+}
+
+func TestSigner_VerifySessionToken(t *testing.T) {
+	signer := main.NewSigner("secret")
+	token := signer.SessionToken("session-id")
+
+	if sessionId, err := signer.VerifySessionToken(token); err != nil || sessionId != "session-id" {
+		t.Fatalf("Expected session-id, got %q (%v)", sessionId, err)
+	}
+	if _, err := signer.VerifySessionToken("other-id" + token[len("session-id"):]); err == nil {
+		t.Fatal("Token for a different session accepted.")
+	}
+	if signer.VerifyCSRFToken(signer.CSRFToken("other-id"), "session-id") {
+		t.Fatal("CSRF token of a different session accepted.")
+	}
+}
+
+func TestApplication_CSRFProtection(t *testing.T) {
+	app, dir := newApplication(t)
+	defer os.RemoveAll(dir)
+
+	registerAuthor(t, app, "jane")
+	login := &main.LogInCommand{Login: "jane", Password: "correct horse"}
+	if _, err := app.HandleCommand(login); err != nil {
+		t.Fatal(err)
+	}
+	cookie := app.SessionCookie(login.SessionId())
+
+	handler := app.CSRFProtection(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<form method="POST" action="/admin/posts"></form>`))
+	}))
+	request := func(method, token string) *httptest.ResponseRecorder {
+		form := url.Values{}
+		if token != "" {
+			form.Set("csrf_token", token)
+		}
+		req := httptest.NewRequest(method, "/admin/posts", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	page := request("GET", "")
+	match := regexp.MustCompile(`<form method="POST" action="/admin/posts"><input type="hidden" name="csrf_token" value="([^"]+)">`).FindStringSubmatch(page.Body.String())
+	if match == nil {
+		t.Fatalf("Expected the form to contain the CSRF token, got %s", page.Body)
+	}
+
+	for _, token := range []string{"", "wrong-token"} {
+		if w := request("POST", token); w.Code != http.StatusForbidden {
+			t.Fatalf("Expected POST with token %q to be forbidden, got %d", token, w.Code)
+		}
+	}
+	if w := request("POST", match[1]); w.Code != http.StatusOK {
+		t.Fatalf("Expected POST with valid token to be allowed, got %d", w.Code)
+	}
+}
+
+func TestApplication_CSRFProtection_ProtectsLogin(t *testing.T) {
+	app, dir := newApplication(t)
+	defer os.RemoveAll(dir)
+
+	handler := app.CSRFProtection(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<form method="POST" action="/admin/login"></form>`))
+	}))
+	request := func(method, token string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		form := url.Values{"login": {"jane"}, "password": {"correct horse"}}
+		if token != "" {
+			form.Set("csrf_token", token)
+		}
+		req := httptest.NewRequest(method, "/admin/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := request("POST", "", nil); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected logging in without a token to be forbidden, got %d", w.Code)
+	}
+
+	page := request("GET", "", nil)
+	cookies := page.Result().Cookies()
+	match := regexp.MustCompile(`name="csrf_token" value="([^"]+)"`).FindStringSubmatch(page.Body.String())
+	if len(cookies) == 0 || match == nil {
+		t.Fatalf("Expected the login form to get a token for a new cookie, got %s", page.Body)
+	}
+
+	if w := request("POST", match[1], nil); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected the token to be bound to the cookie, got %d", w.Code)
+	}
+	if w := request("POST", match[1], cookies); w.Code != http.StatusOK {
+		t.Fatalf("Expected logging in with a valid token to be allowed, got %d", w.Code)
+	}
+}
+
+func TestSessions_Admin(t *testing.T) {
+	sessions := &main.Sessions{}
+	now := time.Now()
+	sessions.HandleEvent(&main.SessionStartedEvent{SessionId: "author", Login: "admin", StartedAt: now, ExpiresAt: now.Add(time.Hour)})
+	sessions.HandleEvent(&main.SessionStartedEvent{SessionId: "admin", Login: "admin", StartedAt: now, ExpiresAt: now.Add(time.Hour), AdminCredentials: "signature"})
+
+	if sessions.Admin("author") {
+		t.Fatal("Expected an author's session not to be the administrator's.")
+	}
+	if !sessions.Admin("admin") {
+		t.Fatal("Expected the administrator's session to be recognized.")
+	}
+}
+
+func TestSessions_EndWhenPasswordChangesOrAuthorIsDeactivated(t *testing.T) {
+	now := time.Now()
+	for _, event := range []main.Event{
+		&main.AuthorPasswordChangedEvent{AuthorId: "author-id", ChangedAt: now},
+		&main.AuthorDeactivatedEvent{AuthorId: "author-id", DeactivatedAt: now},
+	} {
+		sessions := &main.Sessions{}
+		sessions.HandleEvent(&main.AuthorRegisteredEvent{AuthorId: "author-id", Login: "jane"})
+		sessions.HandleEvent(&main.AuthorRegisteredEvent{AuthorId: "other-id", Login: "john"})
+		sessions.HandleEvent(&main.SessionStartedEvent{SessionId: "jane", Login: "jane", StartedAt: now, ExpiresAt: now.Add(time.Hour)})
+		sessions.HandleEvent(&main.SessionStartedEvent{SessionId: "john", Login: "john", StartedAt: now, ExpiresAt: now.Add(time.Hour)})
+		sessions.HandleEvent(event)
+
+		if login := sessions.Login("jane", now); login != "" {
+			t.Fatalf("Expected session to end after %T, still belongs to %q", event, login)
+		}
+		if login := sessions.Login("john", now); login != "john" {
+			t.Fatalf("Expected other sessions to continue after %T", event)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signer creates and checks signatures for data handed out to
+// clients, e.g. as part of links sent by mail.
+type Signer struct {
+	key []byte
+}
+
+// exampleSecret is the secret given as an example in CONFIG, which is
+// publicly known and therefore refused.
+const exampleSecret = "change-me"
+
+// NewSigner returns a signer using secret as the key.  If secret is
+// empty, a random key is used, which means that signatures become
+// invalid when the application is restarted.
+func NewSigner(secret string) *Signer {
+	key := []byte(secret)
+	if secret == "" {
+		log.Printf("NewSigner: no secret configured, using a random one\n")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(err)
+		}
+	}
+
+	return &Signer{key: key}
+}
+
+// Sign returns a URL safe signature of parts.  The first part should
+// describe the purpose of the signature, so that signatures cannot be
+// reused for a different purpose.
+func (signer *Signer) Sign(parts ...string) string {
+	mac := hmac.New(sha256.New, signer.key)
+	mac.Write([]byte(strings.Join(parts, "\x00")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns true if signature is a valid signature of parts.
+func (signer *Signer) Verify(signature string, parts ...string) bool {
+	expected, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, signer.key)
+	mac.Write([]byte(strings.Join(parts, "\x00")))
+	return hmac.Equal(expected, mac.Sum(nil))
+}
+
+// SignCommentManagement returns the signature that allows the author of
+// a comment to edit or withdraw it.
+func (signer *Signer) SignCommentManagement(commentId string) string {
+	return signer.Sign("manage-comment", commentId)
+}
+
+func (signer *Signer) VerifyCommentManagement(signature, commentId string) bool {
+	return signer.Verify(signature, "manage-comment", commentId)
+}
+
+// CommentAuthenticationToken returns a token that authenticates
+// commentId until expires.
+func (signer *Signer) CommentAuthenticationToken(commentId string, expires time.Time) string {
+	expiresAt := strconv.FormatInt(expires.Unix(), 36)
+	return commentId + "." + expiresAt + "." + signer.Sign("authenticate-comment", commentId, expiresAt)
+}
+
+// VerifyCommentAuthenticationToken checks token and returns the id of
+// the comment it authenticates.
+func (signer *Signer) VerifyCommentAuthenticationToken(token string) (string, error) {
+	fields := strings.Split(token, ".")
+	if len(fields) != 3 {
+		return "", ErrInvalidSignature
+	}
+
+	commentId, expiresAt, signature := fields[0], fields[1], fields[2]
+	if !signer.Verify(signature, "authenticate-comment", commentId, expiresAt) {
+		return "", ErrInvalidSignature
+	}
+
+	expires, err := strconv.ParseInt(expiresAt, 36, 64)
+	if err != nil {
+		return "", ErrInvalidSignature
+	}
+	if time.Now().After(time.Unix(expires, 0)) {
+		return "", ErrExpired
+	}
+
+	return commentId, nil
+}
+
+// SignResendAuthentication returns the signature that allows asking for
+// another authentication mail for commentId.
+func (signer *Signer) SignResendAuthentication(commentId string) string {
+	return signer.Sign("resend-authentication", commentId)
+}
+
+func (signer *Signer) VerifyResendAuthentication(signature, commentId string) bool {
+	return signer.Verify(signature, "resend-authentication", commentId)
+}
+
+// CommentFormToken returns a token recording that the comment form was
+// rendered at renderedAt.
+func (signer *Signer) CommentFormToken(renderedAt time.Time) string {
+	timestamp := strconv.FormatInt(renderedAt.Unix(), 36)
+	return timestamp + "." + signer.Sign("comment-form", timestamp)
+}
+
+// VerifyCommentFormToken checks token and returns the time the comment
+// form was rendered.
+func (signer *Signer) VerifyCommentFormToken(token string) (time.Time, error) {
+	fields := strings.Split(token, ".")
+	if len(fields) != 2 || !signer.Verify(fields[1], "comment-form", fields[0]) {
+		return time.Time{}, ErrInvalidSignature
+	}
+
+	renderedAt, err := strconv.ParseInt(fields[0], 36, 64)
+	if err != nil {
+		return time.Time{}, ErrInvalidSignature
+	}
+
+	return time.Unix(renderedAt, 0), nil
+}
+
+// SessionToken returns the value of the session cookie for sessionId.
+func (signer *Signer) SessionToken(sessionId string) string {
+	return sessionId + "." + signer.Sign("session", sessionId)
+}
+
+// VerifySessionToken checks token and returns the id of the session it
+// belongs to.
+func (signer *Signer) VerifySessionToken(token string) (string, error) {
+	fields := strings.Split(token, ".")
+	if len(fields) != 2 || !signer.Verify(fields[1], "session", fields[0]) {
+		return "", ErrInvalidSignature
+	}
+
+	return fields[0], nil
+}
+
+// CSRFToken returns the token that forms submitted during the session
+// with sessionId need to include.
+func (signer *Signer) CSRFToken(sessionId string) string {
+	return signer.Sign("csrf", sessionId)
+}
+
+func (signer *Signer) VerifyCSRFToken(token, sessionId string) bool {
+	return signer.Verify(token, "csrf", sessionId)
+}
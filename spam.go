@@ -0,0 +1,312 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// RateLimiter allows at most limit actions per key during period.
+type RateLimiter struct {
+	limit  int
+	period time.Duration
+
+	lock sync.Mutex
+	hits map[string][]time.Time
+}
+
+func NewRateLimiter(limit int, period time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:  limit,
+		period: period,
+		hits:   map[string][]time.Time{},
+	}
+}
+
+// Allow records an action for key at now and returns false if the
+// limit for key has been exceeded.
+func (limiter *RateLimiter) Allow(key string, now time.Time) bool {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	if len(limiter.hits) > 10000 {
+		for k := range limiter.hits {
+			limiter.prune(k, now)
+		}
+	}
+
+	limiter.prune(key, now)
+	if len(limiter.hits[key]) >= limiter.limit {
+		return false
+	}
+
+	limiter.hits[key] = append(limiter.hits[key], now)
+	return true
+}
+
+func (limiter *RateLimiter) prune(key string, now time.Time) {
+	hits := limiter.hits[key]
+	i := 0
+	for i < len(hits) && now.Sub(hits[i]) >= limiter.period {
+		i++
+	}
+
+	if i == len(hits) {
+		delete(limiter.hits, key)
+	} else {
+		limiter.hits[key] = hits[i:]
+	}
+}
+
+// CommentClassifier decides whether a comment is spam.
+type CommentClassifier interface {
+	// SpamProbability returns a value between 0 and 1, where 1
+	// means the comment is certainly spam.
+	SpamProbability(author, email, content string) float64
+}
+
+// BayesianFilter is a naive Bayes classifier that learns from the
+// admin's moderation decisions: rejected comments are considered spam,
+// approved ones are not.  Comments published without moderation are
+// not learned from, since commenters can authenticate their own spam.
+type BayesianFilter struct {
+	// minTraining is the number of spam and ham comments each
+	// that need to be seen before the filter classifies anything.
+	minTraining int
+
+	lock sync.RWMutex
+
+	// comments holds the comments that have not been used for
+	// training yet.
+	comments   map[string]*PostCommentedEvent
+	spamTokens map[string]int
+	hamTokens  map[string]int
+	spamCount  int
+	hamCount   int
+}
+
+func NewBayesianFilter(minTraining int) *BayesianFilter {
+	return &BayesianFilter{
+		minTraining: minTraining,
+		comments:    map[string]*PostCommentedEvent{},
+		spamTokens:  map[string]int{},
+		hamTokens:   map[string]int{},
+	}
+}
+
+func (filter *BayesianFilter) HandleEvent(event Event) error {
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+
+	switch evt := event.(type) {
+	case *PostCommentedEvent:
+		if !evt.ByAuthor {
+			commented := *evt
+			filter.comments[evt.CommentId] = &commented
+		}
+	case *CommentEditedEvent:
+		if commented := filter.comments[evt.CommentId]; commented != nil {
+			commented.Content = evt.Content
+		}
+	case *PostCommentApprovedEvent:
+		filter.train(evt.CommentId, false)
+	case *PostCommentRejectedEvent:
+		filter.train(evt.CommentId, true)
+	case *PostCommentDeletedEvent:
+		delete(filter.comments, evt.CommentId)
+	case *CommentWithdrawnEvent:
+		delete(filter.comments, evt.CommentId)
+	case *CommentExpiredEvent:
+		delete(filter.comments, evt.CommentId)
+	}
+
+	return nil
+}
+
+func (filter *BayesianFilter) train(commentId string, spam bool) {
+	commented := filter.comments[commentId]
+	if commented == nil {
+		return
+	}
+	delete(filter.comments, commentId)
+
+	counts := filter.hamTokens
+	if spam {
+		counts = filter.spamTokens
+		filter.spamCount++
+	} else {
+		filter.hamCount++
+	}
+
+	for token := range spamTokens(spamText(commented.AuthorName, commented.AuthorEmail, commented.Content)) {
+		counts[token]++
+	}
+}
+
+func (filter *BayesianFilter) SpamProbability(author, email, content string) float64 {
+	filter.lock.RLock()
+	defer filter.lock.RUnlock()
+
+	if filter.spamCount < filter.minTraining || filter.hamCount < filter.minTraining {
+		return 0.5
+	}
+
+	probabilities := []float64{}
+	for token := range spamTokens(spamText(author, email, content)) {
+		spam, ham := filter.spamTokens[token], filter.hamTokens[token]
+		if spam+ham == 0 {
+			continue
+		}
+
+		s := float64(spam) / float64(filter.spamCount)
+		h := float64(ham) / float64(filter.hamCount)
+		p := math.Max(0.01, math.Min(0.99, s/(s+h)))
+		probabilities = append(probabilities, p)
+	}
+
+	if len(probabilities) == 0 {
+		return 0.5
+	}
+
+	// Only the most interesting tokens, i.e. the ones furthest
+	// away from 0.5, are taken into account.
+	sort.Slice(probabilities, func(i, j int) bool {
+		return math.Abs(probabilities[i]-0.5) > math.Abs(probabilities[j]-0.5)
+	})
+	if len(probabilities) > 15 {
+		probabilities = probabilities[:15]
+	}
+
+	logSpam, logHam := 0.0, 0.0
+	for _, p := range probabilities {
+		logSpam += math.Log(p)
+		logHam += math.Log(1 - p)
+	}
+
+	return 1 / (1 + math.Exp(logHam-logSpam))
+}
+
+func spamText(author, email, content string) string {
+	return author + " " + email + " " + content
+}
+
+// spamTokens returns the set of lower case words in text.
+func spamTokens(text string) map[string]bool {
+	tokens := map[string]bool{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '.' && r != '@'
+	}) {
+		word = strings.Trim(word, ".")
+		if len(word) > 2 && len(word) < 40 {
+			tokens[word] = true
+		}
+	}
+
+	return tokens
+}
+
+// CommentGuard protects the comment form from being abused for sending
+// mail to arbitrary addresses or for posting spam.
+type CommentGuard struct {
+	signer     *Signer
+	blocklist  *Blocklist
+	classifier CommentClassifier
+	byIP       *RateLimiter
+	byEmail    *RateLimiter
+
+	// minFillTime is the minimum time that needs to pass between
+	// rendering the comment form and submitting it.
+	minFillTime time.Duration
+
+	// maxFormAge is the time after which a rendered comment form
+	// is no longer accepted.
+	maxFormAge time.Duration
+
+	// spamThreshold is the spam probability above which comments
+	// are refused.
+	spamThreshold float64
+}
+
+func NewCommentGuard(signer *Signer, blocklist *Blocklist, classifier CommentClassifier) *CommentGuard {
+	return &CommentGuard{
+		signer:        signer,
+		blocklist:     blocklist,
+		classifier:    classifier,
+		byIP:          NewRateLimiter(10, time.Hour),
+		byEmail:       NewRateLimiter(5, time.Hour),
+		minFillTime:   3 * time.Second,
+		maxFormAge:    24 * time.Hour,
+		spamThreshold: 0.9,
+	}
+}
+
+// FormToken returns the token that is embedded in the comment form for
+// recording when it was rendered.
+func (guard *CommentGuard) FormToken() string {
+	return guard.signer.CommentFormToken(time.Now())
+}
+
+// Check returns an error if cmd should not be accepted.
+func (guard *CommentGuard) Check(cmd *CommentOnPostCommand) error {
+	now := time.Now()
+	verr := ValidationError{}
+
+	if cmd.Honeypot != "" {
+		return verr.Add("Form", ErrLooksLikeSpam)
+	}
+
+	renderedAt, err := guard.signer.VerifyCommentFormToken(cmd.FormToken)
+	if err != nil {
+		return verr.Add("Form", err)
+	}
+	if now.Sub(renderedAt) < guard.minFillTime {
+		return verr.Add("Form", ErrTooFast)
+	}
+	if now.Sub(renderedAt) > guard.maxFormAge {
+		return verr.Add("Form", ErrExpired)
+	}
+
+	if guard.blocklist.IsBlocked(cmd.Email) {
+		return verr.Add("Email", ErrBlocked)
+	}
+
+	if !guard.byIP.Allow(cmd.RemoteAddr, now) || !guard.byEmail.Allow(strings.ToLower(cmd.Email), now) {
+		return ErrTooManyRequests
+	}
+
+	return guard.CheckContent(cmd.Author, cmd.Email, cmd.Content)
+}
+
+// CheckContent returns an error if the content of a comment looks like
+// spam.  This is also used when comments are edited.
+func (guard *CommentGuard) CheckContent(author, email, content string) error {
+	if guard.classifier.SpamProbability(author, email, content) > guard.spamThreshold {
+		return ValidationError{}.Add("Content", ErrLooksLikeSpam)
+	}
+
+	return nil
+}
+
+// remoteIP returns the IP address of the client that sent req, taking
+// into account the reverse proxy if one is configured.  Only the last
+// address in X-Forwarded-For can be trusted, since it is the one added
+// by the proxy; earlier ones are sent by the client.
+func remoteIP(req *http.Request, behindProxy bool) string {
+	if behindProxy {
+		if forwarded := req.Header["X-Forwarded-For"]; len(forwarded) > 0 {
+			addresses := strings.Split(forwarded[len(forwarded)-1], ",")
+			return strings.TrimSpace(addresses[len(addresses)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
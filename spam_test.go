@@ -0,0 +1,71 @@
+package main_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dhamidi/blog"
+)
+
+func TestRateLimiter_Allow_LimitsPerKey(t *testing.T) {
+	limiter := main.NewRateLimiter(2, time.Hour)
+	now := time.Now()
+
+	if !limiter.Allow("a", now) || !limiter.Allow("a", now) {
+		t.Fatal("Expected first two actions to be allowed.")
+	}
+	if limiter.Allow("a", now) {
+		t.Fatal("Expected third action to be refused.")
+	}
+	if !limiter.Allow("b", now) {
+		t.Fatal("Expected other key to be allowed.")
+	}
+	if !limiter.Allow("a", now.Add(time.Hour)) {
+		t.Fatal("Expected action to be allowed after the period.")
+	}
+}
+
+func TestBlocklist_IsBlocked_MatchesDomain(t *testing.T) {
+	blocklist := main.NewBlocklist()
+	blocklist.HandleEvent(&main.DomainBlockedEvent{Domain: "spam.example"})
+
+	if !blocklist.IsBlocked("Someone@Spam.Example") {
+		t.Fatal("Expected address in blocked domain to be blocked.")
+	}
+	if blocklist.IsBlocked("someone@example.com") {
+		t.Fatal("Expected other address not to be blocked.")
+	}
+}
+
+func TestBayesianFilter_SpamProbability_LearnsFromModeration(t *testing.T) {
+	filter := main.NewBayesianFilter(1)
+	filter.HandleEvent(&main.PostCommentedEvent{CommentId: "spam", Content: "cheap pills online"})
+	filter.HandleEvent(&main.PostCommentRejectedEvent{CommentId: "spam"})
+	filter.HandleEvent(&main.PostCommentedEvent{CommentId: "ham", Content: "thanks for the article"})
+	filter.HandleEvent(&main.PostCommentApprovedEvent{CommentId: "ham"})
+
+	if p := filter.SpamProbability("", "", "buy cheap pills"); p < 0.9 {
+		t.Fatalf("Expected spam to be recognized, got %f", p)
+	}
+	if p := filter.SpamProbability("", "", "great article"); p > 0.1 {
+		t.Fatalf("Expected legitimate comment to be recognized, got %f", p)
+	}
+}
+
+func TestBayesianFilter_SpamProbability_LearnsOnlyFromModeration(t *testing.T) {
+	filter := main.NewBayesianFilter(1)
+	filter.HandleEvent(&main.PostCommentedEvent{CommentId: "spam", Content: "hello"})
+	filter.HandleEvent(&main.CommentEditedEvent{CommentId: "spam", Content: "cheap pills online"})
+	filter.HandleEvent(&main.PostCommentRejectedEvent{CommentId: "spam"})
+	filter.HandleEvent(&main.PostCommentedEvent{CommentId: "self-authenticated", Content: "cheap pills"})
+	filter.HandleEvent(&main.PostCommentAuthenticatedEvent{CommentId: "self-authenticated"})
+	filter.HandleEvent(&main.PostCommentedEvent{CommentId: "ham", Content: "thanks for the article"})
+	filter.HandleEvent(&main.PostCommentApprovedEvent{CommentId: "ham"})
+
+	if p := filter.SpamProbability("", "", "buy cheap pills"); p < 0.9 {
+		t.Fatalf("Expected edited spam to be recognized, got %f", p)
+	}
+	if p := filter.SpamProbability("", "", "great article"); p > 0.1 {
+		t.Fatalf("Expected approved comment to be learned as legitimate, got %f", p)
+	}
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Languages recognized by the search index.
+const (
+	English = "en"
+	German  = "de"
+)
+
+var englishStopWords = stopWords(`a about above after again against all am an and any are as at be
+because been before being below between both but by can could did do does doing down during
+each few for from further had has have having he her here hers herself him himself his how i if
+in into is it its itself just me more most my myself no nor not now of off on once only or other
+our ours ourselves out over own same she should so some such than that the their theirs them
+themselves then there these they this those through to too under until up very was we were what
+when where which while who whom why will with would you your yours yourself yourselves`)
+
+var germanStopWords = stopWords(`aber alle allem allen aller alles als also am an ander andere
+anderem anderen anderer anderes auch auf aus bei bin bis bist da damit dann das dass dasselbe
+dazu dein deine deinem deinen deiner dem den denn der derer des desselben dich die dies diese
+dieselbe dieselben diesem diesen dieser dieses dir doch dort du durch ein eine einem einen einer
+eines einig einige einigem einigen einiger einiges einmal er es etwas euch euer eure für gegen
+gewesen hab habe haben hat hatte hatten hier hin hinter ich ihm ihn ihnen ihr ihre ihrem ihren
+ihrer ihres im in indem ins ist jede jedem jeden jeder jedes jene jenem jenen jener jenes jetzt
+kann kein keine keinem keinen keiner keines können könnte machen man manche mein meine meinem
+meinen meiner mich mir mit muss musste nach nicht nichts noch nun nur ob oder ohne sehr sein
+seine seinem seinen seiner seit sich sie sind so solche soll sollte sondern sonst um und uns
+unser unsere unter viel vom von vor während war waren warst was weg weil weiter welche welchem
+welchen welcher welches wenn werde werden wie wieder will wir wird wirst wo wollen wollte würde
+würden zu zum zur zwar zwischen über`)
+
+func stopWords(words string) map[string]bool {
+	result := map[string]bool{}
+	for _, word := range strings.Fields(words) {
+		result[word] = true
+	}
+
+	return result
+}
+
+// tokenize splits text into lower case words.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// detectLanguage guesses whether words are English or German by
+// counting their stop words.
+func detectLanguage(words []string) string {
+	english, german := 0, 0
+	for _, word := range words {
+		if englishStopWords[word] {
+			english++
+		}
+		if germanStopWords[word] {
+			german++
+		}
+	}
+
+	if german > english {
+		return German
+	}
+	return English
+}
+
+func isStopWord(word, language string) bool {
+	if language == German {
+		return germanStopWords[word]
+	}
+	return englishStopWords[word]
+}
+
+// Stem reduces a lower case word to its stem in language.
+func Stem(word, language string) string {
+	if language == German {
+		return stemGerman(word)
+	}
+	return stemEnglish(word)
+}
+
+// stemEnglish implements the Porter stemming algorithm.  Words that are
+// not plain ASCII are returned unchanged.
+func stemEnglish(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	for _, r := range word {
+		if r < 'a' || r > 'z' {
+			return word
+		}
+	}
+
+	s := &porterWord{b: []byte(word)}
+	s.step1ab()
+	s.step1c()
+	s.step2()
+	s.step3()
+	s.step4()
+	s.step5()
+
+	return string(s.b)
+}
+
+// porterWord holds a word while it is being stemmed.  j marks the end
+// of the stem once a suffix has been matched by ends.
+type porterWord struct {
+	b []byte
+	j int
+}
+
+func (w *porterWord) consonant(i int) bool {
+	switch w.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !w.consonant(i-1)
+	}
+	return true
+}
+
+// measure returns the number of vowel-consonant sequences in the stem.
+func (w *porterWord) measure() int {
+	n, i := 0, 0
+	for ; i < w.j && w.consonant(i); i++ {
+	}
+	for i < w.j {
+		for ; i < w.j && !w.consonant(i); i++ {
+		}
+		if i >= w.j {
+			break
+		}
+		n++
+		for ; i < w.j && w.consonant(i); i++ {
+		}
+	}
+
+	return n
+}
+
+func (w *porterWord) vowelInStem() bool {
+	for i := 0; i < w.j; i++ {
+		if !w.consonant(i) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *porterWord) doubleConsonant(i int) bool {
+	return i >= 1 && w.b[i] == w.b[i-1] && w.consonant(i)
+}
+
+// cvc is true if the letters ending at i are consonant, vowel,
+// consonant and the last one is not w, x or y.
+func (w *porterWord) cvc(i int) bool {
+	if i < 2 || !w.consonant(i) || w.consonant(i-1) || !w.consonant(i-2) {
+		return false
+	}
+	switch w.b[i] {
+	case 'w', 'x', 'y':
+		return false
+	}
+	return true
+}
+
+func (w *porterWord) ends(suffix string) bool {
+	if !strings.HasSuffix(string(w.b), suffix) {
+		return false
+	}
+	w.j = len(w.b) - len(suffix)
+	return true
+}
+
+func (w *porterWord) setTo(suffix string) {
+	w.b = append(w.b[:w.j], suffix...)
+}
+
+func (w *porterWord) replace(suffix string) {
+	if w.measure() > 0 {
+		w.setTo(suffix)
+	}
+}
+
+func (w *porterWord) step1ab() {
+	if w.b[len(w.b)-1] == 's' {
+		switch {
+		case w.ends("sses"), w.ends("ies"):
+			w.b = w.b[:len(w.b)-2]
+		case len(w.b) > 1 && w.b[len(w.b)-2] != 's':
+			w.b = w.b[:len(w.b)-1]
+		}
+	}
+
+	if w.ends("eed") {
+		if w.measure() > 0 {
+			w.b = w.b[:len(w.b)-1]
+		}
+	} else if (w.ends("ed") || w.ends("ing")) && w.vowelInStem() {
+		w.b = w.b[:w.j]
+		w.j = len(w.b)
+		switch {
+		case w.ends("at"):
+			w.setTo("ate")
+		case w.ends("bl"):
+			w.setTo("ble")
+		case w.ends("iz"):
+			w.setTo("ize")
+		case w.doubleConsonant(len(w.b) - 1):
+			switch w.b[len(w.b)-1] {
+			case 'l', 's', 'z':
+			default:
+				w.b = w.b[:len(w.b)-1]
+			}
+		default:
+			w.j = len(w.b)
+			if w.measure() == 1 && w.cvc(len(w.b)-1) {
+				w.b = append(w.b, 'e')
+			}
+		}
+	}
+}
+
+func (w *porterWord) step1c() {
+	if w.ends("y") && w.vowelInStem() {
+		w.b[len(w.b)-1] = 'i'
+	}
+}
+
+var porterStep2 = [][2]string{
+	{"ational", "ate"}, {"tional", "tion"}, {"enci", "ence"}, {"anci", "ance"},
+	{"izer", "ize"}, {"bli", "ble"}, {"alli", "al"}, {"entli", "ent"}, {"eli", "e"},
+	{"ousli", "ous"}, {"ization", "ize"}, {"ation", "ate"}, {"ator", "ate"},
+	{"alism", "al"}, {"iveness", "ive"}, {"fulness", "ful"}, {"ousness", "ous"},
+	{"aliti", "al"}, {"iviti", "ive"}, {"biliti", "ble"}, {"logi", "log"},
+}
+
+var porterStep3 = [][2]string{
+	{"icate", "ic"}, {"ative", ""}, {"alize", "al"}, {"iciti", "ic"},
+	{"ical", "ic"}, {"ful", ""}, {"ness", ""},
+}
+
+var porterStep4 = []string{
+	"al", "ance", "ence", "er", "ic", "able", "ible", "ant", "ement", "ment",
+	"ent", "ion", "ou", "ism", "ate", "iti", "ous", "ive", "ize",
+}
+
+// replaceLongest replaces the longest suffix of rules that the word
+// ends with.
+func (w *porterWord) replaceLongest(rules [][2]string) {
+	longest := -1
+	for i, rule := range rules {
+		if strings.HasSuffix(string(w.b), rule[0]) && (longest < 0 || len(rule[0]) > len(rules[longest][0])) {
+			longest = i
+		}
+	}
+	if longest >= 0 && w.ends(rules[longest][0]) {
+		w.replace(rules[longest][1])
+	}
+}
+
+func (w *porterWord) step2() { w.replaceLongest(porterStep2) }
+func (w *porterWord) step3() { w.replaceLongest(porterStep3) }
+
+func (w *porterWord) step4() {
+	longest := ""
+	for _, suffix := range porterStep4 {
+		if strings.HasSuffix(string(w.b), suffix) && len(suffix) > len(longest) {
+			longest = suffix
+		}
+	}
+	if longest == "" || !w.ends(longest) {
+		return
+	}
+	if longest == "ion" && (w.j == 0 || (w.b[w.j-1] != 's' && w.b[w.j-1] != 't')) {
+		return
+	}
+	if w.measure() > 1 {
+		w.b = w.b[:w.j]
+	}
+}
+
+func (w *porterWord) step5() {
+	w.j = len(w.b)
+	if w.b[len(w.b)-1] == 'e' {
+		w.j = len(w.b) - 1
+		if m := w.measure(); m > 1 || m == 1 && !w.cvc(len(w.b)-2) {
+			w.b = w.b[:len(w.b)-1]
+		}
+	}
+
+	w.j = len(w.b)
+	if w.b[len(w.b)-1] == 'l' && w.doubleConsonant(len(w.b)-1) && w.measure() > 1 {
+		w.b = w.b[:len(w.b)-1]
+	}
+}
+
+func germanVowel(r rune) bool {
+	switch r {
+	case 'a', 'e', 'i', 'o', 'u', 'y', 'ä', 'ö', 'ü':
+		return true
+	}
+	return false
+}
+
+// germanRegion returns the start of the region after the first
+// non-vowel following a vowel at or after from.
+func germanRegion(word []rune, from int) int {
+	for i := from + 1; i < len(word); i++ {
+		if !germanVowel(word[i]) && germanVowel(word[i-1]) {
+			return i + 1
+		}
+	}
+	return len(word)
+}
+
+func hasRuneSuffix(word []rune, suffix string) bool {
+	return strings.HasSuffix(string(word), suffix)
+}
+
+// stemGerman implements the German stemmer of the Snowball project.
+func stemGerman(word string) string {
+	w := []rune(strings.Replace(word, "ß", "ss", -1))
+
+	// u and y between vowels are treated as consonants.
+	for i := 1; i < len(w)-1; i++ {
+		if germanVowel(w[i-1]) && germanVowel(w[i+1]) {
+			switch w[i] {
+			case 'u':
+				w[i] = 'U'
+			case 'y':
+				w[i] = 'Y'
+			}
+		}
+	}
+
+	r1 := germanRegion(w, 0)
+	r2 := germanRegion(w, r1)
+	if r1 < 3 {
+		r1 = 3
+	}
+	inR1 := func(suffix string) bool { return len(w)-len([]rune(suffix)) >= r1 }
+	inR2 := func(suffix string) bool { return len(w)-len([]rune(suffix)) >= r2 }
+	cut := func(suffix string) { w = w[:len(w)-len([]rune(suffix))] }
+
+	// Step 1
+	switch {
+	case hasRuneSuffix(w, "ern"), hasRuneSuffix(w, "em"), hasRuneSuffix(w, "er"):
+		suffix := "er"
+		if hasRuneSuffix(w, "ern") {
+			suffix = "ern"
+		} else if hasRuneSuffix(w, "em") {
+			suffix = "em"
+		}
+		if inR1(suffix) {
+			cut(suffix)
+		}
+	case hasRuneSuffix(w, "en"), hasRuneSuffix(w, "es"), hasRuneSuffix(w, "e"):
+		suffix := "e"
+		if !hasRuneSuffix(w, "e") {
+			suffix = string(w[len(w)-2:])
+		}
+		if inR1(suffix) {
+			cut(suffix)
+			if hasRuneSuffix(w, "niss") {
+				cut("s")
+			}
+		}
+	case hasRuneSuffix(w, "s") && len(w) >= 2 && strings.ContainsRune("bdfghklmnrt", w[len(w)-2]):
+		if inR1("s") {
+			cut("s")
+		}
+	}
+
+	// Step 2
+	switch {
+	case hasRuneSuffix(w, "est"), hasRuneSuffix(w, "en"), hasRuneSuffix(w, "er"):
+		suffix := string(w[len(w)-2:])
+		if hasRuneSuffix(w, "est") {
+			suffix = "est"
+		}
+		if inR1(suffix) {
+			cut(suffix)
+		}
+	case hasRuneSuffix(w, "st") && len(w) >= 6 && strings.ContainsRune("bdfghklmnt", w[len(w)-3]):
+		if inR1("st") {
+			cut("st")
+		}
+	}
+
+	// Step 3
+	switch {
+	case hasRuneSuffix(w, "end"), hasRuneSuffix(w, "ung"):
+		if inR2("end") {
+			cut("end")
+			if hasRuneSuffix(w, "ig") && !hasRuneSuffix(w, "eig") && inR2("ig") {
+				cut("ig")
+			}
+		}
+	case hasRuneSuffix(w, "isch"), hasRuneSuffix(w, "ig"), hasRuneSuffix(w, "ik"):
+		suffix := string(w[len(w)-2:])
+		if hasRuneSuffix(w, "isch") {
+			suffix = "isch"
+		}
+		if inR2(suffix) && !hasRuneSuffix(w[:len(w)-len([]rune(suffix))], "e") {
+			cut(suffix)
+		}
+	case hasRuneSuffix(w, "lich"), hasRuneSuffix(w, "heit"):
+		if inR2("lich") {
+			cut("lich")
+			if (hasRuneSuffix(w, "er") || hasRuneSuffix(w, "en")) && inR1("er") {
+				cut("er")
+			}
+		}
+	case hasRuneSuffix(w, "keit"):
+		if inR2("keit") {
+			cut("keit")
+			if hasRuneSuffix(w, "lich") && inR2("lich") {
+				cut("lich")
+			} else if hasRuneSuffix(w, "ig") && inR2("ig") {
+				cut("ig")
+			}
+		}
+	}
+
+	return strings.NewReplacer("U", "u", "Y", "y", "ä", "a", "ö", "o", "ü", "u").Replace(string(w))
+}
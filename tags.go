@@ -0,0 +1,203 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var tagCloudUrl = &url.URL{Path: "/tags.html"}
+
+func tagUrl(tag string) *url.URL {
+	return &url.URL{Path: "/tags/" + tag + ".html"}
+}
+
+// normalizeTag turns tag into a form that can be used as part of a
+// URL: lower case, with whitespace replaced by dashes and anything
+// that is not a letter, a digit, a dash or an underscore removed.
+func normalizeTag(tag string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r), r == '-', r == '_':
+			return r
+		case unicode.IsSpace(r):
+			return '-'
+		}
+		return -1
+	}, strings.ToLower(strings.TrimSpace(tag)))
+}
+
+func normalizeTags(tags []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+
+	sort.Strings(result)
+	return result
+}
+
+// parseTags splits a comma separated list of tags as entered in a
+// form field.
+func parseTags(str string) []string {
+	return normalizeTags(strings.Split(str, ","))
+}
+
+type TagsTag struct {
+	Name   string
+	Url    *url.URL
+	Posts  []*AllPostsPost
+	Weight int
+}
+
+func (tag *TagsTag) Len() int { return len(tag.Posts) }
+func (tag *TagsTag) Swap(i, j int) {
+	tag.Posts[i], tag.Posts[j] = tag.Posts[j], tag.Posts[i]
+}
+func (tag *TagsTag) Less(i, j int) bool {
+	return tag.Posts[j].publishedAt.Before(tag.Posts[i].publishedAt)
+}
+
+func (tag *TagsTag) addPost(post *AllPostsPost) {
+	for _, existing := range tag.Posts {
+		if existing == post {
+			return
+		}
+	}
+
+	tag.Posts = append(tag.Posts, post)
+	sort.Sort(tag)
+}
+
+func (tag *TagsTag) removePost(post *AllPostsPost) {
+	for i, existing := range tag.Posts {
+		if existing == post {
+			tag.Posts = append(tag.Posts[:i], tag.Posts[i+1:]...)
+			return
+		}
+	}
+}
+
+func (tag *TagsTag) RenderHTML(page *Pagination) []byte {
+	return renderTemplate("views/tag.html", &struct {
+		*TagsTag
+		Page *Pagination
+	}{tag, page})
+}
+
+// TagsView groups posts by their tags and provides the data for the
+// tag cloud.
+type TagsView struct {
+	Tags []*TagsTag
+
+	allPosts *AllPostsView
+	byName   map[string]*TagsTag
+}
+
+func NewTagsView(allPosts *AllPostsView) *TagsView {
+	return &TagsView{
+		Tags:     []*TagsTag{},
+		allPosts: allPosts,
+		byName:   map[string]*TagsTag{},
+	}
+}
+
+func (view *TagsView) Len() int           { return len(view.Tags) }
+func (view *TagsView) Swap(i, j int)      { view.Tags[i], view.Tags[j] = view.Tags[j], view.Tags[i] }
+func (view *TagsView) Less(i, j int) bool { return view.Tags[i].Name < view.Tags[j].Name }
+
+func (view *TagsView) HandleEvent(event Event) error {
+	switch evt := event.(type) {
+	case *PostPublishedEvent:
+		for _, tag := range evt.Tags {
+			view.tagPost(evt.PostId, tag)
+		}
+	case *PostTaggedEvent:
+		view.tagPost(evt.PostId, evt.TagName)
+	case *PostUntaggedEvent:
+		view.untagPost(evt.PostId, evt.TagName)
+	}
+
+	return nil
+}
+
+func (view *TagsView) tagPost(postId, name string) {
+	post := view.allPosts.ById(postId)
+	if post == nil {
+		return
+	}
+
+	tag := view.byName[name]
+	if tag == nil {
+		tag = &TagsTag{
+			Name:  name,
+			Url:   tagUrl(name),
+			Posts: []*AllPostsPost{},
+		}
+		view.byName[name] = tag
+		view.Tags = append(view.Tags, tag)
+		sort.Sort(view)
+	}
+
+	tag.addPost(post)
+	view.weigh()
+}
+
+func (view *TagsView) untagPost(postId, name string) {
+	post := view.allPosts.ById(postId)
+	tag := view.byName[name]
+	if post == nil || tag == nil {
+		return
+	}
+
+	tag.removePost(post)
+	if len(tag.Posts) == 0 {
+		delete(view.byName, name)
+		for i, existing := range view.Tags {
+			if existing == tag {
+				view.Tags = append(view.Tags[:i], view.Tags[i+1:]...)
+				break
+			}
+		}
+	}
+
+	view.weigh()
+}
+
+// weigh assigns each tag a weight from 1 to 5 depending on how many
+// posts are tagged with it, relative to the other tags.
+func (view *TagsView) weigh() {
+	min, max := 0, 0
+	for i, tag := range view.Tags {
+		n := len(tag.Posts)
+		if i == 0 || n < min {
+			min = n
+		}
+		if n > max {
+			max = n
+		}
+	}
+
+	for _, tag := range view.Tags {
+		if max == min {
+			tag.Weight = 3
+		} else {
+			tag.Weight = 1 + 4*(len(tag.Posts)-min)/(max-min)
+		}
+	}
+}
+
+func (view *TagsView) ByName(name string) *TagsTag {
+	return view.byName[name]
+}
+
+func (view *TagsView) RenderHTML() []byte {
+	return renderTemplate("views/tags.html", view)
+}
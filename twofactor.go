@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// The parameters of the time-based one-time passwords, as described in
+// RFC 6238.  These are the defaults understood by all authenticator
+// apps.
+const (
+	totpPeriod = 30
+	totpDigits = 6
+
+	// totpSkew is the number of periods a code is accepted before
+	// and after the current one, allowing for clocks that are off.
+	totpSkew = 1
+)
+
+// recoveryCodeCount is the number of recovery codes handed out when
+// enabling two-factor authentication.
+const recoveryCodeCount = 10
+
+// After twoFactorMaxFailures invalid codes within twoFactorLockout, no
+// further codes are accepted until the lockout has passed.
+const (
+	twoFactorMaxFailures = 5
+	twoFactorLockout     = 15 * time.Minute
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret returns a random secret for a new authenticator, encoded
+// in base32.
+func NewTOTPSecret() string {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+
+	return totpEncoding.EncodeToString(secret)
+}
+
+// TOTPCode returns the code for the base32 encoded secret at the given
+// time.
+func TOTPCode(secret string, at time.Time) string {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return ""
+	}
+
+	return totpCodeForStep(key, at.Unix()/totpPeriod)
+}
+
+func totpCodeForStep(key []byte, step int64) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// verifyTOTP returns the time step code is valid for, if it is valid
+// for a step after lastStep at the given time.  Rejecting earlier steps
+// prevents a code from being used twice.
+func verifyTOTP(secret, code string, at time.Time, lastStep int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := at.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step > lastStep && hmac.Equal([]byte(totpCodeForStep(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// totpURI returns the otpauth URI for adding secret to an authenticator
+// app.
+func totpURI(secret, login string) string {
+	issuer := os.Getenv("BLOG_TLS_HOST")
+	if issuer == "" {
+		issuer = os.Getenv("BLOG_HOST")
+	}
+	if host, _, err := net.SplitHostPort(issuer); err == nil {
+		issuer = host
+	}
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+
+	uri := &url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + login,
+		RawQuery: query.Encode(),
+	}
+	return uri.String()
+}
+
+// newRecoveryCodes returns random codes that can be used once instead
+// of a code from the authenticator app.
+func newRecoveryCodes() []string {
+	codes := []string{}
+	for i := 0; i < recoveryCodeCount; i++ {
+		random := make([]byte, 7)
+		if _, err := rand.Read(random); err != nil {
+			panic(err)
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(random))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+
+	return codes
+}
+
+// hashRecoveryCode returns the hash under which a recovery code is
+// stored.  Recovery codes are random, so they do not need a slow hash.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), " ", "", -1))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func (author *Author) enableTwoFactor(cmd *EnableTwoFactorCommand) (*Events, error) {
+	verr := ValidationError{}
+	if cmd.AuthorId != author.id || author.deactivated {
+		verr.Add("Author", ErrNotFound)
+	}
+	if author.twoFactorSecret != "" {
+		verr.Add("TwoFactor", ErrAlreadyEnabled)
+	}
+	if key, err := totpEncoding.DecodeString(cmd.Secret); err != nil || len(key) < 10 {
+		verr.Add("Secret", ErrInvalidSignature)
+	}
+	step, ok := verifyTOTP(cmd.Secret, cmd.Code, time.Now(), 0)
+	if !ok {
+		verr.Add("Code", ErrInvalidCode)
+	}
+
+	if err := verr.Return(); err != nil {
+		return NoEvents, err
+	}
+
+	cmd.recoveryCodes = newRecoveryCodes()
+	hashes := []string{}
+	for _, code := range cmd.recoveryCodes {
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	return ListOfEvents(&TwoFactorEnabledEvent{
+		AuthorId:      author.id,
+		Secret:        cmd.Secret,
+		Step:          step,
+		RecoveryCodes: hashes,
+		EnabledAt:     time.Now(),
+	}), nil
+}
+
+// verifyTwoFactor checks the code given when logging in, which is
+// either a code from the authenticator app or a recovery code.  An
+// invalid code is recorded in a TwoFactorFailedEvent, which is returned
+// together with ErrInvalidCode.
+func (author *Author) verifyTwoFactor(cmd *VerifyTwoFactorCommand) (*Events, error) {
+	if cmd.AuthorId != author.id || author.twoFactorSecret == "" {
+		return NoEvents, ErrNotFound
+	}
+
+	now := time.Now()
+	if author.lockedOut(now) {
+		return NoEvents, ErrTooManyRequests
+	}
+
+	used := &TwoFactorUsedEvent{
+		AuthorId:   author.id,
+		RemoteAddr: cmd.RemoteAddr,
+		UsedAt:     now,
+	}
+
+	if step, ok := verifyTOTP(author.twoFactorSecret, strings.TrimSpace(cmd.Code), now, author.lastTOTPStep); ok {
+		used.Step = step
+	} else if hash := hashRecoveryCode(cmd.Code); author.recoveryCodes[hash] {
+		used.RecoveryCode = hash
+	} else {
+		return ListOfEvents(&TwoFactorFailedEvent{
+			AuthorId:   author.id,
+			RemoteAddr: cmd.RemoteAddr,
+			FailedAt:   now,
+		}), ErrInvalidCode
+	}
+
+	return ListOfEvents(used), nil
+}
+
+// lockedOut returns true if too many invalid codes have been given
+// recently.
+func (author *Author) lockedOut(now time.Time) bool {
+	failures := author.twoFactorFailures
+	return len(failures) >= twoFactorMaxFailures &&
+		now.Sub(failures[len(failures)-twoFactorMaxFailures]) < twoFactorLockout
+}
+
+func (author *Author) disableTwoFactor(cmd *DisableTwoFactorCommand) (*Events, error) {
+	if cmd.AuthorId != author.id {
+		return NoEvents, ValidationError{}.Add("Author", ErrNotFound)
+	}
+	if author.twoFactorSecret == "" {
+		return NoEvents, nil
+	}
+
+	return ListOfEvents(&TwoFactorDisabledEvent{
+		AuthorId:   author.id,
+		DisabledAt: time.Now(),
+	}), nil
+}
+
+// TwoFactorEnrolment is the page for setting up an authenticator app.
+// Secret is only shown until two-factor authentication is enabled.
+type TwoFactorEnrolment struct {
+	Author *AuthorsAuthor
+	Secret string
+	URI    string
+	Error  error
+
+	// RecoveryCodes is only set right after enabling two-factor
+	// authentication.
+	RecoveryCodes []string
+}
+
+func NewTwoFactorEnrolment(author *AuthorsAuthor) *TwoFactorEnrolment {
+	secret := NewTOTPSecret()
+	return &TwoFactorEnrolment{
+		Author: author,
+		Secret: secret,
+		URI:    totpURI(secret, author.Login),
+	}
+}
+
+func (enrolment *TwoFactorEnrolment) RenderHTML() []byte {
+	return renderTemplate("views/two_factor.html", enrolment)
+}
@@ -0,0 +1,97 @@
+package main_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dhamidi/blog"
+)
+
+func TestTOTPCode_MatchesRFC6238(t *testing.T) {
+	// The SHA1 test vectors of RFC 6238, truncated to six digits.
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for seconds, expected := range vectors {
+		if code := main.TOTPCode(secret, time.Unix(seconds, 0)); code != expected {
+			t.Errorf("At %d: expected %s, got %s", seconds, expected, code)
+		}
+	}
+}
+
+func TestAuthor_VerifyTwoFactor_AcceptsCodesOnlyOnce(t *testing.T) {
+	all := &main.AllAuthors{}
+	registered := &main.AuthorRegisteredEvent{AuthorId: "author-id", Login: "jane"}
+	all.HandleEvent(registered)
+	author := all.New()
+	author.HandleEvent(registered)
+
+	secret := main.NewTOTPSecret()
+	code := main.TOTPCode(secret, time.Now())
+	if _, err := author.HandleCommand(&main.EnableTwoFactorCommand{AuthorId: "author-id", Secret: secret, Code: "000000" + code}); err == nil {
+		t.Fatal("Enabled with an invalid code.")
+	}
+	enable := &main.EnableTwoFactorCommand{AuthorId: "author-id", Secret: secret, Code: code}
+	events, err := author.HandleCommand(enable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enabled := events.Items()[0].(*main.TwoFactorEnabledEvent)
+	author.HandleEvent(enabled)
+	all.HandleEvent(enabled)
+
+	if !all.TwoFactorEnabled("author-id") {
+		t.Fatal("Two-factor authentication not enabled.")
+	}
+	if len(enable.RecoveryCodes()) != len(enabled.RecoveryCodes) || enabled.RecoveryCodes[0] == enable.RecoveryCodes()[0] {
+		t.Fatal("Expected hashed recovery codes to be stored.")
+	}
+
+	if _, err := author.HandleCommand(&main.VerifyTwoFactorCommand{AuthorId: "author-id", Code: code}); err != main.ErrInvalidCode {
+		t.Fatalf("Expected %s when reusing the code used for enabling, got %v", main.ErrInvalidCode, err)
+	}
+
+	recoveryCode := enable.RecoveryCodes()[0]
+	events, err = author.HandleCommand(&main.VerifyTwoFactorCommand{AuthorId: "author-id", Code: recoveryCode})
+	if err != nil {
+		t.Fatalf("Recovery code rejected: %s", err)
+	}
+	author.HandleEvent(events.Items()[0])
+
+	if _, err := author.HandleCommand(&main.VerifyTwoFactorCommand{AuthorId: "author-id", Code: recoveryCode}); err != main.ErrInvalidCode {
+		t.Fatalf("Expected %s when reusing a recovery code, got %v", main.ErrInvalidCode, err)
+	}
+}
+
+func TestAuthor_VerifyTwoFactor_LocksOutAfterFailures(t *testing.T) {
+	all := &main.AllAuthors{}
+	registered := &main.AuthorRegisteredEvent{AuthorId: "author-id", Login: "jane"}
+	all.HandleEvent(registered)
+	author := all.New()
+	author.HandleEvent(registered)
+
+	secret := main.NewTOTPSecret()
+	author.HandleEvent(&main.TwoFactorEnabledEvent{AuthorId: "author-id", Secret: secret, EnabledAt: time.Now()})
+
+	for i := 0; i < 5; i++ {
+		events, err := author.HandleCommand(&main.VerifyTwoFactorCommand{AuthorId: "author-id", Code: "invalid"})
+		if err != main.ErrInvalidCode {
+			t.Fatalf("Expected %s, got %v", main.ErrInvalidCode, err)
+		}
+		failed, ok := events.Items()[0].(*main.TwoFactorFailedEvent)
+		if !ok {
+			t.Fatalf("Expected the failure to be recorded, got %#v", events.Items())
+		}
+		author.HandleEvent(failed)
+	}
+
+	code := main.TOTPCode(secret, time.Now())
+	if _, err := author.HandleCommand(&main.VerifyTwoFactorCommand{AuthorId: "author-id", Code: code}); err != main.ErrTooManyRequests {
+		t.Fatalf("Expected %s after repeated failures, got %v", main.ErrTooManyRequests, err)
+	}
+}
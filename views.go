@@ -31,14 +31,71 @@ func (c RecentChanges) Less(i, j int) bool { return c[i].At.After(c[j].At) }
 
 type AllPostsComment struct {
 	Id          string
+	PostId      string
+	ParentId    string
 	Author      string
 	Content     string
 	ContentHTML template.HTML
 	Created     string
+	ByAuthor    bool
+
+	// Edited is set when the comment has been changed by its
+	// author, Edits holds the previous versions, oldest first.
+	Edited    string
+	Edits     []*CommentEdit
+	Withdrawn bool
+
+	Replies []*AllPostsComment
 
 	createdAt time.Time
 }
 
+type CommentEdit struct {
+	ContentHTML template.HTML
+	Until       string
+}
+
+func (comment *AllPostsComment) edit(content string, editedAt time.Time) {
+	comment.Edits = append(comment.Edits, &CommentEdit{
+		ContentHTML: comment.ContentHTML,
+		Until:       editedAt.Format("02 Jan 2006 15:04"),
+	})
+	comment.Content = content
+	comment.ContentHTML = textToHTML(content, true)
+	comment.Edited = editedAt.Format("02 Jan 2006 15:04")
+}
+
+// withdraw removes the content of the comment, leaving only a
+// placeholder so that replies to it remain in context.
+func (comment *AllPostsComment) withdraw() {
+	comment.Withdrawn = true
+	comment.Content = ""
+	comment.ContentHTML = ""
+	comment.Edits = nil
+}
+
+// editableUntil returns the time until which the comment can be edited
+// by its author.
+func (comment *AllPostsComment) editableUntil(window time.Duration) time.Time {
+	return comment.createdAt.Add(window)
+}
+
+type commentThread []*AllPostsComment
+
+func (c commentThread) Len() int           { return len(c) }
+func (c commentThread) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c commentThread) Less(i, j int) bool { return c[i].createdAt.Before(c[j].createdAt) }
+
+// count returns the number of comments in the thread, including all
+// replies.
+func (c commentThread) count() int {
+	n := len(c)
+	for _, comment := range c {
+		n += commentThread(comment.Replies).count()
+	}
+	return n
+}
+
 type AllPostsPost struct {
 	Id        string
 	Title     string
@@ -50,15 +107,42 @@ type AllPostsPost struct {
 	Excerpt     string
 	ExcerptHTML template.HTML
 
+	// Outline lists the headings in the content, for showing a table
+	// of contents.
+	Outline []*OutlineHeading
+
+	WordCount int
+
+	// ReadingTime is the estimated time it takes to read the post,
+	// in minutes.
+	ReadingTime int
+
 	Url  *url.URL
 	Slug string
 
+	// ArchiveUrl points to the archive of the month the post has
+	// been published in.
+	ArchiveUrl *url.URL
+
 	Preview bool
 
+	Tags []string
+
+	Series *PostSeriesNav
+
+	// Related lists the posts most similar to this one.
+	Related []*AllPostsPost
+
+	Author *AuthorsAuthor
+
 	Comments []*AllPostsComment
 
 	Changes []*ChangeItem
 
+	// Revisions is only set if the changes made to posts are shown
+	// to readers.
+	Revisions *PostRevisions
+
 	allComments map[string]*AllPostsComment
 	publishedAt time.Time
 }
@@ -75,12 +159,108 @@ func (post *AllPostsPost) addComment(comment *AllPostsComment) {
 	post.allComments[comment.Id] = comment
 }
 
-func (post *AllPostsPost) authenticateComment(id string) {
+// CommentCount returns the number of published comments, including
+// replies.
+func (post *AllPostsPost) CommentCount() int {
+	return commentThread(post.Comments).count()
+}
+
+// threadOf returns the list of comments that comment is displayed in:
+// the replies of its parent or the top-level comments of the post.
+// Replies to deleted comments are not displayed at all, so nil is
+// returned for them.
+func (post *AllPostsPost) threadOf(comment *AllPostsComment) *[]*AllPostsComment {
+	if comment.ParentId == "" {
+		return &post.Comments
+	}
+	if parent := post.allComments[comment.ParentId]; parent != nil {
+		return &parent.Replies
+	}
+
+	return nil
+}
+
+func (post *AllPostsPost) publishComment(id string) {
 	comment := post.allComments[id]
-	if comment != nil {
-		post.Comments = append(post.Comments, comment)
+	if comment == nil {
+		return
+	}
+
+	thread := post.threadOf(comment)
+	if thread == nil {
+		return
+	}
+	for _, published := range *thread {
+		if published == comment {
+			return
+		}
+	}
+
+	*thread = append(*thread, comment)
+	if thread == &post.Comments {
 		sort.Sort(post)
+	} else {
+		sort.Sort(commentThread(*thread))
+	}
+}
+
+func (post *AllPostsPost) unpublishComment(id string) {
+	comment := post.allComments[id]
+	if comment == nil {
+		return
+	}
+
+	thread := post.threadOf(comment)
+	if thread == nil {
+		return
+	}
+	for i, published := range *thread {
+		if published == comment {
+			*thread = append((*thread)[:i], (*thread)[i+1:]...)
+			return
+		}
+	}
+}
+
+func (post *AllPostsPost) withdrawComment(id string) {
+	comment := post.allComments[id]
+	if comment == nil {
+		return
 	}
+
+	comment.withdraw()
+	if len(comment.Replies) == 0 {
+		post.unpublishComment(id)
+	}
+}
+
+func (post *AllPostsPost) deleteComment(id string) {
+	post.unpublishComment(id)
+	delete(post.allComments, id)
+}
+
+func (post *AllPostsPost) addTag(tag string) *AllPostsPost {
+	for _, existing := range post.Tags {
+		if existing == tag {
+			return post
+		}
+	}
+
+	post.Tags = append(post.Tags, tag)
+	sort.Strings(post.Tags)
+
+	return post
+}
+
+func (post *AllPostsPost) removeTag(tag string) *AllPostsPost {
+	for i, existing := range post.Tags {
+		if existing == tag {
+			post.Tags = append(post.Tags[:i], post.Tags[i+1:]...)
+			break
+		}
+	}
+
+	return post
 }
 
 func (post *AllPostsPost) createExcerpt() {
@@ -100,6 +280,7 @@ func (post *AllPostsPost) setContent(content string) *AllPostsPost {
 	post.ContentHTML = textToHTML(content, false)
 
 	post.createExcerpt()
+	post.analyzeContent()
 
 	return post
 }
@@ -124,6 +305,10 @@ func (post *AllPostsPost) RenderHTML() []byte {
 	}
 }
 
+func postUrl(slug string) *url.URL {
+	return &url.URL{Path: "/posts/" + slug + ".html"}
+}
+
 type AllPostsView struct {
 	Collection []*AllPostsPost
 
@@ -145,10 +330,51 @@ func (view *AllPostsView) HandleEvent(event Event) error {
 		view.addPost(evt)
 	case *PostRewordedEvent:
 		view.rewordPost(evt)
+	case *PostTaggedEvent:
+		if post := view.ById(evt.PostId); post != nil {
+			post.addTag(evt.TagName)
+		}
+	case *PostUntaggedEvent:
+		if post := view.ById(evt.PostId); post != nil {
+			post.removeTag(evt.TagName)
+		}
 	case *PostCommentedEvent:
 		view.addCommentToPost(evt)
 	case *PostCommentAuthenticatedEvent:
 		view.authenticateComment(evt)
+	case *CommentEditedEvent:
+		if post := view.ById(evt.PostId); post != nil {
+			if comment := post.allComments[evt.CommentId]; comment != nil {
+				comment.edit(evt.Content, evt.EditedAt)
+			}
+			if evt.PendingApproval {
+				post.unpublishComment(evt.CommentId)
+			}
+		}
+	case *CommentWithdrawnEvent:
+		if post := view.ById(evt.PostId); post != nil {
+			post.withdrawComment(evt.CommentId)
+		}
+	case *PostCommentApprovedEvent:
+		if post := view.ById(evt.PostId); post != nil {
+			post.publishComment(evt.CommentId)
+		}
+	case *PostCommentHiddenEvent:
+		if post := view.ById(evt.PostId); post != nil {
+			post.unpublishComment(evt.CommentId)
+		}
+	case *PostCommentRejectedEvent:
+		if post := view.ById(evt.PostId); post != nil {
+			post.deleteComment(evt.CommentId)
+		}
+	case *PostCommentDeletedEvent:
+		if post := view.ById(evt.PostId); post != nil {
+			post.deleteComment(evt.CommentId)
+		}
+	case *CommentExpiredEvent:
+		if post := view.ById(evt.PostId); post != nil {
+			post.deleteComment(evt.CommentId)
+		}
 	}
 
 	return nil
@@ -171,13 +397,20 @@ func (view *AllPostsView) addPost(evt *PostPublishedEvent) {
 		Comments:    []*AllPostsComment{},
 		Published:   evt.PublishedAt.Format("02 Jan 2006"),
 		Slug:        slug,
-		Url:         &url.URL{Path: "/posts/" + slug + ".html"},
+		Url:         postUrl(slug),
+		ArchiveUrl:  monthArchiveUrl(evt.PublishedAt.Year(), evt.PublishedAt.Month()),
 		Preview:     false,
+		Tags:        []string{},
 
 		allComments: map[string]*AllPostsComment{},
 		publishedAt: evt.PublishedAt,
 	}
 	post.createExcerpt()
+	post.analyzeContent()
+
+	for _, tag := range evt.Tags {
+		post.addTag(tag)
+	}
 
 	view.allPosts[evt.PostId] = post
 	view.allPostsBySlug[slug] = post
@@ -209,10 +442,14 @@ func (view *AllPostsView) BySlug(slug string) *AllPostsPost {
 func (view *AllPostsView) addCommentToPost(evt *PostCommentedEvent) {
 	comment := &AllPostsComment{
 		Id:          evt.CommentId,
+		PostId:      evt.PostId,
+		ParentId:    evt.ParentId,
 		Author:      evt.AuthorName,
 		Content:     evt.Content,
 		Created:     evt.CommentedAt.Format("02 Jan 2006 15:04"),
 		ContentHTML: textToHTML(evt.Content, true),
+		ByAuthor:    evt.ByAuthor,
+		Replies:     []*AllPostsComment{},
 
 		createdAt: evt.CommentedAt,
 	}
@@ -223,7 +460,9 @@ func (view *AllPostsView) addCommentToPost(evt *PostCommentedEvent) {
 
 func (view *AllPostsView) authenticateComment(evt *PostCommentAuthenticatedEvent) {
 	post := view.allPosts[evt.PostId]
-	post.authenticateComment(evt.CommentId)
+	if !evt.PendingApproval {
+		post.publishComment(evt.CommentId)
+	}
 }
 
 func (view *AllPostsView) approveCommentViewFor(postId, commentId string) (*ApproveCommentView, error) {
@@ -243,8 +482,46 @@ func (view *AllPostsView) approveCommentViewFor(postId, commentId string) (*Appr
 	}, nil
 }
 
-func (view *AllPostsView) RenderHTML() []byte {
-	return renderTemplate("views/all_posts.html", view)
+func (view *AllPostsView) RenderHTML(page *Pagination) []byte {
+	return renderTemplate("views/all_posts.html", &struct {
+		*AllPostsView
+		Page *Pagination
+	}{view, page})
+}
+
+func (view *AllPostsView) manageCommentViewFor(postId, commentId, signature string, config PostsConfig) (*ManageCommentView, error) {
+	post := view.allPosts[postId]
+	if post == nil {
+		return nil, ErrNotFound
+	}
+
+	comment := post.allComments[commentId]
+	if comment == nil {
+		return nil, ErrNotFound
+	}
+
+	editableUntil := comment.editableUntil(config.EditWindow)
+	return &ManageCommentView{
+		Post:             post,
+		Comment:          comment,
+		Signature:        signature,
+		Editable:         !comment.Withdrawn && time.Now().Before(editableUntil),
+		EditableUntil:    editableUntil.Format("02 Jan 2006 15:04"),
+		RequiresApproval: config.RequireApproval,
+	}, nil
+}
+
+type ManageCommentView struct {
+	Post             *AllPostsPost
+	Comment          *AllPostsComment
+	Signature        string
+	Editable         bool
+	EditableUntil    string
+	RequiresApproval bool
+}
+
+func (view *ManageCommentView) RenderHTML() []byte {
+	return renderTemplate("views/manage_comment.html", view)
 }
 
 type ApproveCommentView struct {
@@ -266,11 +543,17 @@ type SitemapURL struct {
 
 type Sitemap struct {
 	allPosts *AllPostsView
+	tags     *TagsView
 	baseUrl  *url.URL
 	Urls     []*SitemapURL
+
+	// pages maps page ids to their URLs.
+	pages map[string]*url.URL
 }
 
-func NewSitemap(allPosts *AllPostsView) *Sitemap {
+// baseUrlFromEnv returns the URL under which the blog is reachable
+// from the outside.
+func baseUrlFromEnv() *url.URL {
 	host := os.Getenv("BLOG_TLS_HOST")
 	if host == "" {
 		host = "http://" + os.Getenv("BLOG_HOST")
@@ -286,11 +569,17 @@ func NewSitemap(allPosts *AllPostsView) *Sitemap {
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Printf("Sitemap: host=%q, baseUrl=%#v\n", host, baseUrl)
+	log.Printf("baseUrlFromEnv: host=%q, baseUrl=%#v\n", host, baseUrl)
+	return baseUrl
+}
+
+func NewSitemap(allPosts *AllPostsView, tags *TagsView) *Sitemap {
 	return &Sitemap{
 		allPosts: allPosts,
-		baseUrl:  baseUrl,
+		tags:     tags,
+		baseUrl:  baseUrlFromEnv(),
 		Urls:     []*SitemapURL{},
+		pages:    map[string]*url.URL{},
 	}
 }
 
@@ -302,11 +591,60 @@ func (sitemap *Sitemap) HandleEvent(event Event) error {
 			Loc: sitemap.baseUrl.ResolveReference(post.Url).String(),
 		}
 		sitemap.Urls = append(sitemap.Urls, url)
+		sitemap.add(archiveUrl)
+		sitemap.add(yearArchiveUrl(evt.PublishedAt.Year()))
+		sitemap.add(monthArchiveUrl(evt.PublishedAt.Year(), evt.PublishedAt.Month()))
+		for _, tag := range evt.Tags {
+			sitemap.addTag(tag)
+		}
+	case *SeriesCreatedEvent:
+		sitemap.add(seriesUrl(slugify(evt.Title)))
+	case *PageCreatedEvent:
+		sitemap.pages[evt.PageId] = pageUrl(evt.Slug)
+		sitemap.add(sitemap.pages[evt.PageId])
+	case *PageDeletedEvent:
+		if loc := sitemap.pages[evt.PageId]; loc != nil {
+			sitemap.remove(loc)
+		}
+	case *AuthorRegisteredEvent:
+		sitemap.add(authorUrl(evt.Login))
+	case *PostTaggedEvent:
+		sitemap.addTag(evt.TagName)
+	case *PostUntaggedEvent:
+		if sitemap.tags.ByName(evt.TagName) == nil {
+			sitemap.remove(tagUrl(evt.TagName))
+		}
 	}
 
 	return nil
 }
 
+func (sitemap *Sitemap) addTag(tag string) {
+	sitemap.add(tagCloudUrl)
+	sitemap.add(tagUrl(tag))
+}
+
+func (sitemap *Sitemap) add(loc *url.URL) {
+	abs := sitemap.baseUrl.ResolveReference(loc).String()
+	for _, existing := range sitemap.Urls {
+		if existing.Loc == abs {
+			return
+		}
+	}
+
+	sitemap.Urls = append(sitemap.Urls, &SitemapURL{Loc: abs})
+}
+
+func (sitemap *Sitemap) remove(loc *url.URL) {
+	abs := sitemap.baseUrl.ResolveReference(loc).String()
+	for i, existing := range sitemap.Urls {
+		if existing.Loc == abs {
+			sitemap.Urls = append(sitemap.Urls[:i], sitemap.Urls[i+1:]...)
+			return
+		}
+	}
+}
+
 func (sitemap *Sitemap) RenderXML(w io.Writer) error {
 	fmt.Fprintf(w, "%s\n%s\n",
 		xml.Header,
@@ -340,7 +678,7 @@ func (renderer *userContentRenderer) Image(out *bytes.Buffer, link []byte, title
 }
 
 var (
-	blackfridayAuthor = blackfriday.HtmlRenderer(
+	blackfridayHtml = blackfriday.HtmlRenderer(
 		blackfriday.HTML_USE_XHTML|
 			blackfriday.HTML_USE_SMARTYPANTS|
 			blackfriday.HTML_SMARTYPANTS_FRACTIONS|
@@ -348,13 +686,16 @@ var (
 		"",
 		"",
 	)
+	blackfridayAuthor = &authorContentRenderer{
+		Renderer: blackfridayHtml,
+	}
 	blackfridayUgc = &userContentRenderer{
-		Renderer: blackfridayAuthor,
+		Renderer: blackfridayHtml,
 	}
 )
 
 func textToHTML(text string, userGenerated bool) template.HTML {
-	renderer := blackfridayAuthor
+	var renderer blackfriday.Renderer = blackfridayAuthor
 	if userGenerated {
 		renderer = blackfridayUgc
 	}
@@ -377,8 +718,16 @@ func slugify(str string) string {
 	return slugReplacer.Replace(strings.TrimSpace(strings.ToLower(str)))
 }
 
+// templateFuncs are the functions available in all templates.
+var templateFuncs = template.FuncMap{
+	"commentFormToken": func() string { return "" },
+	"navigation":       func() []*NavigationLink { return nil },
+	"socialLinks":      func() []*NavigationLink { return nil },
+	"tagUrl":           tagUrl,
+}
+
 func renderTemplate(name string, data interface{}) []byte {
-	tmpl, err := template.ParseFiles("views/layout.html", name)
+	tmpl, err := template.New("layout.html").Funcs(templateFuncs).ParseFiles("views/layout.html", name)
 	if err != nil {
 		return []byte(err.Error())
 	}